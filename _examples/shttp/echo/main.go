@@ -0,0 +1,66 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// echo opens a stream to the /echo handler of the shttp example server, sends
+// the lines read from stdin and prints the echoed lines.
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/netsec-ethz/scion-apps/pkg/shttp"
+)
+
+func main() {
+	serverAddrStr := flag.String("s", "", "Server address (<ISD-AS,[IP]> or <hostname>, optionally with appended <:port>)")
+	flag.Parse()
+
+	if len(*serverAddrStr) == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	rt := shttp.NewRoundTripper(nil, nil)
+	defer rt.Close()
+
+	stream, _, err := shttp.DialStream(rt, fmt.Sprintf("https://%s/echo", *serverAddrStr), nil)
+	if err != nil {
+		log.Fatal("Opening stream failed: ", err)
+	}
+	defer stream.Close()
+
+	// print the echoed lines as they arrive
+	done := make(chan struct{})
+	go func() {
+		scanner := bufio.NewScanner(stream)
+		for scanner.Scan() {
+			fmt.Println("echo:", scanner.Text())
+		}
+		close(done)
+	}()
+
+	scanner := bufio.NewScanner(os.Stdin)
+	for scanner.Scan() {
+		if _, err := fmt.Fprintln(stream, scanner.Text()); err != nil {
+			log.Fatal("Sending failed: ", err)
+		}
+	}
+	// signal the end of the input to the server, then wait for the remaining echoes
+	_ = stream.CloseWrite()
+	<-done
+}
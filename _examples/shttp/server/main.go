@@ -18,6 +18,7 @@ import (
 	"encoding/json"
 	"flag"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"time"
@@ -28,6 +29,7 @@ import (
 func main() {
 
 	port := flag.Uint("p", 443, "port the server listens on")
+	tcpPort := flag.Uint("tcp", 0, "port the server additionally listens on over TCP/IP (0 to disable)")
 	flag.Parse()
 
 	m := http.NewServeMux()
@@ -84,5 +86,19 @@ func main() {
 		}
 	})
 
+	// stream handler that echoes everything the client sends
+	m.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		stream, err := shttp.AcceptStream(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer stream.Close()
+		io.Copy(stream, stream)
+	})
+
+	if *tcpPort != 0 {
+		log.Fatal(shttp.ListenAndServeDualStack(fmt.Sprintf(":%d", *port), fmt.Sprintf(":%d", *tcpPort), "", "", m))
+	}
 	log.Fatal(shttp.ListenAndServe(fmt.Sprintf(":%d", *port), m))
 }
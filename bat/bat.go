@@ -36,6 +36,7 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/netsec-ethz/scion-apps/pkg/appnet"
 	"github.com/netsec-ethz/scion-apps/pkg/shttp"
 )
 
@@ -97,7 +98,11 @@ func init() {
 	flag.Usage = usage
 	flag.Parse()
 
-	defaultSetting.Transport = shttp.NewRoundTripper(nil, nil)
+	var pathSelector shttp.PathSelector
+	if interactive {
+		pathSelector = appnet.ChoosePathInteractive
+	}
+	defaultSetting.Transport = shttp.NewRoundTripperWithPathSelector(nil, nil, pathSelector)
 }
 
 func parsePrintOption(s string) {
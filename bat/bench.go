@@ -14,6 +14,7 @@ import (
 	"time"
 
 	"github.com/netsec-ethz/scion-apps/bat/httplib"
+	"github.com/netsec-ethz/scion-apps/pkg/shttp"
 )
 
 type result struct {
@@ -44,6 +45,25 @@ func RunBench(b *httplib.BeegoHttpRequest) {
 	wg.Wait()
 	printReport(benchN, results, "", time.Now().Sub(start))
 	close(results)
+	if rt, ok := defaultSetting.Transport.(shttp.RoundTripper); ok {
+		printSessions(rt.Sessions())
+	}
+}
+
+// printSessions prints the QUIC sessions that were used for the benchmark.
+func printSessions(sessions []shttp.SessionInfo) {
+	fmt.Printf("\nSessions:\n")
+	for _, s := range sessions {
+		path := "default path"
+		if s.Path != nil {
+			path = fmt.Sprintf("path %s", s.Path)
+		}
+		remote := "not established"
+		if s.Remote != nil {
+			remote = s.Remote.String()
+		}
+		fmt.Printf("  %s (%s) over %s, %d active requests\n", s.Host, remote, path, s.ActiveRequests)
+	}
 }
 
 func worker(wg *sync.WaitGroup, ch chan int, results chan *result, b *httplib.BeegoHttpRequest) {
@@ -0,0 +1,324 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"crypto/subtle"
+	"fmt"
+	"html/template"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// files smaller than this are not compressed
+	gzipMinSize = 1024
+)
+
+// fileHandler serves the files in root. Directories are served as listings,
+// unless they contain an index.html.
+type fileHandler struct {
+	root string
+	// user and password for basic auth; no authentication if user is empty
+	user, password string
+	// allowUpload enables uploads with PUT
+	allowUpload bool
+	// maxUploadSize limits the size of uploaded files, in bytes
+	maxUploadSize int64
+}
+
+func (h *fileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.user != "" && !h.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Basic realm="fileserver"`)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+
+	urlPath := path.Clean("/" + r.URL.Path)
+	name := filepath.Join(h.root, filepath.FromSlash(urlPath))
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		h.serveGet(w, r, urlPath, name)
+	case http.MethodPut:
+		if !h.allowUpload {
+			http.Error(w, "uploads are disabled", http.StatusMethodNotAllowed)
+			return
+		}
+		h.servePut(w, r, name)
+	default:
+		allow := "GET, HEAD"
+		if h.allowUpload {
+			allow += ", PUT"
+		}
+		w.Header().Set("Allow", allow)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
+
+// authorized checks the basic auth credentials of the request.
+func (h *fileHandler) authorized(r *http.Request) bool {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return false
+	}
+	userOK := subtle.ConstantTimeCompare([]byte(user), []byte(h.user)) == 1
+	passwordOK := subtle.ConstantTimeCompare([]byte(password), []byte(h.password)) == 1
+	return userOK && passwordOK
+}
+
+func (h *fileHandler) serveGet(w http.ResponseWriter, r *http.Request, urlPath, name string) {
+	f, err := os.Open(name)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+
+	if info.IsDir() {
+		// redirect to canonical path, so that relative links in the listing work
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			http.Redirect(w, r, path.Base(urlPath)+"/", http.StatusMovedPermanently)
+			return
+		}
+		index, err := os.Open(filepath.Join(name, "index.html"))
+		if err != nil {
+			serveListing(w, r, urlPath, f)
+			return
+		}
+		defer index.Close()
+		f = index
+		info, err = index.Stat()
+		if err != nil {
+			serveError(w, err)
+			return
+		}
+	}
+
+	// http.ServeContent handles Range requests and conditional requests based on
+	// the ETag and the modification time.
+	etag := fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+	if acceptsGzip(r) && r.Header.Get("Range") == "" &&
+		info.Size() >= gzipMinSize && compressible(info.Name()) {
+		// The compressed representation needs its own ETag
+		w.Header().Set("ETag", strings.TrimSuffix(etag, `"`)+`-gzip"`)
+		w.Header().Add("Vary", "Accept-Encoding")
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		w = gw
+	} else {
+		w.Header().Set("ETag", etag)
+	}
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// servePut stores the request body in the file name. The file is written to a
+// temporary file first, so that concurrent readers never see a partial file.
+func (h *fileHandler) servePut(w http.ResponseWriter, r *http.Request, name string) {
+	if strings.HasSuffix(r.URL.Path, "/") {
+		http.Error(w, "cannot upload to a directory", http.StatusBadRequest)
+		return
+	}
+	if r.ContentLength > h.maxUploadSize {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	_, err := os.Stat(name)
+	existed := err == nil
+
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		serveError(w, err)
+		return
+	}
+	tmp, err := ioutil.TempFile(dir, ".upload-")
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	defer os.Remove(tmp.Name()) // no-op after the rename succeeded
+
+	n, err := io.Copy(tmp, io.LimitReader(r.Body, h.maxUploadSize+1))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if n > h.maxUploadSize {
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		serveError(w, err)
+		return
+	}
+	if err := os.Rename(tmp.Name(), name); err != nil {
+		serveError(w, err)
+		return
+	}
+
+	if existed {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// serveError responds with a status code matching the file system error err.
+func serveError(w http.ResponseWriter, err error) {
+	switch {
+	case os.IsNotExist(err):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case os.IsPermission(err):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+var listingTemplate = template.Must(template.New("listing").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Index of {{.Path}}</title></head>
+<body>
+<h1>Index of {{.Path}}</h1>
+<table>
+<tr><th align="left">Name</th><th align="right">Size</th><th align="left">Modified</th></tr>
+{{- if ne .Path "/"}}
+<tr><td><a href="../">../</a></td><td></td><td></td></tr>
+{{- end}}
+{{- range .Entries}}
+<tr><td><a href="{{.Href}}">{{.Name}}</a></td><td align="right">{{.Size}}</td><td>{{.ModTime}}</td></tr>
+{{- end}}
+</table>
+</body>
+</html>
+`))
+
+type listingEntry struct {
+	Name    string
+	Href    string
+	Size    string
+	ModTime string
+}
+
+// serveListing responds with an HTML listing of the directory dir.
+func serveListing(w http.ResponseWriter, r *http.Request, urlPath string, dir *os.File) {
+	infos, err := dir.Readdir(-1)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		return infos[i].Name() < infos[j].Name()
+	})
+
+	entries := make([]listingEntry, 0, len(infos))
+	for _, info := range infos {
+		name := info.Name()
+		size := fmt.Sprintf("%d", info.Size())
+		if info.IsDir() {
+			name += "/"
+			size = "-"
+		}
+		entries = append(entries, listingEntry{
+			Name:    name,
+			Href:    (&url.URL{Path: name}).String(),
+			Size:    size,
+			ModTime: info.ModTime().UTC().Format(time.RFC3339),
+		})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if r.Method == http.MethodHead {
+		return
+	}
+	_ = listingTemplate.Execute(w, struct {
+		Path    string
+		Entries []listingEntry
+	}{urlPath, entries})
+}
+
+// acceptsGzip returns true if the client accepts gzip encoded responses.
+func acceptsGzip(r *http.Request) bool {
+	for _, enc := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		enc = strings.TrimSpace(enc)
+		if enc == "gzip" || strings.HasPrefix(enc, "gzip;") && !strings.HasSuffix(enc, "q=0") {
+			return true
+		}
+	}
+	return false
+}
+
+// compressible returns true for files with a text-like content type, based on
+// the extension.
+func compressible(name string) bool {
+	ctype := mime.TypeByExtension(filepath.Ext(name))
+	if i := strings.Index(ctype, ";"); i >= 0 {
+		ctype = ctype[:i]
+	}
+	switch {
+	case strings.HasPrefix(ctype, "text/"):
+		return true
+	case strings.HasSuffix(ctype, "json"), strings.HasSuffix(ctype, "xml"), strings.HasSuffix(ctype, "javascript"):
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter compresses successful (200 OK) responses.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if code == http.StatusOK {
+		w.Header().Del("Content-Length")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.gz = gzip.NewWriter(w.ResponseWriter)
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if w.gz != nil {
+		return w.gz.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *gzipResponseWriter) Close() error {
+	if w.gz != nil {
+		return w.gz.Close()
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestHandler(t *testing.T) (*fileHandler, func()) {
+	root, err := ioutil.TempDir("", "fileserver")
+	if err != nil {
+		t.Fatalf("unable to create temp dir: %s", err)
+	}
+	files := map[string]string{
+		"hello.txt":     "hello world",
+		"large.txt":     strings.Repeat("compress me ", 1000),
+		"sub/inner.txt": "inner",
+	}
+	for name, content := range files {
+		p := filepath.Join(root, filepath.FromSlash(name))
+		_ = os.MkdirAll(filepath.Dir(p), 0755)
+		if err := ioutil.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatalf("unable to write test file: %s", err)
+		}
+	}
+	h := &fileHandler{root: root, maxUploadSize: 1024}
+	return h, func() { os.RemoveAll(root) }
+}
+
+func serve(h http.Handler, method, target string, header http.Header, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w
+}
+
+func TestGet(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	testCases := []struct {
+		Target   string
+		Header   http.Header
+		Status   int
+		Expected string
+	}{
+		{"/hello.txt", nil, http.StatusOK, "hello world"},
+		{"/sub/inner.txt", nil, http.StatusOK, "inner"},
+		{"/../hello.txt", nil, http.StatusOK, "hello world"},
+		{"/missing.txt", nil, http.StatusNotFound, ""},
+		{"/hello.txt", http.Header{"Range": {"bytes=6-"}}, http.StatusPartialContent, "world"},
+		{"/sub", nil, http.StatusMovedPermanently, ""},
+		{"/", nil, http.StatusOK, `<a href="hello.txt">hello.txt</a>`},
+		{"/", nil, http.StatusOK, `<a href="sub/">sub/</a>`},
+	}
+	for _, tc := range testCases {
+		w := serve(h, http.MethodGet, tc.Target, tc.Header, "")
+		if w.Code != tc.Status {
+			t.Fatalf("unexpected status for %s, actual='%d', expected='%d'", tc.Target, w.Code, tc.Status)
+		}
+		if !strings.Contains(w.Body.String(), tc.Expected) {
+			t.Fatalf("unexpected body for %s, actual='%s', expected='%s'", tc.Target, w.Body.String(), tc.Expected)
+		}
+	}
+}
+
+func TestETag(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	w := serve(h, http.MethodGet, "/hello.txt", nil, "")
+	etag := w.Header().Get("ETag")
+	if etag == "" {
+		t.Fatalf("no ETag in response")
+	}
+	w = serve(h, http.MethodGet, "/hello.txt", http.Header{"If-None-Match": {etag}}, "")
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("unexpected status, actual='%d', expected='%d'", w.Code, http.StatusNotModified)
+	}
+}
+
+func TestGzip(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	w := serve(h, http.MethodGet, "/large.txt", http.Header{"Accept-Encoding": {"gzip"}}, "")
+	if enc := w.Header().Get("Content-Encoding"); enc != "gzip" {
+		t.Fatalf("unexpected Content-Encoding, actual='%s', expected='gzip'", enc)
+	}
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("invalid gzip response: %s", err)
+	}
+	body, err := ioutil.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("invalid gzip response: %s", err)
+	}
+	if string(body) != strings.Repeat("compress me ", 1000) {
+		t.Fatalf("unexpected decompressed body")
+	}
+
+	// small files are not compressed
+	w = serve(h, http.MethodGet, "/hello.txt", http.Header{"Accept-Encoding": {"gzip"}}, "")
+	if enc := w.Header().Get("Content-Encoding"); enc != "" {
+		t.Fatalf("unexpected Content-Encoding, actual='%s', expected=''", enc)
+	}
+}
+
+func TestBasicAuth(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+	h.user, h.password = "user", "secret"
+
+	w := serve(h, http.MethodGet, "/hello.txt", nil, "")
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("unexpected status, actual='%d', expected='%d'", w.Code, http.StatusUnauthorized)
+	}
+	req := httptest.NewRequest(http.MethodGet, "/hello.txt", nil)
+	req.SetBasicAuth("user", "secret")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status, actual='%d', expected='%d'", rec.Code, http.StatusOK)
+	}
+}
+
+func TestPut(t *testing.T) {
+	h, cleanup := newTestHandler(t)
+	defer cleanup()
+
+	w := serve(h, http.MethodPut, "/new.txt", nil, "uploaded")
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("unexpected status, actual='%d', expected='%d'", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	h.allowUpload = true
+	testCases := []struct {
+		Target string
+		Body   string
+		Status int
+	}{
+		{"/new.txt", "uploaded", http.StatusCreated},
+		{"/new.txt", "replaced", http.StatusNoContent},
+		{"/newdir/new.txt", "nested", http.StatusCreated},
+		{"/big.txt", strings.Repeat("x", 2048), http.StatusRequestEntityTooLarge},
+	}
+	for _, tc := range testCases {
+		w := serve(h, http.MethodPut, tc.Target, nil, tc.Body)
+		if w.Code != tc.Status {
+			t.Fatalf("unexpected status for %s, actual='%d', expected='%d'", tc.Target, w.Code, tc.Status)
+		}
+		if tc.Status == http.StatusRequestEntityTooLarge {
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(h.root, filepath.FromSlash(tc.Target)))
+		if err != nil || string(content) != tc.Body {
+			t.Fatalf("unexpected file content for %s, actual='%s', expected='%s'", tc.Target, content, tc.Body)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(h.root, "big.txt")); !os.IsNotExist(err) {
+		t.Fatalf("file exceeding the size limit was stored")
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// fileserver serves the files in a directory over HTTP/3 over SCION.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/netsec-ethz/scion-apps/pkg/shttp"
+)
+
+func main() {
+	port := flag.Uint("p", 443, "Port the server listens on (SCION/UDP)")
+	root := flag.String("root", ".", "Directory to serve")
+	auth := flag.String("auth", "", "Require HTTP basic authentication with USER:PASS")
+	upload := flag.Bool("upload", false, "Allow uploading files with PUT")
+	maxUpload := flag.Int64("max-upload", 1<<30, "Maximum size of uploaded files, in bytes")
+	verbose := flag.Bool("v", false, "Log every request")
+	flag.Parse()
+
+	if info, err := os.Stat(*root); err != nil || !info.IsDir() {
+		log.Fatalf("Invalid root directory %q", *root)
+	}
+	handler := &fileHandler{
+		root:          *root,
+		allowUpload:   *upload,
+		maxUploadSize: *maxUpload,
+	}
+	if *auth != "" {
+		parts := strings.SplitN(*auth, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			fmt.Fprintln(os.Stderr, "Invalid -auth, expected USER:PASS")
+			os.Exit(2)
+		}
+		handler.user, handler.password = parts[0], parts[1]
+	}
+	if *upload && *auth == "" {
+		log.Printf("Warning: uploads are enabled without authentication")
+	}
+
+	var h http.Handler = handler
+	if *verbose {
+		h = logRequests(h)
+	}
+
+	log.Printf("Serving %s on port %d", *root, *port)
+	log.Fatal(shttp.ListenAndServe(fmt.Sprintf(":%d", *port), h))
+}
+
+// logRequests logs each request, including the client's SCION address
+func logRequests(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("%s %s %s", r.RemoteAddr, r.Method, r.URL)
+		handler.ServeHTTP(w, r)
+	})
+}
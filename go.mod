@@ -8,10 +8,12 @@ require (
 	github.com/kormat/fmt15 v0.0.0-20181112140556-ee69fecb2656
 	github.com/kr/pty v1.1.8
 	github.com/lucas-clemente/quic-go v0.15.5
+	github.com/marten-seemann/qpack v0.1.0
 	github.com/mattn/go-sqlite3 v1.9.1-0.20180719091609-b3511bfdd742
 	github.com/msteinert/pam v0.0.0-20190215180659-f29b9f28d6f9
 	github.com/netsec-ethz/rains v0.1.0
 	github.com/netsec-ethz/scion-apps v0.1.0
+	github.com/pkg/sftp v1.11.0
 	github.com/scionproto/scion v0.5.0
 	github.com/smartystreets/goconvey v1.6.4
 	golang.org/x/crypto v0.0.0-20200423211502-4bdfaf469ed5
@@ -20,6 +20,7 @@ package appquic
 import (
 	"crypto/tls"
 	"fmt"
+	"net"
 	"sync"
 
 	"github.com/lucas-clemente/quic-go"
@@ -44,9 +45,22 @@ type closerSession struct {
 	conn *snet.Conn
 }
 
-func (s *closerSession) Close() error {
+func (s *closerSession) CloseWithError(code quic.ErrorCode, desc string) error {
+	err := s.Session.CloseWithError(code, desc)
 	s.conn.Close()
-	return s.Session.Close()
+	return err
+}
+
+// closerEarlySession is the quic.EarlySession equivalent of closerSession.
+type closerEarlySession struct {
+	quic.EarlySession
+	conn *snet.Conn
+}
+
+func (s *closerEarlySession) CloseWithError(code quic.ErrorCode, desc string) error {
+	err := s.EarlySession.CloseWithError(code, desc)
+	s.conn.Close()
+	return err
 }
 
 // Dial establishes a new QUIC connection to a server at the remote address.
@@ -85,11 +99,49 @@ func DialAddr(raddr *snet.UDPAddr, tlsConf *tls.Config, quicConf *quic.Config) (
 	return &closerSession{session, sconn}, nil
 }
 
-// ListenPort listens for QUIC connections on a SCION/UDP port.
+// DialEarly establishes a new 0-RTT QUIC connection to a server at the remote address.
+// This is analogous to Dial, but returns the session before the handshake is
+// complete, as required e.g. by the quic-go HTTP/3 client.
+func DialEarly(remote string, tlsConf *tls.Config, quicConf *quic.Config) (quic.EarlySession, error) {
+	raddr, err := appnet.ResolveUDPAddr(remote)
+	if err != nil {
+		return nil, err
+	}
+	return DialAddrEarly(raddr, tlsConf, quicConf)
+}
+
+// DialAddrEarly establishes a new 0-RTT QUIC connection to a server at the remote address.
+//
+// If no path is specified in raddr, DialAddrEarly will choose the first available path,
+// analogous to appnet.DialAddr.
+func DialAddrEarly(raddr *snet.UDPAddr, tlsConf *tls.Config, quicConf *quic.Config) (quic.EarlySession, error) {
+	if raddr.Path == nil {
+		err := appnet.SetDefaultPath(raddr)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sconn, err := appnet.Listen(nil)
+	if err != nil {
+		return nil, err
+	}
+	if tlsConf == nil {
+		tlsConf = cliTLSCfg
+	}
+	session, err := quic.DialEarly(sconn, raddr, "host:0", tlsConf, quicConf)
+	if err != nil {
+		return nil, err
+	}
+	// quic.DialEarly is declared to return a quic.Session, but the session always
+	// is an EarlySession.
+	return &closerEarlySession{session.(quic.EarlySession), sconn}, nil
+}
+
+// Listen listens for QUIC connections on a SCION/UDP address.
 //
 // See note on wildcard addresses in the appnet package documentation.
-func ListenPort(port uint16, tlsConf *tls.Config, quicConfig *quic.Config) (quic.Listener, error) {
-	sconn, err := appnet.ListenPort(port)
+func Listen(listen *net.UDPAddr, tlsConf *tls.Config, quicConfig *quic.Config) (quic.Listener, error) {
+	sconn, err := appnet.Listen(listen)
 	if err != nil {
 		return nil, err
 	}
@@ -102,6 +154,13 @@ func ListenPort(port uint16, tlsConf *tls.Config, quicConfig *quic.Config) (quic
 	return quic.Listen(sconn, tlsConf, quicConfig)
 }
 
+// ListenPort listens for QUIC connections on a SCION/UDP port.
+//
+// See note on wildcard addresses in the appnet package documentation.
+func ListenPort(port uint16, tlsConf *tls.Config, quicConfig *quic.Config) (quic.Listener, error) {
+	return Listen(&net.UDPAddr{Port: int(port)}, tlsConf, quicConfig)
+}
+
 // GetDummyTLSConfig returns the (singleton) default server TLS config with a fresh
 // private key and a dummy certificate.
 func GetDummyTLSConfig() (*tls.Config, error) {
@@ -0,0 +1,156 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shttp
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/lucas-clemente/quic-go/http3"
+	"github.com/netsec-ethz/scion-apps/pkg/appnet"
+	"github.com/netsec-ethz/scion-apps/pkg/appnet/appquic"
+	"github.com/scionproto/scion/go/lib/snet"
+)
+
+// AltSvcProtocolID is the protocol identifier used in the Alt-Svc header to
+// advertise the SCION endpoint of a DualStackServer to TCP/IP clients.
+// The alternative authority is a SCION address, mangled as by
+// MangleSCIONAddrURL, e.g.:
+//  Alt-Svc: h3-scion="[1-ff00:0:110,10.0.0.1]:443"; ma=2592000
+const AltSvcProtocolID = "h3-scion"
+
+// DualStackServer serves the same http.Handler over SCION (HTTP/3 over QUIC)
+// and over TCP/IP (HTTPS over TCP).
+// Responses sent over TCP/IP carry an Alt-Svc header advertising the SCION
+// endpoint.
+type DualStackServer struct {
+	// SCIONServer serves requests over SCION; its Addr is the local SCION/UDP
+	// listen address.
+	SCIONServer *Server
+	// TCPServer serves requests over TCP/IP; its Addr is the local TCP
+	// listen address.
+	TCPServer *http.Server
+
+	altSvc atomic.Value // string
+}
+
+// ListenAndServeDualStack listens for HTTPS connections on the SCION address
+// scionAddr and on the TCP address tcpAddr and serves requests on both with
+// handler.
+// certFile and keyFile are used for the TCP/TLS listener; if they are empty, a
+// dummy self-signed certificate is used.
+func ListenAndServeDualStack(scionAddr, tcpAddr, certFile, keyFile string, handler http.Handler) error {
+	return NewDualStackServer(scionAddr, tcpAddr, handler).ListenAndServeTLS(certFile, keyFile)
+}
+
+// NewDualStackServer creates a DualStackServer listening on the SCION address
+// scionAddr and on the TCP address tcpAddr.
+func NewDualStackServer(scionAddr, tcpAddr string, handler http.Handler) *DualStackServer {
+	if handler == nil {
+		handler = http.DefaultServeMux
+	}
+	srv := &DualStackServer{
+		SCIONServer: &Server{
+			Server: &http3.Server{
+				Server: &http.Server{
+					Addr:    scionAddr,
+					Handler: handler,
+				},
+			},
+		},
+	}
+	srv.TCPServer = &http.Server{
+		Addr:    tcpAddr,
+		Handler: srv.advertiseSCION(handler),
+	}
+	return srv
+}
+
+// ListenAndServeTLS listens on both srv.SCIONServer.Addr and srv.TCPServer.Addr
+// and serves requests until one of the servers fails.
+// certFile and keyFile are used for the TCP/TLS listener, analogous to
+// http.Server.ListenAndServeTLS; if they are empty and srv.TCPServer.TLSConfig
+// contains no certificates, a dummy self-signed certificate is used.
+func (srv *DualStackServer) ListenAndServeTLS(certFile, keyFile string) error {
+
+	laddr, err := net.ResolveUDPAddr("udp", srv.SCIONServer.Addr)
+	if err != nil {
+		return err
+	}
+	sconn, err := appnet.Listen(laddr)
+	if err != nil {
+		return err
+	}
+	defer sconn.Close()
+
+	if certFile == "" && keyFile == "" &&
+		(srv.TCPServer.TLSConfig == nil || len(srv.TCPServer.TLSConfig.Certificates) == 0) {
+		cfg, err := appquic.GetDummyTLSConfig()
+		if err != nil {
+			return err
+		}
+		srv.TCPServer.TLSConfig = &tls.Config{Certificates: cfg.Certificates}
+	}
+	tcpListener, err := net.Listen("tcp", srv.TCPServer.Addr)
+	if err != nil {
+		return err
+	}
+
+	srv.altSvc.Store(altSvcHeaderValue(&snet.UDPAddr{
+		IA:   appnet.DefNetwork().IA,
+		Host: sconn.LocalAddr().(*net.UDPAddr),
+	}))
+
+	errs := make(chan error, 2)
+	go func() {
+		errs <- srv.SCIONServer.Serve(sconn)
+	}()
+	go func() {
+		errs <- srv.TCPServer.ServeTLS(tcpListener, certFile, keyFile)
+	}()
+	err = <-errs
+	srv.Close()
+	return err
+}
+
+// Close immediately closes both the SCION and the TCP/IP server.
+func (srv *DualStackServer) Close() error {
+	errSCION := srv.SCIONServer.Close()
+	errTCP := srv.TCPServer.Close()
+	if errSCION != nil {
+		return errSCION
+	}
+	return errTCP
+}
+
+// advertiseSCION wraps handler to add the Alt-Svc header advertising the SCION
+// endpoint, once it is known, to every response.
+func (srv *DualStackServer) advertiseSCION(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if altSvc, ok := srv.altSvc.Load().(string); ok {
+			w.Header().Add("Alt-Svc", altSvc)
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// altSvcHeaderValue returns the Alt-Svc header value advertising the SCION
+// address addr.
+func altSvcHeaderValue(addr *snet.UDPAddr) string {
+	return fmt.Sprintf(`%s="%s"; ma=2592000`, AltSvcProtocolID, mangleSCIONAddr(addr.String()))
+}
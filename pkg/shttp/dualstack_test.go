@@ -0,0 +1,56 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/scionproto/scion/go/lib/snet"
+)
+
+func TestAltSvcHeader(t *testing.T) {
+	testCases := []struct {
+		Addr     string
+		Expected string
+	}{
+		{"1-ff00:0:110,127.0.0.1:443", `h3-scion="[1-ff00:0:110,127.0.0.1]:443"; ma=2592000`},
+		{"1-ff00:0:110,[::1]:8443", `h3-scion="[1-ff00:0:110,::1]:8443"; ma=2592000`},
+	}
+
+	for _, tc := range testCases {
+		addr, err := snet.ParseUDPAddr(tc.Addr)
+		if err != nil {
+			t.Fatalf("unexpected error parsing '%s': %s", tc.Addr, err)
+		}
+
+		srv := NewDualStackServer("", "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+		// Before the SCION listener is set up, nothing is advertised
+		rec := httptest.NewRecorder()
+		srv.TCPServer.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if actual := rec.Header().Get("Alt-Svc"); actual != "" {
+			t.Fatalf("unexpected Alt-Svc header before listening, actual='%s'", actual)
+		}
+
+		srv.altSvc.Store(altSvcHeaderValue(addr))
+		rec = httptest.NewRecorder()
+		srv.TCPServer.Handler.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+		if actual := rec.Header().Get("Alt-Svc"); actual != tc.Expected {
+			t.Fatalf("unexpected Alt-Svc header, actual='%s', expected='%s'", actual, tc.Expected)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shttp
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/http3"
+	"github.com/scionproto/scion/go/lib/snet"
+	"github.com/scionproto/scion/go/lib/spath"
+)
+
+// packetPipe connects two packetPipeConns in memory. Packets are delivered
+// with the sender's SCION address as source, like a snet.Conn does, so that
+// both the client and the server side of shttp can be tested without a SCION
+// dispatcher.
+type packetPipe struct {
+	a, b *packetPipeConn
+}
+
+type pipePacket struct {
+	data []byte
+	from *snet.UDPAddr
+}
+
+// newPacketPipe creates a pipe between the SCION addresses a and b.
+func newPacketPipe(a, b *snet.UDPAddr) *packetPipe {
+	p := &packetPipe{
+		a: newPacketPipeConn(a),
+		b: newPacketPipeConn(b),
+	}
+	p.a.peer = p.b
+	p.b.peer = p.a
+	return p
+}
+
+// packetPipeConn is a net.PacketConn that is one end of a packetPipe.
+type packetPipeConn struct {
+	local *snet.UDPAddr
+	peer  *packetPipeConn
+	queue chan pipePacket
+
+	mutex        sync.Mutex
+	closed       chan struct{}
+	closeOnce    sync.Once
+	readDeadline time.Time
+}
+
+func newPacketPipeConn(local *snet.UDPAddr) *packetPipeConn {
+	return &packetPipeConn{
+		local:  local,
+		queue:  make(chan pipePacket, 1024),
+		closed: make(chan struct{}),
+	}
+}
+
+func (c *packetPipeConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.mutex.Lock()
+	deadline := c.readDeadline
+	c.mutex.Unlock()
+	var timeout <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case p := <-c.queue:
+		return copy(b, p.data), p.from.Copy(), nil
+	case <-c.closed:
+		return 0, nil, errors.New("use of closed packet pipe")
+	case <-timeout:
+		return 0, nil, timeoutError{}
+	}
+}
+
+func (c *packetPipeConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, errors.New("use of closed packet pipe")
+	default:
+	}
+	if addr.String() != c.peer.local.String() {
+		return 0, fmt.Errorf("no route to %s", addr)
+	}
+	data := make([]byte, len(b))
+	copy(data, b)
+	select {
+	case c.peer.queue <- pipePacket{data: data, from: c.local}:
+	default:
+		// queue full, drop the packet like a real network would
+	}
+	return len(b), nil
+}
+
+func (c *packetPipeConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	return nil
+}
+
+func (c *packetPipeConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+func (c *packetPipeConn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *packetPipeConn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.readDeadline = t
+	return nil
+}
+
+func (c *packetPipeConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// pipeDialer returns a DialFunc that dials the QUIC session over conn.
+func pipeDialer(conn net.PacketConn) DialFunc {
+	return func(raddr *snet.UDPAddr, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlySession, error) {
+		session, err := quic.DialEarly(conn, raddr, "host:0", tlsCfg, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return session.(quic.EarlySession), nil
+	}
+}
+
+// testSCIONPath returns a raw path with a single segment of two hops.
+func testSCIONPath() *spath.Path {
+	raw := make([]byte, spath.InfoFieldLength+2*spath.HopFieldLength)
+	(&spath.InfoField{ISD: 1, Hops: 2}).Write(raw)
+	(&spath.HopField{ConsIngress: 0, ConsEgress: 2}).Write(raw[spath.InfoFieldLength:])
+	(&spath.HopField{ConsIngress: 5, ConsEgress: 0}).Write(raw[spath.InfoFieldLength+spath.HopFieldLength:])
+	return spath.New(raw)
+}
+
+func TestRoundTripOverPacketPipe(t *testing.T) {
+	clientAddr, _ := snet.ParseUDPAddr("1-ff00:0:111,10.0.0.1:40000")
+	clientAddr.Path = testSCIONPath()
+	serverAddr, _ := snet.ParseUDPAddr("1-ff00:0:110,10.0.0.2:443")
+	pipe := newPacketPipe(clientAddr, serverAddr)
+	defer pipe.a.Close()
+	defer pipe.b.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s via %s", RemoteAddr(r), RemotePath(r))
+	})
+	server := &Server{
+		Server: &http3.Server{
+			Server: &http.Server{Handler: mux},
+		},
+	}
+	go func() {
+		_ = server.Serve(pipe.b)
+	}()
+	defer server.Close()
+
+	rt := NewRoundTripperWithConfig(&RoundTripperConfig{
+		Dial: pipeDialer(pipe.a),
+	})
+	defer rt.Close()
+	c := &http.Client{Transport: rt, Timeout: 10 * time.Second}
+
+	resp, err := c.Get(MangleSCIONAddrURL("https://" + serverAddr.String() + "/whoami"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatalf("unexpected error reading body: %s", err)
+	}
+	expected := fmt.Sprintf("%s via %s", clientAddr, describePath(clientAddr.Path))
+	if string(body) != expected {
+		t.Fatalf("unexpected body, actual='%s', expected='%s'", body, expected)
+	}
+
+	sessions := rt.Sessions()
+	if len(sessions) != 1 {
+		t.Fatalf("unexpected number of sessions, actual='%d', expected='1'", len(sessions))
+	}
+	if sessions[0].Remote == nil || sessions[0].Remote.String() != serverAddr.String() {
+		t.Fatalf("unexpected remote of session, actual='%v', expected='%s'", sessions[0].Remote, serverAddr)
+	}
+	if sessions[0].ActiveRequests != 0 {
+		t.Fatalf("unexpected number of active requests, actual='%d', expected='0'", sessions[0].ActiveRequests)
+	}
+}
@@ -0,0 +1,283 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shttp
+
+import (
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/http3"
+	"github.com/scionproto/scion/go/lib/snet"
+)
+
+// ErrTooManySessions is returned by a RoundTripper if a request requires a new
+// session to a host, but PoolConfig.MaxSessionsPerHost sessions to this host
+// are already open and none of them is idle.
+var ErrTooManySessions = errors.New("shttp: too many sessions to host")
+
+// PoolConfig configures the pool of QUIC sessions of a RoundTripper.
+// The zero value means that sessions are kept open until the RoundTripper is
+// closed, without limits.
+type PoolConfig struct {
+	// MaxSessionsPerHost limits the number of open sessions to a host. As a
+	// separate session is used for each path, this limits the number of paths
+	// used for a host. If the limit is reached, the least recently used idle
+	// session to the host is closed to make room for a new one.
+	// Zero means no limit.
+	MaxSessionsPerHost int
+	// IdleTimeout is the time after which a session without running requests
+	// is closed. Zero means no timeout.
+	IdleTimeout time.Duration
+}
+
+// SessionInfo describes a session in the pool of a RoundTripper.
+type SessionInfo struct {
+	// Host is the host:port that the session was opened for.
+	Host string
+	// Remote is the address of the server, including the path. Nil if the
+	// session has not been established yet.
+	Remote *snet.UDPAddr
+	// Path is the path selected for the session, nil for the default path.
+	Path snet.Path
+	// ActiveRequests is the number of requests whose response body has not
+	// yet been closed.
+	ActiveRequests int
+	// LastUsed is the time at which the most recent request was started or
+	// finished.
+	LastUsed time.Time
+}
+
+// pooledSession is an entry in the sessionPool. Each pooledSession wraps an
+// http3.RoundTripper that holds at most one QUIC session.
+type pooledSession struct {
+	key      sessionKey
+	path     snet.Path
+	rt       *http3.RoundTripper
+	dialOnce sync.Once
+
+	// The following fields are protected by the sessionPool mutex
+	session  quic.EarlySession
+	remote   *snet.UDPAddr
+	dialErr  error
+	active   int
+	lastUsed time.Time
+}
+
+// healthy returns false if the session could not be established or has been
+// closed. The http3.RoundTripper would otherwise keep using it.
+// A session that is still being established is considered healthy.
+func (s *pooledSession) healthy() bool {
+	if s.dialErr != nil {
+		return false
+	}
+	return s.session == nil || s.session.Context().Err() == nil
+}
+
+func (s *pooledSession) idle(now time.Time, timeout time.Duration) bool {
+	return s.active == 0 && now.Sub(s.lastUsed) >= timeout
+}
+
+// sessionPool keeps track of the sessions of a roundTripper and enforces the
+// PoolConfig.
+type sessionPool struct {
+	config PoolConfig
+	// newRoundTripper creates the http3.RoundTripper for a new pooledSession
+	newRoundTripper func(s *pooledSession) *http3.RoundTripper
+
+	mutex     sync.Mutex
+	sessions  map[sessionKey]*pooledSession
+	idleTimer *time.Timer
+}
+
+func newSessionPool(config PoolConfig, newRoundTripper func(s *pooledSession) *http3.RoundTripper) *sessionPool {
+	return &sessionPool{
+		config:          config,
+		newRoundTripper: newRoundTripper,
+		sessions:        make(map[sessionKey]*pooledSession),
+	}
+}
+
+// get returns a healthy session for key, creating it if necessary. The
+// session is marked as active until release is called.
+func (p *sessionPool) get(key sessionKey, path snet.Path) (*pooledSession, error) {
+	now := time.Now()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if s, ok := p.sessions[key]; ok {
+		if s.healthy() {
+			s.active++
+			s.lastUsed = now
+			return s, nil
+		}
+		p.removeLocked(s)
+	}
+
+	if p.config.MaxSessionsPerHost > 0 {
+		var count int
+		var lru *pooledSession
+		for _, s := range p.sessions {
+			if s.key.authority != key.authority {
+				continue
+			}
+			count++
+			if s.active == 0 && (lru == nil || s.lastUsed.Before(lru.lastUsed)) {
+				lru = s
+			}
+		}
+		if count >= p.config.MaxSessionsPerHost {
+			if lru == nil {
+				return nil, ErrTooManySessions
+			}
+			p.removeLocked(lru)
+		}
+	}
+
+	s := &pooledSession{
+		key:      key,
+		path:     path,
+		active:   1,
+		lastUsed: now,
+	}
+	s.rt = p.newRoundTripper(s)
+	p.sessions[key] = s
+	p.scheduleIdleCheckLocked()
+	return s, nil
+}
+
+// broken returns true if the session for key could not be established or has
+// been closed, but is still in the pool.
+func (p *sessionPool) broken(key sessionKey) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s, ok := p.sessions[key]
+	return ok && !s.healthy()
+}
+
+// release marks the end of a request on s.
+func (p *sessionPool) release(s *pooledSession) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s.active--
+	s.lastUsed = time.Now()
+}
+
+// established records the result of establishing the session of s.
+func (p *sessionPool) established(s *pooledSession, session quic.EarlySession, remote *snet.UDPAddr, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	s.session = session
+	s.remote = remote
+	s.dialErr = err
+}
+
+// sessionOf returns the session of s, or the error that occurred when
+// establishing it.
+func (p *sessionPool) sessionOf(s *pooledSession) (quic.EarlySession, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return s.session, s.dialErr
+}
+
+// info returns a description of all sessions in the pool, sorted by host.
+func (p *sessionPool) info() []SessionInfo {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	infos := make([]SessionInfo, 0, len(p.sessions))
+	for _, s := range p.sessions {
+		info := SessionInfo{
+			Host:           s.key.authority,
+			Path:           s.path,
+			ActiveRequests: s.active,
+			LastUsed:       s.lastUsed,
+		}
+		if s.remote != nil {
+			info.Remote = s.remote.Copy()
+		}
+		infos = append(infos, info)
+	}
+	sort.Slice(infos, func(i, j int) bool {
+		if infos[i].Host != infos[j].Host {
+			return infos[i].Host < infos[j].Host
+		}
+		return infos[i].LastUsed.Before(infos[j].LastUsed)
+	})
+	return infos
+}
+
+// closeIdle closes all sessions that have been idle for longer than the idle
+// timeout and reschedules itself while there are sessions left.
+func (p *sessionPool) closeIdle() {
+	now := time.Now()
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.idleTimer = nil
+	for _, s := range p.sessions {
+		if s.idle(now, p.config.IdleTimeout) {
+			p.removeLocked(s)
+		}
+	}
+	p.scheduleIdleCheckLocked()
+}
+
+func (p *sessionPool) scheduleIdleCheckLocked() {
+	if p.config.IdleTimeout > 0 && p.idleTimer == nil && len(p.sessions) > 0 {
+		p.idleTimer = time.AfterFunc(p.config.IdleTimeout, p.closeIdle)
+	}
+}
+
+// close closes all sessions.
+func (p *sessionPool) close() (err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.idleTimer != nil {
+		p.idleTimer.Stop()
+		p.idleTimer = nil
+	}
+	for _, s := range p.sessions {
+		if cerr := p.removeLocked(s); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+func (p *sessionPool) removeLocked(s *pooledSession) error {
+	delete(p.sessions, s.key)
+	return s.rt.Close()
+}
+
+// releaseBody releases the session of a request once the response body is
+// closed.
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
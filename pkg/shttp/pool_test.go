@@ -0,0 +1,129 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shttp
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/http3"
+)
+
+func newTestSessionPool(config PoolConfig) *sessionPool {
+	return newSessionPool(config, func(s *pooledSession) *http3.RoundTripper {
+		return &http3.RoundTripper{}
+	})
+}
+
+func TestSessionPoolReuse(t *testing.T) {
+	p := newTestSessionPool(PoolConfig{})
+	defer p.close()
+
+	keyA := sessionKey{authority: "host1:443", path: "a"}
+	keyB := sessionKey{authority: "host1:443", path: "b"}
+
+	s1, _ := p.get(keyA, nil)
+	s2, _ := p.get(keyA, nil)
+	s3, _ := p.get(keyB, nil)
+	if s1 != s2 {
+		t.Fatalf("session for same host and path was not reused")
+	}
+	if s1 == s3 {
+		t.Fatalf("session for different path was reused")
+	}
+	if s1.active != 2 {
+		t.Fatalf("unexpected number of active requests, actual='%d', expected='2'", s1.active)
+	}
+	p.release(s1)
+	p.release(s2)
+	if s1.active != 0 {
+		t.Fatalf("unexpected number of active requests, actual='%d', expected='0'", s1.active)
+	}
+	if n := len(p.info()); n != 2 {
+		t.Fatalf("unexpected number of sessions, actual='%d', expected='2'", n)
+	}
+}
+
+func TestSessionPoolMaxSessionsPerHost(t *testing.T) {
+	p := newTestSessionPool(PoolConfig{MaxSessionsPerHost: 2})
+	defer p.close()
+
+	keyA := sessionKey{authority: "host1:443", path: "a"}
+	keyB := sessionKey{authority: "host1:443", path: "b"}
+	keyC := sessionKey{authority: "host1:443", path: "c"}
+	keyOther := sessionKey{authority: "host2:443", path: "a"}
+
+	sA, _ := p.get(keyA, nil)
+	_, _ = p.get(keyB, nil)
+	if _, err := p.get(keyOther, nil); err != nil {
+		t.Fatalf("unexpected error for other host: %s", err)
+	}
+	if _, err := p.get(keyC, nil); err != ErrTooManySessions {
+		t.Fatalf("unexpected error, actual='%v', expected='%s'", err, ErrTooManySessions)
+	}
+
+	// Once idle, the session for path a can be replaced
+	p.release(sA)
+	if _, err := p.get(keyC, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok := p.sessions[keyA]; ok {
+		t.Fatalf("idle session was not evicted")
+	}
+	if n := len(p.info()); n != 3 {
+		t.Fatalf("unexpected number of sessions, actual='%d', expected='3'", n)
+	}
+}
+
+func TestSessionPoolHealth(t *testing.T) {
+	p := newTestSessionPool(PoolConfig{})
+	defer p.close()
+
+	key := sessionKey{authority: "host1:443"}
+	s1, _ := p.get(key, nil)
+	p.established(s1, nil, nil, errors.New("handshake failed"))
+	p.release(s1)
+
+	s2, _ := p.get(key, nil)
+	if s1 == s2 {
+		t.Fatalf("broken session was reused")
+	}
+	if n := len(p.info()); n != 1 {
+		t.Fatalf("unexpected number of sessions, actual='%d', expected='1'", n)
+	}
+}
+
+func TestSessionPoolIdleTimeout(t *testing.T) {
+	timeout := 20 * time.Millisecond
+	p := newTestSessionPool(PoolConfig{IdleTimeout: timeout})
+	defer p.close()
+
+	keyIdle := sessionKey{authority: "host1:443", path: "a"}
+	keyBusy := sessionKey{authority: "host1:443", path: "b"}
+	s, _ := p.get(keyIdle, nil)
+	p.release(s)
+	_, _ = p.get(keyBusy, nil)
+
+	time.Sleep(5 * timeout)
+
+	infos := p.info()
+	if len(infos) != 1 {
+		t.Fatalf("unexpected number of sessions, actual='%d', expected='1'", len(infos))
+	}
+	if infos[0].ActiveRequests != 1 {
+		t.Fatalf("idle session was not evicted")
+	}
+}
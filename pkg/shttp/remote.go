@@ -0,0 +1,190 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shttp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/scionproto/scion/go/lib/addr"
+	"github.com/scionproto/scion/go/lib/snet"
+	"github.com/scionproto/scion/go/lib/spath"
+)
+
+const (
+	// remoteAddrTTL is the time after which the address of a client that has not
+	// sent any packets is forgotten
+	remoteAddrTTL = 5 * time.Minute
+	// remoteAddrPruneThreshold is the number of recorded client addresses above
+	// which stale addresses are removed
+	remoteAddrPruneThreshold = 1024
+)
+
+type contextKey struct {
+	name string
+}
+
+var remoteAddrContextKey = &contextKey{"remote-addr"}
+
+// RemoteAddr returns the SCION address of the client of a request served by a
+// Server. If known, the address includes the path on which the most recent
+// packet from the client arrived, reversed so that it leads back to the client.
+// Returns nil if the request was not served by a Server.
+func RemoteAddr(r *http.Request) *snet.UDPAddr {
+	if a, ok := r.Context().Value(remoteAddrContextKey).(*snet.UDPAddr); ok {
+		return a.Copy()
+	}
+	return nil
+}
+
+// RemotePath returns a human readable description of the path on which the
+// request arrived, as returned by RemoteAddr. The description lists the hops of
+// each segment as ConsIngress>ConsEgress interface IDs, in the direction back
+// to the client.
+// Returns an empty string if the path is not known.
+func RemotePath(r *http.Request) string {
+	a, ok := r.Context().Value(remoteAddrContextKey).(*snet.UDPAddr)
+	if !ok {
+		return ""
+	}
+	return describePath(a.Path)
+}
+
+// AllowISDs returns a handler that only passes requests from clients in one of
+// the given ISDs to handler and responds with 403 Forbidden to all others.
+func AllowISDs(handler http.Handler, isds ...addr.ISD) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a := RemoteAddr(r); a != nil {
+			for _, isd := range isds {
+				if a.IA.I == isd {
+					handler.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	})
+}
+
+// describePath formats the hops of a raw SCION path.
+func describePath(p *spath.Path) string {
+	if p == nil || len(p.Raw) == 0 {
+		return "empty"
+	}
+	var segments []string
+	for off := 0; off < len(p.Raw); {
+		info, err := spath.InfoFFromRaw(p.Raw[off:])
+		if err != nil {
+			return fmt.Sprintf("invalid path: %v", err)
+		}
+		off += spath.InfoFieldLength
+		hops := make([]string, 0, info.Hops)
+		for i := 0; i < int(info.Hops); i++ {
+			if off+spath.HopFieldLength > len(p.Raw) {
+				return "invalid path: truncated"
+			}
+			hop, err := spath.HopFFromRaw(p.Raw[off:])
+			if err != nil {
+				return fmt.Sprintf("invalid path: %v", err)
+			}
+			hops = append(hops, fmt.Sprintf("%d>%d", hop.ConsIngress, hop.ConsEgress))
+			off += spath.HopFieldLength
+		}
+		segments = append(segments, fmt.Sprintf("ISD %d [%s]", info.ISD, strings.Join(hops, " ")))
+	}
+	return strings.Join(segments, " ")
+}
+
+// remoteAddrConn is a net.PacketConn that records the SCION address, including
+// the path, of the latest packet received from each remote.
+// quic-go only exposes the remote address as a string to the http3 handlers,
+// so the path would otherwise be lost.
+type remoteAddrConn struct {
+	net.PacketConn
+	table *remoteAddrTable
+}
+
+func (c *remoteAddrConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, a, err := c.PacketConn.ReadFrom(b)
+	if sa, ok := a.(*snet.UDPAddr); ok {
+		c.table.update(sa)
+	}
+	return n, a, err
+}
+
+type remoteAddrEntry struct {
+	addr     *snet.UDPAddr
+	lastSeen time.Time
+}
+
+// remoteAddrTable maps the string representation of remote addresses to the
+// full address
+type remoteAddrTable struct {
+	mutex   sync.Mutex
+	entries map[string]remoteAddrEntry
+}
+
+func newRemoteAddrTable() *remoteAddrTable {
+	return &remoteAddrTable{
+		entries: make(map[string]remoteAddrEntry),
+	}
+}
+
+func (t *remoteAddrTable) update(a *snet.UDPAddr) {
+	now := time.Now()
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.entries[a.String()] = remoteAddrEntry{addr: a, lastSeen: now}
+	if len(t.entries) > remoteAddrPruneThreshold {
+		for k, e := range t.entries {
+			if now.Sub(e.lastSeen) > remoteAddrTTL {
+				delete(t.entries, k)
+			}
+		}
+	}
+}
+
+func (t *remoteAddrTable) lookup(remoteAddr string) (*snet.UDPAddr, bool) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	e, ok := t.entries[remoteAddr]
+	return e.addr, ok
+}
+
+// remoteAddrHandler adds the remote SCION address to the request context, for
+// RemoteAddr and RemotePath.
+type remoteAddrHandler struct {
+	handler http.Handler
+	table   *remoteAddrTable
+}
+
+func (h *remoteAddrHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a, ok := h.table.lookup(r.RemoteAddr)
+	if !ok {
+		// Path unknown, but the address may still be useful.
+		var err error
+		a, err = snet.ParseUDPAddr(r.RemoteAddr)
+		ok = err == nil
+	}
+	if ok {
+		r = r.WithContext(context.WithValue(r.Context(), remoteAddrContextKey, a))
+	}
+	h.handler.ServeHTTP(w, r)
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shttp
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/scionproto/scion/go/lib/snet"
+	"github.com/scionproto/scion/go/lib/spath"
+)
+
+func TestRemoteAddr(t *testing.T) {
+
+	// one segment with two hops
+	raw := make([]byte, spath.InfoFieldLength+2*spath.HopFieldLength)
+	(&spath.InfoField{ISD: 1, Hops: 2}).Write(raw)
+	(&spath.HopField{ConsIngress: 0, ConsEgress: 2}).Write(raw[spath.InfoFieldLength:])
+	(&spath.HopField{ConsIngress: 5, ConsEgress: 0}).Write(raw[spath.InfoFieldLength+spath.HopFieldLength:])
+
+	withPath, _ := snet.ParseUDPAddr("1-ff00:0:110,127.0.0.1:4000")
+	withPath.Path = spath.New(raw)
+	table := newRemoteAddrTable()
+	table.update(withPath)
+
+	testCases := []struct {
+		RemoteAddr   string
+		ExpectedAddr string
+		ExpectedPath string
+	}{
+		{"1-ff00:0:110,127.0.0.1:4000", "1-ff00:0:110,127.0.0.1:4000", "ISD 1 [0>2 5>0]"},
+		{"2-ff00:0:220,[::1]:4000", "2-ff00:0:220,[::1]:4000", "empty"},
+		{"127.0.0.1:4000", "", ""},
+	}
+
+	for _, tc := range testCases {
+		var actualAddr, actualPath string
+		h := &remoteAddrHandler{
+			handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if a := RemoteAddr(r); a != nil {
+					actualAddr = a.String()
+				}
+				actualPath = RemotePath(r)
+			}),
+			table: table,
+		}
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tc.RemoteAddr
+		h.ServeHTTP(httptest.NewRecorder(), req)
+		if actualAddr != tc.ExpectedAddr {
+			t.Fatalf("unexpected RemoteAddr, actual='%s', expected='%s'", actualAddr, tc.ExpectedAddr)
+		}
+		if actualPath != tc.ExpectedPath {
+			t.Fatalf("unexpected RemotePath, actual='%s', expected='%s'", actualPath, tc.ExpectedPath)
+		}
+	}
+}
+
+func TestAllowISDs(t *testing.T) {
+	testCases := []struct {
+		RemoteAddr string
+		Expected   int
+	}{
+		{"1-ff00:0:110,127.0.0.1:4000", http.StatusOK},
+		{"17-ffaa:0:1,127.0.0.1:4000", http.StatusOK},
+		{"2-ff00:0:220,127.0.0.1:4000", http.StatusForbidden},
+		{"127.0.0.1:4000", http.StatusForbidden},
+	}
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	h := &remoteAddrHandler{
+		handler: AllowISDs(ok, 1, 17),
+		table:   newRemoteAddrTable(),
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tc.RemoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tc.Expected {
+			t.Fatalf("unexpected status for '%s', actual=%d, expected=%d", tc.RemoteAddr, rec.Code, tc.Expected)
+		}
+	}
+}
@@ -17,15 +17,19 @@ package shttp
 import (
 	"net"
 	"net/http"
+	"sync"
 
-	"github.com/lucas-clemente/quic-go/h2quic"
+	"github.com/lucas-clemente/quic-go/http3"
 	"github.com/netsec-ethz/scion-apps/pkg/appnet"
 	"github.com/netsec-ethz/scion-apps/pkg/appnet/appquic"
 )
 
-// Server wraps a h2quic.Server making it work with SCION
+// Server wraps a http3.Server making it work with SCION
 type Server struct {
-	*h2quic.Server
+	*http3.Server
+
+	remoteAddrsOnce sync.Once
+	remoteAddrs     *remoteAddrTable
 }
 
 // ListenAndServe listens for HTTPS connections on the SCION address addr and calls Serve
@@ -33,7 +37,7 @@ type Server struct {
 func ListenAndServe(addr string, handler http.Handler) error {
 
 	scionServer := &Server{
-		Server: &h2quic.Server{
+		Server: &http3.Server{
 			Server: &http.Server{
 				Addr:    addr,
 				Handler: handler,
@@ -48,7 +52,7 @@ func ListenAndServe(addr string, handler http.Handler) error {
 func Serve(conn net.PacketConn, handler http.Handler) error {
 
 	scionServer := &Server{
-		Server: &h2quic.Server{
+		Server: &http3.Server{
 			Server: &http.Server{
 				Handler: handler,
 			},
@@ -70,11 +74,19 @@ func (srv *Server) ListenAndServe() error {
 	if err != nil {
 		return err
 	}
+	// http3.Server does not close the conn passed to Serve
+	defer sconn.Close()
 	return srv.Serve(sconn)
 }
 
 // Serve listens on conn and accepts incoming connections
 // a goroutine is spawned for every request and handled by srv.srv.handler
+// The handler can obtain the client's SCION address and path with RemoteAddr
+// and RemotePath.
+// conn is usually a SCION conn created with appnet.Listen, but can be any
+// net.PacketConn, e.g. to test handlers without a SCION dispatcher. If the
+// remote addresses of conn are *snet.UDPAddr, they are made available to the
+// handler.
 func (srv *Server) Serve(conn net.PacketConn) error {
 
 	// set dummy TLS config if not set:
@@ -86,7 +98,16 @@ func (srv *Server) Serve(conn net.PacketConn) error {
 		srv.TLSConfig = cfg
 	}
 
-	return srv.Server.Serve(conn)
+	srv.remoteAddrsOnce.Do(func() {
+		srv.remoteAddrs = newRemoteAddrTable()
+		handler := srv.Handler
+		if handler == nil {
+			handler = http.DefaultServeMux
+		}
+		srv.Handler = &remoteAddrHandler{handler: handler, table: srv.remoteAddrs}
+	})
+
+	return srv.Server.Serve(&remoteAddrConn{PacketConn: conn, table: srv.remoteAddrs})
 }
 
 // Close the server immediately, aborting requests and sending CONNECTION_CLOSE frames to connected clients
@@ -0,0 +1,435 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shttp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/http3"
+	"github.com/marten-seemann/qpack"
+)
+
+// Streams are bidirectional byte streams between client and server, carried
+// in the body of a single HTTP/3 request and its response. They serve the
+// purpose of WebSockets, which are not available over HTTP/3.
+//
+// The client opens a stream with a CONNECT request to the path of the
+// handler. The handler accepts it with AcceptStream, after which both sides
+// can read and write concurrently until either side closes the stream.
+//
+// The http3.RoundTripper buffers request bodies until they are complete, so
+// the client side writes the frames of the request directly to the QUIC
+// stream instead.
+
+const (
+	frameTypeData    = 0x0
+	frameTypeHeaders = 0x1
+
+	// maxResponseHeaderBytes limits the size of the response HEADERS frame
+	maxResponseHeaderBytes = 1 << 20
+)
+
+// ErrNotStreamRequest is returned by AcceptStream for requests that have not
+// been sent with OpenStream.
+var ErrNotStreamRequest = errors.New("shttp: not a stream request")
+
+// Stream is a bidirectional byte stream between an shttp client and server.
+type Stream interface {
+	io.ReadWriteCloser
+}
+
+// ClientStream is the client side of a stream, returned by OpenStream.
+type ClientStream interface {
+	Stream
+	// CloseWrite signals the end of the client's data to the handler, while
+	// the response can still be read.
+	CloseWrite() error
+}
+
+var (
+	h3ALPNOnce sync.Once
+	h3ALPN     []string
+)
+
+// h3NextProtos returns the ALPN protocols of the quic-go http3 package, which
+// does not export them. They are taken from the TLS configuration that an
+// http3.RoundTripper dials with.
+func h3NextProtos() []string {
+	h3ALPNOnce.Do(func() {
+		rt := &http3.RoundTripper{
+			Dial: func(network, address string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlySession, error) {
+				h3ALPN = tlsCfg.NextProtos
+				return nil, errors.New("shttp: only determining the ALPN")
+			},
+		}
+		defer rt.Close()
+		req, _ := http.NewRequest(http.MethodGet, "https://localhost/", nil)
+		_, _ = rt.RoundTrip(req)
+	})
+	return h3ALPN
+}
+
+// AcceptStream accepts a stream opened by the client with OpenStream. It sends
+// the response header, with status 200 OK and any headers already set on w,
+// and returns the stream.
+// The stream ends when the handler returns, so the handler must not return
+// before it is done with the stream.
+func AcceptStream(w http.ResponseWriter, r *http.Request) (Stream, error) {
+	if r.Method != http.MethodConnect || r.ProtoMajor != 3 {
+		return nil, ErrNotStreamRequest
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("shttp: response writer does not support streaming")
+	}
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	return &serverStream{body: r.Body, w: w, flusher: flusher}, nil
+}
+
+// serverStream is the server side of a stream. It reads from the request body
+// and writes to the response.
+type serverStream struct {
+	body    io.ReadCloser
+	w       io.Writer
+	flusher http.Flusher
+
+	mutex  sync.Mutex
+	closed bool
+}
+
+func (s *serverStream) Read(b []byte) (int, error) {
+	return s.body.Read(b)
+}
+
+func (s *serverStream) Write(b []byte) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n, err := s.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	// the response writer is buffered
+	s.flusher.Flush()
+	return n, nil
+}
+
+// Close stops writing to the stream. The stream is closed once the handler
+// returns.
+func (s *serverStream) Close() error {
+	s.mutex.Lock()
+	s.closed = true
+	s.mutex.Unlock()
+	return s.body.Close()
+}
+
+// OpenStream opens a stream to the server, to be accepted by the handler with
+// AcceptStream. The request is sent as a CONNECT request, regardless of
+// req.Method; req.Body is ignored.
+// If the handler does not accept the stream, the response is returned
+// together with an error.
+func (t *roundTripper) OpenStream(req *http.Request) (ClientStream, *http.Response, error) {
+	host := mangleSCIONAddr(req.URL.Host)
+	authority := authorityAddr(host)
+	path, err := t.pathFor(req.Context(), authority)
+	if err != nil {
+		return nil, nil, err
+	}
+	key := sessionKey{authority: authority}
+	if path != nil {
+		key.path = path.Fingerprint()
+	}
+	s, err := t.pool.get(key, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	release := func() { t.pool.release(s) }
+
+	session, err := t.establish(s, authority, t.h3TLSConfig(), t.h3QuicConfig())
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	select {
+	case <-session.HandshakeComplete().Done():
+	case <-req.Context().Done():
+		release()
+		return nil, nil, req.Context().Err()
+	}
+	str, err := session.OpenStreamSync(req.Context())
+	if err != nil {
+		release()
+		return nil, nil, err
+	}
+	cs := &clientStream{
+		str:     str,
+		r:       bufio.NewReader(str),
+		release: release,
+	}
+	resp, err := cs.roundTrip(req, host)
+	if err != nil {
+		cs.Close()
+		return nil, nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body = cs
+		return nil, resp, fmt.Errorf("shttp: stream not accepted: %s", resp.Status)
+	}
+	resp.Body = http.NoBody
+	return cs, resp, nil
+}
+
+// h3TLSConfig returns the TLS configuration for dialing a session, as the
+// http3.RoundTripper does.
+func (t *roundTripper) h3TLSConfig() *tls.Config {
+	tlsCfg := t.tlsClientCfg.Clone()
+	tlsCfg.NextProtos = h3NextProtos()
+	return tlsCfg
+}
+
+// h3QuicConfig returns the QUIC configuration for dialing a session, as the
+// http3.RoundTripper does.
+func (t *roundTripper) h3QuicConfig() *quic.Config {
+	if t.quicCfg == nil {
+		return &quic.Config{MaxIncomingStreams: -1, KeepAlive: true}
+	}
+	cfg := t.quicCfg.Clone()
+	cfg.MaxIncomingStreams = -1
+	return cfg
+}
+
+// clientStream is the client side of a stream, sending and receiving
+// HTTP/3 DATA frames.
+type clientStream struct {
+	str quic.Stream
+	r   *bufio.Reader
+	// remaining is the number of bytes left to read in the current DATA frame
+	remaining uint64
+
+	writeMutex sync.Mutex
+	closeOnce  sync.Once
+	release    func()
+}
+
+// roundTrip sends the request header and reads the response header.
+func (s *clientStream) roundTrip(req *http.Request, host string) (*http.Response, error) {
+	headers := &bytes.Buffer{}
+	enc := qpack.NewEncoder(headers)
+	path := req.URL.RequestURI()
+	fields := []qpack.HeaderField{
+		{Name: ":method", Value: http.MethodConnect},
+		{Name: ":scheme", Value: "https"},
+		{Name: ":authority", Value: host},
+		{Name: ":path", Value: path},
+	}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "host" || name == "connection" || name == "content-length" {
+			continue
+		}
+		for _, v := range values {
+			fields = append(fields, qpack.HeaderField{Name: name, Value: v})
+		}
+	}
+	for _, f := range fields {
+		if err := enc.WriteField(f); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.writeFrame(frameTypeHeaders, headers.Bytes()); err != nil {
+		return nil, err
+	}
+
+	typ, length, err := readFrameHeader(s.r)
+	if err != nil {
+		return nil, err
+	}
+	if typ != frameTypeHeaders {
+		return nil, errors.New("shttp: expected first frame of response to be a HEADERS frame")
+	}
+	if length > maxResponseHeaderBytes {
+		return nil, fmt.Errorf("shttp: HEADERS frame too large: %d bytes", length)
+	}
+	block := make([]byte, length)
+	if _, err := io.ReadFull(s.r, block); err != nil {
+		return nil, err
+	}
+	hfs, err := qpack.NewDecoder(nil).DecodeFull(block)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &http.Response{
+		Proto:      "HTTP/3",
+		ProtoMajor: 3,
+		Header:     http.Header{},
+		Request:    req,
+	}
+	for _, hf := range hfs {
+		if hf.Name == ":status" {
+			status, err := strconv.Atoi(hf.Value)
+			if err != nil {
+				return nil, errors.New("shttp: malformed status in response")
+			}
+			resp.StatusCode = status
+			resp.Status = hf.Value + " " + http.StatusText(status)
+		} else if !hf.IsPseudo() {
+			resp.Header.Add(hf.Name, hf.Value)
+		}
+	}
+	return resp, nil
+}
+
+// Read reads the payload of DATA frames, skipping all other frames.
+func (s *clientStream) Read(b []byte) (int, error) {
+	for s.remaining == 0 {
+		typ, length, err := readFrameHeader(s.r)
+		if err != nil {
+			return 0, err
+		}
+		if typ == frameTypeData {
+			s.remaining = length
+		} else if _, err := io.CopyN(ioutil.Discard, s.r, int64(length)); err != nil {
+			return 0, err
+		}
+	}
+	if uint64(len(b)) > s.remaining {
+		b = b[:s.remaining]
+	}
+	n, err := s.r.Read(b)
+	s.remaining -= uint64(n)
+	if err == io.EOF && s.remaining > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// Write sends b in a DATA frame.
+func (s *clientStream) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	if err := s.writeFrame(frameTypeData, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// CloseWrite closes the sending direction of the stream; the handler reads
+// EOF from the stream.
+func (s *clientStream) CloseWrite() error {
+	return s.str.Close()
+}
+
+// Close closes the stream in both directions.
+func (s *clientStream) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.str.CancelRead(0)
+		err = s.str.Close()
+		s.release()
+	})
+	return err
+}
+
+func (s *clientStream) writeFrame(typ uint64, payload []byte) error {
+	s.writeMutex.Lock()
+	defer s.writeMutex.Unlock()
+	hdr := appendVarInt(nil, typ)
+	hdr = appendVarInt(hdr, uint64(len(payload)))
+	_, err := s.str.Write(append(hdr, payload...))
+	return err
+}
+
+// readFrameHeader reads the type and length of an HTTP/3 frame.
+func readFrameHeader(r io.ByteReader) (typ, length uint64, err error) {
+	typ, err = readVarInt(r)
+	if err != nil {
+		return 0, 0, err
+	}
+	length, err = readVarInt(r)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return typ, length, err
+}
+
+// readVarInt reads a QUIC variable-length integer.
+func readVarInt(r io.ByteReader) (uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	length := 1 << (b >> 6)
+	v := uint64(b & 0x3f)
+	for i := 1; i < length; i++ {
+		b, err = r.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		v = v<<8 | uint64(b)
+	}
+	return v, nil
+}
+
+// appendVarInt appends v, encoded as QUIC variable-length integer, to b.
+func appendVarInt(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return append(b, byte(v>>8)|0x40, byte(v))
+	case v < 1<<30:
+		return append(b, byte(v>>24)|0x80, byte(v>>16), byte(v>>8), byte(v))
+	default:
+		return append(b, byte(v>>56)|0xc0, byte(v>>48), byte(v>>40), byte(v>>32),
+			byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+}
+
+// DialStream opens a stream to the handler at rawurl, using rt.
+// See RoundTripper.OpenStream.
+func DialStream(rt RoundTripper, rawurl string, header http.Header) (ClientStream, *http.Response, error) {
+	u, err := url.Parse(MangleSCIONAddrURL(rawurl))
+	if err != nil {
+		return nil, nil, err
+	}
+	req, err := http.NewRequest(http.MethodConnect, u.String(), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	return rt.OpenStream(req)
+}
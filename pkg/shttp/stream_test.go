@@ -0,0 +1,149 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shttp
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/http3"
+	"github.com/scionproto/scion/go/lib/snet"
+)
+
+func TestVarInt(t *testing.T) {
+	testCases := []struct {
+		Value   uint64
+		Encoded []byte
+	}{
+		{37, []byte{0x25}},
+		{15293, []byte{0x7b, 0xbd}},
+		{494878333, []byte{0x9d, 0x7f, 0x3e, 0x7d}},
+		{151288809941952652, []byte{0xc2, 0x19, 0x7c, 0x5e, 0xff, 0x14, 0xe8, 0x8c}},
+	}
+	for _, tc := range testCases {
+		encoded := appendVarInt(nil, tc.Value)
+		if !bytes.Equal(encoded, tc.Encoded) {
+			t.Fatalf("appendVarInt(%d) returned different result, actual='%x', expected='%x'", tc.Value, encoded, tc.Encoded)
+		}
+		decoded, err := readVarInt(bytes.NewReader(tc.Encoded))
+		if err != nil || decoded != tc.Value {
+			t.Fatalf("readVarInt('%x') returned different result, actual='%d', expected='%d'", tc.Encoded, decoded, tc.Value)
+		}
+	}
+	if _, err := readVarInt(bytes.NewReader([]byte{0x7b})); err != io.ErrUnexpectedEOF {
+		t.Fatalf("unexpected error for truncated varint, actual='%v', expected='%s'", err, io.ErrUnexpectedEOF)
+	}
+}
+
+func TestH3NextProtos(t *testing.T) {
+	protos := h3NextProtos()
+	if len(protos) == 0 {
+		t.Fatalf("no ALPN determined for HTTP/3")
+	}
+	for _, proto := range protos {
+		if !strings.HasPrefix(proto, "h3") {
+			t.Fatalf("unexpected ALPN for HTTP/3, actual='%s'", proto)
+		}
+	}
+}
+
+func TestStreamEcho(t *testing.T) {
+
+	// As in TestRoundTripperServerInterop, run the server on a plain UDP socket
+	// on localhost.
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	defer conn.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/echo", func(w http.ResponseWriter, r *http.Request) {
+		stream, err := AcceptStream(w, r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer stream.Close()
+		_, _ = io.Copy(stream, stream)
+	})
+	server := &Server{
+		Server: &http3.Server{
+			Server: &http.Server{Handler: mux},
+		},
+	}
+	go func() {
+		_ = server.Serve(conn)
+	}()
+	defer server.Close()
+
+	rt := NewRoundTripper(nil, nil)
+	rt.(*roundTripper).dial = func(raddr *snet.UDPAddr, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlySession, error) {
+		return quic.DialAddrEarly(conn.LocalAddr().String(), tlsCfg, cfg)
+	}
+	defer rt.Close()
+
+	stream, resp, err := DialStream(rt, "https://1-ff00:0:110,127.0.0.1:443/echo", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer stream.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status, actual='%s'", resp.Status)
+	}
+
+	// Messages must be echoed one by one, i.e. not be held back until the
+	// stream is closed.
+	r := bufio.NewReader(stream)
+	for _, msg := range []string{"hello\n", "scion\n"} {
+		if _, err := io.WriteString(stream, msg); err != nil {
+			t.Fatalf("unexpected error writing: %s", err)
+		}
+		echo, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("unexpected error reading: %s", err)
+		}
+		if echo != msg {
+			t.Fatalf("unexpected echo, actual='%s', expected='%s'", echo, msg)
+		}
+	}
+
+	// After the client closes its side, the handler finishes the stream
+	if err := stream.CloseWrite(); err != nil {
+		t.Fatalf("unexpected error closing: %s", err)
+	}
+	rest, err := ioutil.ReadAll(r)
+	if err != nil || len(rest) != 0 {
+		t.Fatalf("unexpected end of stream, data='%s', err='%v'", rest, err)
+	}
+
+	// Requests to handlers that do not accept streams fail
+	_, resp, err = DialStream(rt, "https://1-ff00:0:110,127.0.0.1:443/missing", nil)
+	if err == nil {
+		t.Fatalf("unexpected success")
+	}
+	if resp == nil || resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("unexpected response, actual='%v', expected status='404'", resp)
+	}
+	resp.Body.Close()
+}
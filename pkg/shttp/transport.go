@@ -18,6 +18,7 @@
 package shttp
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"io"
@@ -26,44 +27,145 @@ import (
 	"net/url"
 	"regexp"
 	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/lucas-clemente/quic-go"
-	"github.com/lucas-clemente/quic-go/h2quic"
+	"github.com/lucas-clemente/quic-go/http3"
+	"github.com/netsec-ethz/scion-apps/pkg/appnet"
 	"github.com/netsec-ethz/scion-apps/pkg/appnet/appquic"
+	"github.com/scionproto/scion/go/lib/addr"
 	"github.com/scionproto/scion/go/lib/snet"
 )
 
-// RoundTripper extends the http.RoundTripper interface with a Close
+// RoundTripper extends the http.RoundTripper interface with a Close, a way to
+// inspect the pool of QUIC sessions and bidirectional streams
 type RoundTripper interface {
 	http.RoundTripper
 	io.Closer
+	// Sessions returns a description of the currently open sessions.
+	Sessions() []SessionInfo
+	// OpenStream opens a bidirectional stream to the handler for req, which
+	// accepts it with AcceptStream. See DialStream.
+	OpenStream(req *http.Request) (ClientStream, *http.Response, error)
+}
+
+// DialFunc establishes a QUIC session to raddr. If raddr.Path is nil, the
+// default path is to be used.
+type DialFunc func(raddr *snet.UDPAddr, tlsCfg *tls.Config, quicCfg *quic.Config) (quic.EarlySession, error)
+
+// RoundTripperConfig configures a RoundTripper
+type RoundTripperConfig struct {
+	// TLSClientConfig is the TLS configuration used for the QUIC sessions. If
+	// nil, the server's certificate is not verified, analogous to appquic.Dial.
+	TLSClientConfig *tls.Config
+	// QuicConfig is the QUIC configuration, may be nil.
+	QuicConfig *quic.Config
+	// PathSelector chooses the path to each host. It is invoked once per host;
+	// the chosen path is used for all subsequent requests to this host,
+	// unless overridden with WithPath. If nil, the default path is used.
+	PathSelector PathSelector
+	// Pool configures the limits of the session pool.
+	Pool PoolConfig
+	// Dial establishes the QUIC sessions. If nil, appquic.DialAddrEarly is
+	// used. A custom DialFunc allows to use a different packet conn, e.g. for
+	// testing without a SCION dispatcher.
+	Dial DialFunc
+}
+
+// PathSelector chooses the path to the destination AS dst. Returning a nil
+// path selects the default path.
+// appnet.ChoosePathInteractive is a PathSelector; appnet.ChoosePathByMetric
+// can be used as a PathSelector by binding the path algorithm.
+type PathSelector func(dst addr.IA) (snet.Path, error)
+
+var pathContextKey = &contextKey{"path"}
+
+// WithPath returns a copy of ctx that makes the RoundTripper send a request
+// with this context over path, overriding the RoundTripper's PathSelector.
+// The path must lead to the AS of the requested host.
+func WithPath(ctx context.Context, path snet.Path) context.Context {
+	return context.WithValue(ctx, pathContextKey, path)
 }
 
 // NewRoundTripper creates a new RoundTripper that can be used as the Transport
-// of an http.Client.
+// of an http.Client. Requests are sent over the default path.
+// If tlsClientCfg is nil, the server's certificate is not verified, analogous
+// to appquic.Dial.
 func NewRoundTripper(tlsClientCfg *tls.Config, quicCfg *quic.Config) RoundTripper {
-	return &roundTripper{
-		&h2quic.RoundTripper{
-			Dial:            dial,
-			QuicConfig:      quicCfg,
-			TLSClientConfig: tlsClientCfg,
-		},
+	return NewRoundTripperWithPathSelector(tlsClientCfg, quicCfg, nil)
+}
+
+// NewRoundTripperWithPathSelector creates a new RoundTripper that uses
+// selector to choose the path to each host. The selector is invoked once per
+// host; the chosen path is used for all subsequent requests to this host,
+// unless overridden with WithPath. The selector is invoked again once the path
+// has expired, or the session over it could not be established or was closed.
+// If selector is nil, the default path is used.
+// If tlsClientCfg is nil, the server's certificate is not verified, analogous
+// to appquic.Dial.
+func NewRoundTripperWithPathSelector(tlsClientCfg *tls.Config, quicCfg *quic.Config,
+	selector PathSelector) RoundTripper {
+
+	return NewRoundTripperWithConfig(&RoundTripperConfig{
+		TLSClientConfig: tlsClientCfg,
+		QuicConfig:      quicCfg,
+		PathSelector:    selector,
+	})
+}
+
+// NewRoundTripperWithConfig creates a new RoundTripper with the given
+// configuration.
+func NewRoundTripperWithConfig(cfg *RoundTripperConfig) RoundTripper {
+	tlsClientCfg := cfg.TLSClientConfig
+	if tlsClientCfg == nil {
+		// Don't verify the server's cert, as we are not using the TLS PKI.
+		tlsClientCfg = &tls.Config{InsecureSkipVerify: true}
+	}
+	dial := cfg.Dial
+	if dial == nil {
+		dial = appquic.DialAddrEarly
 	}
+	t := &roundTripper{
+		tlsClientCfg: tlsClientCfg,
+		quicCfg:      cfg.QuicConfig,
+		selector:     cfg.PathSelector,
+		dial:         dial,
+		selected:     make(map[string]snet.Path),
+	}
+	t.pool = newSessionPool(cfg.Pool, t.newHTTP3RoundTripper)
+	return t
 }
 
 var _ RoundTripper = (*roundTripper)(nil)
 
-// roundTripper implements the RoundTripper interface. It wraps a
-// h2quic.RoundTripper, making it compatible with SCION
+// sessionKey identifies the QUIC session to a host over a specific path.
+type sessionKey struct {
+	authority string
+	path      snet.PathFingerprint // empty for the default path
+}
+
+// roundTripper implements the RoundTripper interface. It wraps one
+// http3.RoundTripper per host and path, making it compatible with SCION.
+// These are managed by the session pool.
 type roundTripper struct {
-	rt *h2quic.RoundTripper
+	tlsClientCfg *tls.Config
+	quicCfg      *quic.Config
+	selector     PathSelector
+	dial         DialFunc
+
+	selectMutex sync.Mutex
+	selected    map[string]snet.Path // path chosen by selector, by authority
+
+	pool *sessionPool
 }
 
 // RoundTrip does a single round trip; retreiving a response for a given request
 func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 
 	// If req.URL.Host is a SCION address, we need to mangle it so it passes through
-	// h2quic without tripping up.
+	// http3 without tripping up.
 	// Note: when using the http.Client, the URL must already arrive mangled
 	// here, otherwise it would not have parsed.
 	cpy := *req
@@ -71,22 +173,140 @@ func (t *roundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
 	*cpy.URL = *req.URL
 	cpy.URL.Host = mangleSCIONAddr(req.URL.Host)
 
-	return t.rt.RoundTrip(&cpy)
+	authority := authorityAddr(cpy.URL.Host)
+	path, err := t.pathFor(req.Context(), authority)
+	if err != nil {
+		return nil, err
+	}
+
+	key := sessionKey{authority: authority}
+	if path != nil {
+		key.path = path.Fingerprint()
+	}
+	s, err := t.pool.get(key, path)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.rt.RoundTrip(&cpy)
+	if err != nil {
+		t.pool.release(s)
+		return nil, err
+	}
+	resp.Body = &releaseBody{
+		ReadCloser: resp.Body,
+		release:    func() { t.pool.release(s) },
+	}
+	return resp, nil
 }
 
 // Close closes the QUIC connections that this RoundTripper has used
-func (t *roundTripper) Close() (err error) {
+func (t *roundTripper) Close() error {
+	return t.pool.close()
+}
+
+// Sessions returns a description of the currently open sessions
+func (t *roundTripper) Sessions() []SessionInfo {
+	return t.pool.info()
+}
+
+// pathFor returns the path for a request with context ctx to the host
+// authority, or nil for the default path.
+func (t *roundTripper) pathFor(ctx context.Context, authority string) (snet.Path, error) {
+
+	if path, ok := ctx.Value(pathContextKey).(snet.Path); ok {
+		return path, nil
+	}
+	if t.selector == nil {
+		return nil, nil
+	}
+
+	// Serialize the path selection, so that an interactive selector does not
+	// ask about the same host multiple times.
+	t.selectMutex.Lock()
+	defer t.selectMutex.Unlock()
+	if path, ok := t.selected[authority]; ok {
+		if t.usable(authority, path) {
+			return path, nil
+		}
+		delete(t.selected, authority)
+	}
+	raddr, err := appnet.ResolveUDPAddr(unmangleSCIONAddr(authority))
+	if err != nil {
+		return nil, err
+	}
+	path, err := t.selector(raddr.IA)
+	if err != nil {
+		return nil, err
+	}
+	t.selected[authority] = path
+	return path, nil
+}
 
-	if t.rt != nil {
-		err = t.rt.Close()
+// usable returns false if path has expired, or if the session to authority
+// over path could not be established or has been closed.
+func (t *roundTripper) usable(authority string, path snet.Path) bool {
+	if expiry := path.Expiry(); !expiry.IsZero() && time.Now().After(expiry) {
+		return false
 	}
+	return !t.pool.broken(sessionKey{authority: authority, path: path.Fingerprint()})
+}
+
+// newHTTP3RoundTripper creates the http3.RoundTripper for the session s,
+// dialing over the path of s.
+func (t *roundTripper) newHTTP3RoundTripper(s *pooledSession) *http3.RoundTripper {
+	return &http3.RoundTripper{
+		Dial: func(network, address string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlySession, error) {
+			return t.establish(s, address, tlsCfg, cfg)
+		},
+		QuicConfig:      t.quicCfg,
+		TLSClientConfig: t.tlsClientCfg,
+	}
+}
+
+// establish returns the QUIC session of s, dialing it on first use. The
+// session is shared between the http3.RoundTripper of s and the streams opened
+// with OpenStream.
+func (t *roundTripper) establish(s *pooledSession, address string,
+	tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlySession, error) {
+
+	s.dialOnce.Do(func() {
+		session, raddr, err := t.dialPath(address, s.path, tlsCfg, cfg)
+		t.pool.established(s, session, raddr, err)
+	})
+	return t.pool.sessionOf(s)
+}
+
+// dialPath resolves the (mangled) address and dials it over path, or the
+// default path if path is nil.
+func (t *roundTripper) dialPath(address string, path snet.Path,
+	tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlySession, *snet.UDPAddr, error) {
 
-	return err
+	raddr, err := appnet.ResolveUDPAddr(unmangleSCIONAddr(address))
+	if err != nil {
+		return nil, nil, err
+	}
+	if path != nil {
+		if path.Destination() != raddr.IA {
+			return nil, nil, fmt.Errorf("path to %s cannot be used for host in %s", path.Destination(), raddr.IA)
+		}
+		appnet.SetPath(raddr, path)
+	}
+	session, err := t.dial(raddr, tlsCfg, cfg)
+	return session, raddr, err
 }
 
-// dial is the Dial function used in RoundTripper
-func dial(network, address string, tlsCfg *tls.Config, cfg *quic.Config) (quic.Session, error) {
-	return appquic.Dial(unmangleSCIONAddr(address), tlsCfg, cfg)
+// authorityAddr adds the default port to host if it has none, like http3 does
+// to determine the address of a request.
+func authorityAddr(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	// JoinHostPort would add another pair of brackets around a mangled SCION
+	// address or an IPv6 literal.
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		return host + ":443"
+	}
+	return net.JoinHostPort(host, "443")
 }
 
 var scionAddrURLRegexp = regexp.MustCompile(
@@ -136,7 +356,7 @@ func mangleSCIONAddr(address string) string {
 // This parses the address, so that it can safely join host and port, with the
 // brackets in the right place. Yes, this means this will be parsed twice.
 //
-// Assumes that address always has a port (this is enforced by the h2quic
+// Assumes that address always has a port (this is enforced by the http3
 // roundtripper code)
 func unmangleSCIONAddr(address string) string {
 	host, port, err := net.SplitHostPort(address)
@@ -15,17 +15,24 @@
 package shttp
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"net/http"
 	"net/url"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/lucas-clemente/quic-go"
+	"github.com/lucas-clemente/quic-go/http3"
 	"github.com/netsec-ethz/scion-apps/pkg/appnet"
+	"github.com/scionproto/scion/go/lib/addr"
+	"github.com/scionproto/scion/go/lib/snet"
+	"github.com/scionproto/scion/go/lib/spath"
 )
 
 func TestMangleSCIONAddrURL(t *testing.T) {
@@ -92,24 +99,18 @@ func TestRoundTripper(t *testing.T) {
 	urlPatterns := hostURLPatterns()
 
 	// We replace the actual dial function of the roundtripper with this function that only
-	// checks wether the address can be successfully unmangled and resolved.
+	// checks wether the address has been successfully unmangled and resolved.
 	// expected will be set in the test loop, below
 	var expected string
-	testDial := func(network, address string, tlsCfg *tls.Config, cfg *quic.Config) (quic.Session, error) {
-		unmangled := unmangleSCIONAddr(address)
-		resolvedAddr, err := appnet.ResolveUDPAddr(unmangled)
-		if err != nil {
-			t.Fatalf("unexpected error when resolving address '%s' in roundtripper: %s", unmangled, err)
-		}
-		actual := resolvedAddr.String()
+	testDial := func(raddr *snet.UDPAddr, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlySession, error) {
+		actual := raddr.String()
 		if actual != expected {
 			t.Fatalf("unexpected address resolved in roundtripper, actual='%s', expected='%s'", actual, expected)
 		}
 		return nil, errors.New("just a test")
 	}
 
-	rt := NewRoundTripper(nil, nil)
-	rt.(*roundTripper).rt.Dial = testDial
+	rt := NewRoundTripperWithConfig(&RoundTripperConfig{Dial: testDial})
 	c := &http.Client{Transport: rt}
 
 	for _, tc := range testCases {
@@ -127,6 +128,159 @@ func TestRoundTripper(t *testing.T) {
 	}
 }
 
+func TestRoundTripperServerInterop(t *testing.T) {
+
+	// Run the server on a plain UDP socket on localhost, so that this test does
+	// not depend on a running SCION dispatcher.
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unable to listen: %s", err)
+	}
+	defer conn.Close()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/hello", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "hello %s", r.URL.Query().Get("name"))
+	})
+	server := &Server{
+		Server: &http3.Server{
+			Server: &http.Server{Handler: mux},
+		},
+	}
+	go func() {
+		_ = server.Serve(conn)
+	}()
+	defer server.Close()
+
+	// Replace the SCION dial with one that ignores the (SCION) address in the
+	// URL and connects to the local server instead.
+	rt := NewRoundTripper(nil, nil)
+	rt.(*roundTripper).dial = func(raddr *snet.UDPAddr, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlySession, error) {
+		return quic.DialAddrEarly(conn.LocalAddr().String(), tlsCfg, cfg)
+	}
+	c := &http.Client{Transport: rt}
+	defer rt.Close()
+
+	resp, err := c.Get(MangleSCIONAddrURL("https://1-ff00:0:110,127.0.0.1:443/hello?name=scion"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unexpected error reading body: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status, actual='%s'", resp.Status)
+	}
+	if resp.ProtoMajor != 3 {
+		t.Fatalf("unexpected protocol, actual='%s', expected='HTTP/3'", resp.Proto)
+	}
+	if string(body) != "hello scion" {
+		t.Fatalf("unexpected body, actual='%s', expected='hello scion'", body)
+	}
+}
+
+func TestRoundTripperPaths(t *testing.T) {
+
+	dst, _ := addr.IAFromString("1-ff00:0:110")
+	pathA := &testPath{dst: dst, fingerprint: "a"}
+	pathB := &testPath{dst: dst, fingerprint: "b"}
+	pathOther := &testPath{dst: addr.IA{I: 2}, fingerprint: "c"}
+
+	var selectorCalls int
+	selector := func(ia addr.IA) (snet.Path, error) {
+		selectorCalls++
+		if ia != dst {
+			t.Fatalf("unexpected destination in path selector, actual='%s', expected='%s'", ia, dst)
+		}
+		return pathA, nil
+	}
+
+	var dialedPath snet.PathFingerprint
+	rt := NewRoundTripperWithPathSelector(nil, nil, selector)
+	rt.(*roundTripper).dial = func(raddr *snet.UDPAddr, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlySession, error) {
+		if raddr.NextHop != nil {
+			dialedPath = snet.PathFingerprint(raddr.NextHop.Zone)
+		}
+		return nil, errors.New("just a test")
+	}
+	defer rt.Close()
+
+	testCases := []struct {
+		Path         snet.Path // per-request override
+		Host         string
+		ExpectedPath snet.PathFingerprint
+		ExpectedErr  string
+		Sessions     int
+	}{
+		{nil, "1-ff00:0:110,127.0.0.1", "a", "just a test", 1},
+		{nil, "1-ff00:0:110,127.0.0.1:443", "a", "just a test", 1}, // same session, redialed as dialing it failed
+		{pathB, "1-ff00:0:110,127.0.0.1", "b", "just a test", 2},
+		{pathA, "1-ff00:0:110,127.0.0.2", "a", "just a test", 3},
+		{pathOther, "1-ff00:0:110,127.0.0.1", "", "cannot be used", 4},
+	}
+
+	for _, tc := range testCases {
+		dialedPath = ""
+		req, err := http.NewRequest(http.MethodGet, MangleSCIONAddrURL("https://"+tc.Host+"/hello"), nil)
+		if err != nil {
+			t.Fatalf("unexpected error creating request: %s", err)
+		}
+		if tc.Path != nil {
+			req = req.WithContext(WithPath(req.Context(), tc.Path))
+		}
+		_, err = rt.RoundTrip(req)
+		if err == nil || !strings.Contains(err.Error(), tc.ExpectedErr) {
+			t.Fatalf("unexpected error, actual='%v', expected='%s'", err, tc.ExpectedErr)
+		}
+		if dialedPath != tc.ExpectedPath {
+			t.Fatalf("unexpected path dialed, actual='%s', expected='%s'", dialedPath, tc.ExpectedPath)
+		}
+		if sessions := len(rt.(*roundTripper).pool.sessions); sessions != tc.Sessions {
+			t.Fatalf("unexpected number of sessions, actual='%d', expected='%d'", sessions, tc.Sessions)
+		}
+	}
+	// The path is selected again for the second request, as dialing failed
+	if selectorCalls != 2 {
+		t.Fatalf("unexpected number of path selector calls, actual='%d', expected='2'", selectorCalls)
+	}
+}
+
+func TestRoundTripperPathExpiry(t *testing.T) {
+
+	dst, _ := addr.IAFromString("1-ff00:0:110")
+	expired := &testPath{dst: dst, fingerprint: "a", expiry: time.Now().Add(-time.Minute)}
+	valid := &testPath{dst: dst, fingerprint: "b", expiry: time.Now().Add(time.Hour)}
+
+	var selectorCalls int
+	selector := func(ia addr.IA) (snet.Path, error) {
+		selectorCalls++
+		if selectorCalls == 1 {
+			return expired, nil
+		}
+		return valid, nil
+	}
+	rt := NewRoundTripperWithPathSelector(nil, nil, selector).(*roundTripper)
+	defer rt.Close()
+
+	authority := authorityAddr(mangleSCIONAddr("1-ff00:0:110,127.0.0.1"))
+	expectedPaths := []snet.Path{expired, valid, valid}
+	for i, expected := range expectedPaths {
+		path, err := rt.pathFor(context.Background(), authority)
+		if err != nil {
+			t.Fatalf("unexpected error selecting path: %s", err)
+		}
+		if path != expected {
+			t.Fatalf("unexpected path for request %d, actual='%s', expected='%s'", i, path.Fingerprint(), expected.Fingerprint())
+		}
+	}
+	if selectorCalls != 2 {
+		t.Fatalf("unexpected number of path selector calls, actual='%d', expected='2'", selectorCalls)
+	}
+}
+
 // hostURLPatterns returns a slice of URL patterns in which a host can be inserted
 func hostURLPatterns() []string {
 	return []string{
@@ -140,3 +294,22 @@ func hostURLPatterns() []string {
 		"https://user@%s/hello?boo=bla",
 	}
 }
+
+// testPath is a snet.Path with a given fingerprint. For easy inspection, the
+// fingerprint is also used as the zone of the overlay next hop.
+type testPath struct {
+	dst         addr.IA
+	fingerprint snet.PathFingerprint
+	expiry      time.Time
+}
+
+func (p *testPath) Fingerprint() snet.PathFingerprint { return p.fingerprint }
+func (p *testPath) OverlayNextHop() *net.UDPAddr {
+	return &net.UDPAddr{Zone: string(p.fingerprint)}
+}
+func (p *testPath) Path() *spath.Path                { return nil }
+func (p *testPath) Interfaces() []snet.PathInterface { return nil }
+func (p *testPath) Destination() addr.IA             { return p.dst }
+func (p *testPath) MTU() uint16                      { return 0 }
+func (p *testPath) Expiry() time.Time                { return p.expiry }
+func (p *testPath) Copy() snet.Path                  { c := *p; return &c }
@@ -0,0 +1,198 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// forwardproxy is a local HTTP forward proxy that lets unmodified HTTP clients
+// (browsers, curl, ...) access shttp servers in the SCION network.
+// Requests to SCION hosts are forwarded over SCION with shttp, all other
+// requests are passed through to the normal internet.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/netsec-ethz/scion-apps/pkg/appnet"
+	"github.com/netsec-ethz/scion-apps/pkg/shttp"
+	"github.com/scionproto/scion/go/lib/snet"
+)
+
+const (
+	// default port of shttp servers, used if the request does not specify a port
+	defaultSCIONPort = 443
+	// timeout for establishing tunnels for CONNECT requests
+	connectTimeout = 10 * time.Second
+)
+
+var verbose bool
+
+func main() {
+	listen := flag.String("listen", "127.0.0.1:8888", "Address (TCP/IP) the proxy listens on")
+	flag.BoolVar(&verbose, "v", false, "Log every proxied request")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: %s [flags]\n\n", os.Args[0])
+		fmt.Fprintln(os.Stderr, "HTTP forward proxy for accessing shttp servers in the SCION network.")
+		fmt.Fprintln(os.Stderr, "Requests to SCION hosts (SCION addresses or hostnames resolved by appnet)")
+		fmt.Fprintln(os.Stderr, "are forwarded over SCION, everything else is passed through.")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Example:")
+		fmt.Fprintln(os.Stderr, "  curl -x http://127.0.0.1:8888 http://host1/hello")
+		fmt.Fprintln(os.Stderr, "")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	scionTransport := shttp.NewRoundTripper(nil, nil)
+	defer scionTransport.Close()
+
+	proxy := &forwardProxy{
+		reverseProxy: &httputil.ReverseProxy{
+			Director: func(r *http.Request) {},
+			Transport: &transport{
+				scion: scionTransport,
+				ip:    http.DefaultTransport,
+			},
+		},
+	}
+
+	log.Printf("Listening on %s", *listen)
+	log.Fatal(http.ListenAndServe(*listen, proxy))
+}
+
+// forwardProxy is the http.Handler for the proxy. Plain proxy requests are
+// handled by reverseProxy, CONNECT requests are tunnelled over TCP/IP.
+type forwardProxy struct {
+	reverseProxy *httputil.ReverseProxy
+}
+
+func (p *forwardProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if verbose {
+		log.Printf("%s %s %s", r.RemoteAddr, r.Method, r.RequestURI)
+	}
+	if r.Method == http.MethodConnect {
+		p.serveConnect(w, r)
+		return
+	}
+	if !r.URL.IsAbs() {
+		http.Error(w, "this is a proxy, only requests with absolute URLs are supported", http.StatusBadRequest)
+		return
+	}
+	p.reverseProxy.ServeHTTP(w, r)
+}
+
+// serveConnect establishes a TCP tunnel for a CONNECT request.
+// Tunnels to SCION hosts are not supported, as shttp servers only speak
+// HTTP/3; clients need to use http:// URLs to let the proxy translate the
+// requests.
+func (p *forwardProxy) serveConnect(w http.ResponseWriter, r *http.Request) {
+	if _, err := resolveSCION(r.Host); err == nil {
+		http.Error(w, "CONNECT to SCION hosts is not supported, use http:// URLs instead", http.StatusNotImplemented)
+		return
+	}
+
+	remote, err := net.DialTimeout("tcp", r.Host, connectTimeout)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		remote.Close()
+		http.Error(w, "hijacking not supported", http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	client, _, err := hijacker.Hijack()
+	if err != nil {
+		remote.Close()
+		log.Printf("Error hijacking connection: %v", err)
+		return
+	}
+
+	closeBoth := func() {
+		client.Close()
+		remote.Close()
+	}
+	var once sync.Once
+	go func() {
+		_, _ = io.Copy(remote, client)
+		once.Do(closeBoth)
+	}()
+	go func() {
+		_, _ = io.Copy(client, remote)
+		once.Do(closeBoth)
+	}()
+}
+
+// transport is a http.RoundTripper that forwards requests to SCION hosts over
+// SCION and all others over TCP/IP.
+type transport struct {
+	scion http.RoundTripper
+	ip    http.RoundTripper
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	raddr, err := resolveSCION(req.URL.Host)
+	if err != nil {
+		return t.ip.RoundTrip(req)
+	}
+	if verbose {
+		log.Printf("Forwarding %s over SCION to %s", req.URL, raddr)
+	}
+	// shttp servers only speak HTTP/3, i.e. HTTPS. Send the request to the
+	// resolved address, the Host header still contains the original host name.
+	cpy := req.Clone(req.Context())
+	cpy.URL.Scheme = "https"
+	cpy.URL.Host = shttp.MangleSCIONAddrURL(raddr.String())
+	if cpy.Host == "" {
+		cpy.Host = req.URL.Host
+	}
+	return t.scion.RoundTrip(cpy)
+}
+
+// resolveSCION resolves hostport to a SCION address. The host can be a SCION
+// address, also in the mangled form produced by shttp.MangleSCIONAddrURL, or a
+// hostname resolved by appnet. If hostport does not contain a port, the
+// default shttp port is used.
+// An error is returned if host is not a SCION host.
+func resolveSCION(hostport string) (*snet.UDPAddr, error) {
+	// net.SplitHostPort can't split an unmangled SCION address with a port
+	if addr, err := snet.ParseUDPAddr(hostport); err == nil && addr.Host.Port != 0 {
+		return addr, nil
+	}
+	host, portStr, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+		portStr = strconv.Itoa(defaultSCIONPort)
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, err
+	}
+	// SplitHostPort removes the brackets around a mangled SCION address, so
+	// this also handles "[ISD-AS,IP]:port".
+	if addr, err := snet.ParseUDPAddr(host); err == nil {
+		addr.Host.Port = int(port)
+		return addr, nil
+	}
+	return appnet.ResolveUDPAddr(net.JoinHostPort(host, strconv.Itoa(int(port))))
+}
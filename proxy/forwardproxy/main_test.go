@@ -0,0 +1,202 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/netsec-ethz/scion-apps/pkg/appnet"
+)
+
+func TestResolveSCION(t *testing.T) {
+	_ = appnet.AddHost("scionhost", "17-ffaa:0:1,[192.168.1.1]")
+
+	testCases := []struct {
+		HostPort string
+		Expected string
+	}{
+		{"scionhost", "17-ffaa:0:1,192.168.1.1:443"},
+		{"scionhost:8080", "17-ffaa:0:1,192.168.1.1:8080"},
+		{"1-ff00:0:110,[127.0.0.1]", "1-ff00:0:110,127.0.0.1:443"},
+		{"1-ff00:0:110,[127.0.0.1]:80", "1-ff00:0:110,127.0.0.1:80"},
+		{"[1-ff00:0:110,127.0.0.1]:80", "1-ff00:0:110,127.0.0.1:80"},
+		{"[1-ff00:0:110,::1]:80", "1-ff00:0:110,[::1]:80"},
+		// not SCION hosts
+		{"example.com", ""},
+		{"example.com:80", ""},
+		{"127.0.0.1:80", ""},
+		{"[::1]:80", ""},
+		// malformed
+		{"", ""},
+		{"scionhost:http", ""},
+		{"scionhost:99999", ""},
+		{"1-ff00:0:110,[127.0.0.1]:http", ""},
+	}
+
+	for _, tc := range testCases {
+		addr, err := resolveSCION(tc.HostPort)
+		if tc.Expected == "" {
+			if err == nil {
+				t.Fatalf("expected error for '%s', actual='%s'", tc.HostPort, addr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error for '%s': %s", tc.HostPort, err)
+		}
+		if actual := addr.String(); actual != tc.Expected {
+			t.Fatalf("unexpected address for '%s', actual='%s', expected='%s'", tc.HostPort, actual, tc.Expected)
+		}
+	}
+}
+
+// recordingTransport records the requests it receives instead of sending them.
+type recordingTransport struct {
+	requests []*http.Request
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	t.requests = append(t.requests, req)
+	return nil, errors.New("just a test")
+}
+
+func TestTransport(t *testing.T) {
+	_ = appnet.AddHost("scionhost", "17-ffaa:0:1,[192.168.1.1]")
+
+	testCases := []struct {
+		Scheme      string
+		Host        string
+		SCION       bool
+		ExpectedURL string
+	}{
+		{"http", "scionhost", true, "https://[17-ffaa:0:1,192.168.1.1]:443/hello"},
+		{"http", "scionhost:8080", true, "https://[17-ffaa:0:1,192.168.1.1]:8080/hello"},
+		{"http", "[1-ff00:0:110,127.0.0.1]:80", true, "https://[1-ff00:0:110,127.0.0.1]:80/hello"},
+		{"http", "1-ff00:0:110,[::1]:80", true, "https://[1-ff00:0:110,::1]:80/hello"},
+		{"http", "example.com", false, "http://example.com/hello"},
+		{"https", "127.0.0.1:8443", false, "https://127.0.0.1:8443/hello"},
+		{"http", "scionhost:99999", false, "http://scionhost:99999/hello"},
+	}
+
+	for _, tc := range testCases {
+		scion := &recordingTransport{}
+		ip := &recordingTransport{}
+		tr := &transport{scion: scion, ip: ip}
+
+		// Newer versions of net/url don't parse SCION addresses in URLs
+		req := &http.Request{
+			Method: http.MethodGet,
+			URL:    &url.URL{Scheme: tc.Scheme, Host: tc.Host, Path: "/hello"},
+			Host:   tc.Host,
+			Header: make(http.Header),
+		}
+		_, _ = tr.RoundTrip(req)
+
+		used, unused := ip, scion
+		if tc.SCION {
+			used, unused = scion, ip
+		}
+		if len(used.requests) != 1 || len(unused.requests) != 0 {
+			t.Fatalf("wrong transport used for '%s', SCION expected: %v", tc.Host, tc.SCION)
+		}
+		sent := used.requests[0]
+		if actual := sent.URL.String(); actual != tc.ExpectedURL {
+			t.Fatalf("unexpected URL for '%s', actual='%s', expected='%s'", tc.Host, actual, tc.ExpectedURL)
+		}
+		if actual := sent.Host; actual != tc.Host {
+			t.Fatalf("unexpected Host header, actual='%s', expected='%s'", actual, tc.Host)
+		}
+		if req.URL.Host != tc.Host {
+			t.Fatalf("original request modified, actual='%s', expected='%s'", req.URL.Host, tc.Host)
+		}
+	}
+}
+
+func TestConnect(t *testing.T) {
+	_ = appnet.AddHost("scionhost", "17-ffaa:0:1,[192.168.1.1]")
+
+	// The tunnel target echoes a line
+	target, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error listening: %s", err)
+	}
+	defer target.Close()
+	go func() {
+		conn, err := target.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		line, _ := bufio.NewReader(conn).ReadString('\n')
+		_, _ = conn.Write([]byte(line))
+	}()
+
+	// CONNECT requests to SCION hosts are refused, to other hosts they are
+	// tunnelled. Newer versions of net/http don't parse SCION addresses in
+	// requests, so the requests are passed to the handler directly.
+	testCases := []struct {
+		Host     string
+		Expected int
+	}{
+		{"scionhost:443", http.StatusNotImplemented},
+		{"[1-ff00:0:110,127.0.0.1]:443", http.StatusNotImplemented},
+		{"1-ff00:0:110,[127.0.0.1]:443", http.StatusNotImplemented},
+		{"127.0.0.1:http", http.StatusBadGateway},
+		{"", http.StatusBadGateway},
+	}
+	for _, tc := range testCases {
+		r := &http.Request{
+			Method:     http.MethodConnect,
+			URL:        &url.URL{Host: tc.Host},
+			Host:       tc.Host,
+			Header:     make(http.Header),
+			RemoteAddr: "127.0.0.1:4242",
+		}
+		w := httptest.NewRecorder()
+		(&forwardProxy{}).ServeHTTP(w, r)
+		if w.Code != tc.Expected {
+			t.Fatalf("unexpected status for '%s', actual='%d', expected='%d'", tc.Host, w.Code, tc.Expected)
+		}
+	}
+
+	proxy := httptest.NewServer(&forwardProxy{})
+	defer proxy.Close()
+
+	conn, err := net.Dial("tcp", proxy.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unexpected error connecting to proxy: %s", err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target.Addr(), target.Addr())
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	if err != nil {
+		t.Fatalf("unexpected error reading response: %s", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status, actual='%d', expected='%d'", resp.StatusCode, http.StatusOK)
+	}
+	fmt.Fprintf(conn, "hello\n")
+	if line, err := reader.ReadString('\n'); err != nil || line != "hello\n" {
+		t.Fatalf("unexpected tunnel reply, actual='%s', expected='hello', error: %v", line, err)
+	}
+}
@@ -0,0 +1,263 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// reverseproxy publishes HTTP services that are only reachable over TCP/IP in
+// the SCION network. It accepts HTTP/3 requests over SCION and forwards them
+// to the configured backends.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lucas-clemente/quic-go/http3"
+	"github.com/netsec-ethz/scion-apps/pkg/shttp"
+	"github.com/scionproto/scion/go/lib/snet"
+)
+
+// route maps requests with a path prefix to a backend.
+type route struct {
+	prefix  string
+	backend *url.URL
+}
+
+// routes implements flag.Value for the repeatable -route flag
+type routes []route
+
+func (r *routes) String() string {
+	var s []string
+	for _, rt := range *r {
+		s = append(s, rt.prefix+"="+rt.backend.String())
+	}
+	return strings.Join(s, ",")
+}
+
+// Set parses a route of the form [/prefix=]backend-url
+func (r *routes) Set(value string) error {
+	prefix := "/"
+	backend := value
+	if strings.HasPrefix(value, "/") {
+		parts := strings.SplitN(value, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid route %q, expected /prefix=backend-url", value)
+		}
+		prefix, backend = parts[0], parts[1]
+	}
+	u, err := url.Parse(backend)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("invalid backend URL %q, expected http(s)://host[:port][/path]", backend)
+	}
+	*r = append(*r, route{prefix: prefix, backend: u})
+	return nil
+}
+
+func main() {
+	var backends routes
+	port := flag.Uint("p", 443, "Port the proxy listens on (SCION/UDP)")
+	flag.Var(&backends, "route", "Route requests to a backend, [/prefix=]http://host:port[/path]. Can be repeated; "+
+		"the route with the longest matching prefix is used")
+	shutdownTimeout := flag.Duration("shutdown-timeout", 10*time.Second, "Time to wait for running requests on shutdown")
+	verbose := flag.Bool("v", false, "Log every proxied request")
+	flag.Parse()
+
+	if len(backends) == 0 {
+		fmt.Fprintln(os.Stderr, "At least one -route is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	handler := newRoutingHandler(backends, *verbose)
+	gh := &gracefulHandler{handler: handler}
+	server := &shttp.Server{
+		Server: &http3.Server{
+			Server: &http.Server{
+				Addr:    fmt.Sprintf(":%d", *port),
+				Handler: gh,
+			},
+		},
+	}
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.Printf("Received %s, shutting down", sig)
+		if !gh.shutdown(*shutdownTimeout) {
+			log.Printf("Timeout waiting for running requests")
+		}
+		server.Close()
+	}()
+
+	log.Printf("Listening on port %d", *port)
+	err := server.ListenAndServe()
+	if err != nil && !errors.Is(err, http.ErrServerClosed) && !gh.isShuttingDown() {
+		log.Fatal(err)
+	}
+}
+
+// newRoutingHandler creates a handler that forwards requests to the backend of
+// the route with the longest matching path prefix.
+func newRoutingHandler(rts routes, verbose bool) http.Handler {
+	// sort by decreasing prefix length, so that the first match is the longest
+	sorted := make(routes, len(rts))
+	copy(sorted, rts)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return len(sorted[i].prefix) > len(sorted[j].prefix)
+	})
+	proxies := make([]*httputil.ReverseProxy, len(sorted))
+	for i, rt := range sorted {
+		proxies[i] = newReverseProxy(rt)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i, rt := range sorted {
+			if matchPrefix(r.URL.Path, rt.prefix) {
+				if verbose {
+					log.Printf("%s %s %s -> %s", r.RemoteAddr, r.Method, r.URL, rt.backend)
+				}
+				proxies[i].ServeHTTP(w, r)
+				return
+			}
+		}
+		http.NotFound(w, r)
+	})
+}
+
+// matchPrefix returns true if path is within the path prefix, i.e. the prefix
+// "/api" matches "/api" and "/api/x", but not "/apix".
+func matchPrefix(path, prefix string) bool {
+	if strings.HasSuffix(prefix, "/") {
+		return strings.HasPrefix(path, prefix)
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// newReverseProxy creates a proxy for the route. The route prefix is replaced
+// by the backend's path.
+func newReverseProxy(rt route) *httputil.ReverseProxy {
+	target := rt.backend
+	return &httputil.ReverseProxy{
+		Director: func(r *http.Request) {
+			r.URL.Scheme = target.Scheme
+			r.URL.Host = target.Host
+			r.URL.Path = singleJoiningSlash(target.Path, strings.TrimPrefix(r.URL.Path, strings.TrimSuffix(rt.prefix, "/")))
+			r.URL.RawPath = ""
+			if target.RawQuery == "" || r.URL.RawQuery == "" {
+				r.URL.RawQuery = target.RawQuery + r.URL.RawQuery
+			} else {
+				r.URL.RawQuery = target.RawQuery + "&" + r.URL.RawQuery
+			}
+			setForwardedHeaders(r)
+		},
+	}
+}
+
+// setForwardedHeaders sets the X-Forwarded-* headers, and appends the client
+// to the Forwarded header (RFC 7239) with its SCION address, as
+// for="ISD-AS,[IP]". The IP of a SCION address is only unique within its AS,
+// so it must not reach the backend on its own: X-Forwarded-For, which only
+// takes IP addresses, is removed instead, including any value sent by the
+// client. The nil value also keeps httputil.ReverseProxy from setting it.
+func setForwardedHeaders(r *http.Request) {
+	forwarded := fmt.Sprintf("host=%q;proto=https", r.Host)
+	if clientAddr, err := snet.ParseUDPAddr(r.RemoteAddr); err == nil {
+		forwarded = fmt.Sprintf("for=\"%s,[%s]\";%s", clientAddr.IA, clientAddr.Host.IP, forwarded)
+	}
+	if prior := r.Header["Forwarded"]; len(prior) > 0 {
+		forwarded = strings.Join(prior, ", ") + ", " + forwarded
+	}
+	r.Header["X-Forwarded-For"] = nil
+	r.Header.Set("Forwarded", forwarded)
+	r.Header.Set("X-Forwarded-Proto", "https")
+	if r.Header.Get("X-Forwarded-Host") == "" {
+		r.Header.Set("X-Forwarded-Host", r.Host)
+	}
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// gracefulHandler tracks running requests to allow a graceful shutdown.
+// After shutdown has been initiated, new requests are rejected.
+type gracefulHandler struct {
+	handler http.Handler
+
+	mutex    sync.Mutex
+	closing  bool
+	inFlight sync.WaitGroup
+}
+
+func (h *gracefulHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	h.mutex.Lock()
+	if h.closing {
+		h.mutex.Unlock()
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	h.inFlight.Add(1)
+	h.mutex.Unlock()
+	defer h.inFlight.Done()
+
+	h.handler.ServeHTTP(w, r)
+}
+
+// shutdown rejects new requests and waits for running requests to complete.
+// Returns false if the timeout expired first.
+func (h *gracefulHandler) shutdown(timeout time.Duration) bool {
+	h.mutex.Lock()
+	h.closing = true
+	h.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		h.inFlight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+func (h *gracefulHandler) isShuttingDown() bool {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.closing
+}
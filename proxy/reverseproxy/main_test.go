@@ -0,0 +1,179 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSetForwardedHeaders(t *testing.T) {
+	testCases := []struct {
+		RemoteAddr            string
+		PriorForwarded        string
+		ExpectedForwarded     string
+		ExpectedForwardedHost string
+	}{
+		{
+			"1-ff00:0:110,[10.0.0.1]:4242", "",
+			`for="1-ff00:0:110,[10.0.0.1]";host="example.org";proto=https`,
+			"example.org",
+		},
+		{
+			"1-ff00:0:110,[::1]:4242", `for=192.0.2.1`,
+			`for=192.0.2.1, for="1-ff00:0:110,[::1]";host="example.org";proto=https`,
+			"example.org",
+		},
+		{
+			"invalid", "",
+			`host="example.org";proto=https`,
+			"example.org",
+		},
+	}
+
+	for _, tc := range testCases {
+		r, err := http.NewRequest(http.MethodGet, "https://example.org/", nil)
+		if err != nil {
+			t.Fatalf("unexpected error creating request: %s", err)
+		}
+		r.RemoteAddr = tc.RemoteAddr
+		// a client may try to pass for a host in the backend's network
+		r.Header.Set("X-Forwarded-For", "127.0.0.1")
+		if tc.PriorForwarded != "" {
+			r.Header.Set("Forwarded", tc.PriorForwarded)
+		}
+		setForwardedHeaders(r)
+		if actual := r.Header.Get("X-Forwarded-For"); actual != "" {
+			t.Fatalf("unexpected X-Forwarded-For, actual='%s', expected=''", actual)
+		}
+		if actual := r.Header.Get("Forwarded"); actual != tc.ExpectedForwarded {
+			t.Fatalf("unexpected Forwarded, actual='%s', expected='%s'", actual, tc.ExpectedForwarded)
+		}
+		if actual := r.Header.Get("X-Forwarded-Host"); actual != tc.ExpectedForwardedHost {
+			t.Fatalf("unexpected X-Forwarded-Host, actual='%s', expected='%s'", actual, tc.ExpectedForwardedHost)
+		}
+		if actual := r.Header.Get("X-Forwarded-Proto"); actual != "https" {
+			t.Fatalf("unexpected X-Forwarded-Proto, actual='%s', expected='https'", actual)
+		}
+	}
+}
+
+// newTestBackend starts a backend that replies with its name, the requested
+// URI and the X-Forwarded-For header.
+func newTestBackend(name string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s %s %s", name, r.URL.RequestURI(), r.Header.Get("X-Forwarded-For"))
+	}))
+}
+
+func TestRoutingHandler(t *testing.T) {
+	root := newTestBackend("root")
+	defer root.Close()
+	api := newTestBackend("api")
+	defer api.Close()
+
+	var rts routes
+	for _, rt := range []string{root.URL, "/api=" + api.URL + "/v1?key=1", "/api/static/=" + root.URL + "/files"} {
+		if err := rts.Set(rt); err != nil {
+			t.Fatalf("unexpected error parsing route %s: %s", rt, err)
+		}
+	}
+	handler := newRoutingHandler(rts, false)
+
+	testCases := []struct {
+		Path     string
+		Expected string
+	}{
+		{"/", "root / "},
+		{"/index.html?x=y", "root /index.html?x=y "},
+		{"/api", "api /v1/?key=1 "},
+		{"/api/users?id=2", "api /v1/users?key=1&id=2 "},
+		{"/apix", "root /apix "},
+		{"/api/static/a.css", "root /files/a.css "},
+	}
+	for _, tc := range testCases {
+		r := httptest.NewRequest(http.MethodGet, "https://example.org"+tc.Path, nil)
+		r.RemoteAddr = "1-ff00:0:110,[127.0.0.1]:4242"
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("unexpected status for %s, actual='%d'", tc.Path, w.Code)
+		}
+		if actual := w.Body.String(); actual != tc.Expected {
+			t.Fatalf("unexpected response for %s, actual='%s', expected='%s'", tc.Path, actual, tc.Expected)
+		}
+	}
+}
+
+func TestRoutingHandlerNoRoute(t *testing.T) {
+	var rts routes
+	if err := rts.Set("/api=http://127.0.0.1:1"); err != nil {
+		t.Fatalf("unexpected error parsing route: %s", err)
+	}
+	w := httptest.NewRecorder()
+	newRoutingHandler(rts, false).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "https://example.org/other", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected status, actual='%d', expected='%d'", w.Code, http.StatusNotFound)
+	}
+}
+
+func TestRoutesSet(t *testing.T) {
+	for _, value := range []string{"/api", "ftp://host", "/api=http://", "not a url"} {
+		var rts routes
+		if err := rts.Set(value); err == nil {
+			t.Fatalf("expected error for route %q", value)
+		}
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	gh := &gracefulHandler{handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})}
+
+	running := make(chan struct{})
+	go func() {
+		gh.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		close(running)
+	}()
+	<-started
+
+	// Running requests delay the shutdown until they complete or the timeout
+	if gh.shutdown(10 * time.Millisecond) {
+		t.Fatalf("shutdown did not wait for the running request")
+	}
+	if !gh.isShuttingDown() {
+		t.Fatalf("handler not shutting down")
+	}
+
+	// New requests are rejected
+	w := httptest.NewRecorder()
+	gh.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status, actual='%d', expected='%d'", w.Code, http.StatusServiceUnavailable)
+	}
+
+	close(release)
+	<-running
+	if !gh.shutdown(time.Second) {
+		t.Fatalf("shutdown timed out without running requests")
+	}
+}
@@ -16,38 +16,47 @@ package clientconfig
 
 // ClientConfig is a struct containing configuration for the client.
 type ClientConfig struct {
-	User                   string   `regex:".*"`
-	HostAddress            string   `regex:"([-.\\da-zA-Z]+)|(\\d+-[\\d:A-Fa-f]+,\\[[^\\]]+\\])"`
-	Port                   string   `regex:"0*([0-5]?\\d{0,4}|6([0-4]\\d{3}|5([0-4]\\d{2}|5([0-2]\\d|3[0-5]))))"`
-	PasswordAuthentication string   `regex:"(yes|no)"`
-	PubkeyAuthentication   string   `regex:"(yes|no)"`
-	StrictHostKeyChecking  string   `regex:"(yes|no|ask)"`
-	IdentityFile           []string `regex:".*"`
-	LocalForward           string   `regex:".*"`
-	RemoteForward          string   `regex:".*"`
-	UserKnownHostsFile     string   `regex:".*"`
-	ProxyCommand           string   `regex:".*"`
+	User                         string   `regex:".*"`
+	HostAddress                  string   `regex:"([-.\\da-zA-Z]+)|(\\d+-[\\d:A-Fa-f]+,\\[[^\\]]+\\])" alias:"HostName"`
+	Port                         string   `regex:"0*([0-5]?\\d{0,4}|6([0-4]\\d{3}|5([0-4]\\d{2}|5([0-2]\\d|3[0-5]))))"`
+	PasswordAuthentication       string   `regex:"(yes|no)"`
+	PubkeyAuthentication         string   `regex:"(yes|no)"`
+	KbdInteractiveAuthentication string   `regex:"(yes|no)"`
+	PreferredAuthentications     string   `regex:"[-a-z]+(,[-a-z]+)*"`
+	StrictHostKeyChecking        string   `regex:"(yes|no|ask)"`
+	IdentityFile                 []string `regex:".*"`
+	LocalForward                 []string `regex:".*"`
+	RemoteForward                string   `regex:".*"`
+	DynamicForward               string   `regex:"0*([0-5]?\\d{0,4}|6([0-4]\\d{3}|5([0-4]\\d{2}|5([0-2]\\d|3[0-5]))))"`
+	UserKnownHostsFile           string   `regex:".*"`
+	ProxyCommand                 string   `regex:".*"`
+	ProxyJump                    string   `regex:".*"`
+	SendEnv                      []string `regex:".*"`
+	ForwardAgent                 string   `regex:"(yes|no)"`
+	ControlMaster                string   `regex:"(yes|no|auto)"`
+	ControlPath                  string   `regex:".*"`
+	ControlPersist               string   `regex:"(yes|no|(\\d+[sSmMhHdDwW]?)+)"`
 }
 
 // Create creates a new ClientConfig with the default values.
 func Create() *ClientConfig {
 	return &ClientConfig{
-		User:                   "",
-		HostAddress:            "",
-		Port:                   "22",
-		PasswordAuthentication: "yes",
-		PubkeyAuthentication:   "yes",
-		StrictHostKeyChecking:  "ask",
-		UserKnownHostsFile:     "~/.ssh/known_hosts",
-		IdentityFile: []string{
-			"~/.ssh/id_ed25519",
-			"~/.ssh/id_ecdsa",
-			"~/.ssh/id_dsa",
-			"~/.ssh/id_rsa",
-			"~/.ssh/identity",
-		},
-		LocalForward:  "",
-		RemoteForward: "",
-		ProxyCommand:  "",
+		User:                         "",
+		HostAddress:                  "",
+		Port:                         "22",
+		PasswordAuthentication:       "yes",
+		PubkeyAuthentication:         "yes",
+		KbdInteractiveAuthentication: "yes",
+		PreferredAuthentications:     "publickey,keyboard-interactive,password",
+		StrictHostKeyChecking:        "ask",
+		UserKnownHostsFile:           "~/.ssh/known_hosts",
+		RemoteForward:                "",
+		DynamicForward:               "",
+		ProxyCommand:                 "",
+		ProxyJump:                    "",
+		ForwardAgent:                 "no",
+		ControlMaster:                "no",
+		ControlPath:                  "none",
+		ControlPersist:               "no",
 	}
 }
@@ -68,16 +68,19 @@ func TestDefaultConfig(t *testing.T) {
 
 		Convey("The new values are read correctly", func() {
 			conf := &ClientConfig{}
-			config.UpdateFromReader(conf, strings.NewReader(configString))
+			err := config.UpdateFromReader(conf, strings.NewReader(configString))
+			So(err, ShouldBeNil)
 			So(conf.HostAddress, ShouldEqual, "")
 			So(conf.PasswordAuthentication, ShouldEqual, "no")
 			So(conf.StrictHostKeyChecking, ShouldEqual, "no")
 			So(conf.Port, ShouldEqual, "65535")
-			So(conf.IdentityFile[len(conf.IdentityFile)-1], ShouldEqual, "~/.ssh/identity")
-			So(conf.IdentityFile[len(conf.IdentityFile)-2], ShouldEqual, "~/.ssh/id_rsa")
-			So(conf.IdentityFile[len(conf.IdentityFile)-3], ShouldEqual, "~/.ssh/id_dsa")
-			So(conf.IdentityFile[len(conf.IdentityFile)-4], ShouldEqual, "~/.ssh/id_ecdsa")
-			So(conf.IdentityFile[len(conf.IdentityFile)-5], ShouldEqual, "~/.ssh/id_ed25519")
+			So(conf.IdentityFile, ShouldResemble, []string{
+				"~/.ssh/identity",
+				"~/.ssh/id_rsa",
+				"~/.ssh/id_dsa",
+				"~/.ssh/id_ecdsa",
+				"~/.ssh/id_ed25519",
+			})
 		})
 
 	})
@@ -111,3 +114,55 @@ func TestPortRegex(t *testing.T) {
 
 	})
 }
+
+func TestLocalForward(t *testing.T) {
+	Convey("Given a config file with multiple LocalForward options", t, func() {
+		configString := `
+			LocalForward 8080 example.com:80
+			LocalForward 127.0.0.1:8443:example.com:443
+		`
+
+		Convey("All forwards are read", func() {
+			conf := Create()
+			config.UpdateFromReader(conf, strings.NewReader(configString))
+			So(len(conf.LocalForward), ShouldEqual, 2)
+			So(conf.LocalForward, ShouldContain, "8080 example.com:80")
+			So(conf.LocalForward, ShouldContain, "127.0.0.1:8443:example.com:443")
+		})
+	})
+}
+
+func TestHostBlocks(t *testing.T) {
+	Convey("Given a config file with Host blocks", t, func() {
+		configString := `
+			Host server
+			    HostName 17-ffaa:1:1,[10.0.0.1]
+			    Port 2222
+			Host 17-ffaa:1:*
+			    User scion
+			Host *
+			    Port 22
+			    User nobody
+		`
+
+		Convey("The host alias is resolved", func() {
+			conf := Create()
+			ctx := &config.Context{Host: "server"}
+			err := config.UpdateFromReaderWithContext(conf, strings.NewReader(configString), "config", ctx)
+			So(err, ShouldBeNil)
+			So(conf.HostAddress, ShouldEqual, "17-ffaa:1:1,[10.0.0.1]")
+			So(conf.Port, ShouldEqual, "2222")
+			So(conf.User, ShouldEqual, "nobody")
+		})
+
+		Convey("SCION addresses are matched", func() {
+			conf := Create()
+			ctx := &config.Context{Host: "17-ffaa:1:2,[10.0.0.2]"}
+			err := config.UpdateFromReaderWithContext(conf, strings.NewReader(configString), "config", ctx)
+			So(err, ShouldBeNil)
+			So(conf.HostAddress, ShouldEqual, "")
+			So(conf.Port, ShouldEqual, "22")
+			So(conf.User, ShouldEqual, "scion")
+		})
+	})
+}
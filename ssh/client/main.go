@@ -26,7 +26,6 @@ import (
 	"strings"
 
 	log "github.com/inconshreveable/log15"
-	"golang.org/x/crypto/ssh/terminal"
 	"gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/scionproto/scion/go/lib/pathpol"
@@ -40,15 +39,21 @@ import (
 
 var (
 	// Connection
-	serverAddress = kingpin.Arg("host-address", "Server SCION address (without the port)").Required().String()
-	runCommand    = kingpin.Arg("command", "Command to run (empty for pty)").Strings()
-	port          = kingpin.Flag("port", "The server's port").Default("0").Short('p').Uint16()
-	localForward  = kingpin.Flag("local-forward", "Forward remote address connections to listening port. Format: listening_port:remote_address").Short('L').String()
-	options       = kingpin.Flag("option", "Set an option").Short('o').Strings()
-	configFiles   = kingpin.Flag("config", "Configuration files").Short('c').Default("/etc/ssh/ssh_config", "~/.ssh/config").Strings()
-	policyFile    = kingpin.Flag("policy-file", "Path to the JSON policy file").Default("").String()
-	policyName    = kingpin.Flag("policy-name", "Name of policy to be applied.").Default("").String()
-	pathSelection = kingpin.Flag("selection", "Path selection mode").Default("arbitrary").Enum("static", "arbitrary", "random", "round-robin")
+	serverAddress  = kingpin.Arg("host-address", "Server SCION address (without the port)").Required().String()
+	runCommand     = kingpin.Arg("command", "Command to run (empty for pty)").Strings()
+	port           = kingpin.Flag("port", "The server's port").Default("0").Short('p').Uint16()
+	localForwards  = kingpin.Flag("local-forward", "Forward connections to listening port to remote address over the server. Format: [bind_address:]listening_port:host:hostport").Short('L').Strings()
+	remoteForward  = kingpin.Flag("remote-forward", "Forward connections to the server's listening port to local address. Format: [bind_address:]listening_port:host:hostport").Short('R').String()
+	dynamicForward = kingpin.Flag("dynamic-forward", "Run a SOCKS5 proxy on listening port, connecting over the server").Short('D').Uint16()
+	jumpHosts      = kingpin.Flag("jump-host", "Connect over the comma-separated jump hosts, each given as [user@]host[:port]").Short('J').String()
+	forwardAgent   = kingpin.Flag("forward-agent", "Forward the connection to the authentication agent").Short('A').Bool()
+	controlMaster  = kingpin.Flag("master", "Start a control master in the background, if none is listening on the control path").Short('M').Bool()
+	controlPath    = kingpin.Flag("control-path", "Unix socket of the control master sharing its connection").Short('S').String()
+	options        = kingpin.Flag("option", "Set an option").Short('o').Strings()
+	configFiles    = kingpin.Flag("config", "Configuration files").Short('c').Default("/etc/ssh/ssh_config", "~/.ssh/config").Strings()
+	policyFile     = kingpin.Flag("policy-file", "Path to the JSON policy file").Default("").String()
+	policyName     = kingpin.Flag("policy-name", "Name of policy to be applied.").Default("").String()
+	pathSelection  = kingpin.Flag("selection", "Path selection mode").Default("arbitrary").Enum("static", "arbitrary", "random", "round-robin")
 
 	// TODO: additional file paths
 	knownHostsFile = kingpin.Flag("known-hosts", "File where known hosts are stored").ExistingFile()
@@ -57,32 +62,6 @@ var (
 	loginName = kingpin.Flag("login-name", "Username to login with").String()
 )
 
-// PromptPassword prompts the user for a password to authenticate with.
-func PromptPassword() (secret string, err error) {
-	fmt.Printf("Password: ")
-	password, _ := terminal.ReadPassword(0)
-	fmt.Println()
-	return string(password), nil
-}
-
-// PromptAcceptHostKey prompts the user to accept or reject the given host key.
-func PromptAcceptHostKey(hostname string, remote net.Addr, publicKey string) bool {
-	for {
-		fmt.Printf("Key fingerprint SHA256 is: %s do you recognize it? (y/n) ", publicKey)
-		var answer string
-		fmt.Scanln(&answer)
-		answer = strings.ToLower(answer)
-		if strings.HasPrefix(answer, "y") {
-			fmt.Printf("Alright, adding %s to the list of known hosts", publicKey)
-			return true
-		} else if strings.HasPrefix(answer, "n") {
-			return false
-		} else {
-			fmt.Printf("Not a valid answer. Try again")
-		}
-	}
-}
-
 func setConfIfNot(conf *clientconfig.ClientConfig, name string, value, not interface{}) bool {
 	res, err := config.SetIfNot(conf, name, value, not)
 	if err != nil {
@@ -91,32 +70,57 @@ func setConfIfNot(conf *clientconfig.ClientConfig, name string, value, not inter
 	return res
 }
 
-func createConfig() *clientconfig.ClientConfig {
+func createConfig(localUser string) *clientconfig.ClientConfig {
 	conf := clientconfig.Create()
 
+	ctx := &config.Context{
+		Host:      *serverAddress,
+		User:      *loginName,
+		LocalUser: localUser,
+	}
 	for _, configFile := range *configFiles {
-		updateConfigFromFile(conf, configFile)
+		updateConfigFromFile(conf, configFile, ctx)
 	}
 
 	for _, option := range *options {
 		err := config.UpdateFromString(conf, option)
 		if err != nil {
-			log.Debug("Error updating config from --option flag: %v", err)
+			golog.Panicf("Error updating config from --option flag: %v", err)
 		}
 	}
 
 	setConfIfNot(conf, "Port", *port, 0)
-	setConfIfNot(conf, "HostAddress", *serverAddress, "")
-	setConfIfNot(conf, "IdentityFile", *identityFile, "")
-	setConfIfNot(conf, "LocalForward", *localForward, "")
+	// The host address may be set by HostName in a Host block
+	if conf.HostAddress == "" {
+		setConfIfNot(conf, "HostAddress", *serverAddress, "")
+	}
+	if *identityFile != "" {
+		conf.IdentityFile = append([]string{*identityFile}, conf.IdentityFile...)
+	}
+	for _, localForward := range *localForwards {
+		setConfIfNot(conf, "LocalForward", localForward, "")
+	}
+	if *jumpHosts != "" {
+		conf.ProxyCommand = ""
+		setConfIfNot(conf, "ProxyJump", *jumpHosts, "")
+	}
+	setConfIfNot(conf, "RemoteForward", *remoteForward, "")
+	setConfIfNot(conf, "DynamicForward", *dynamicForward, 0)
+	if *forwardAgent {
+		setConfIfNot(conf, "ForwardAgent", "yes", "")
+	}
+	if *controlMaster {
+		setConfIfNot(conf, "ControlMaster", "yes", "")
+	}
+	setConfIfNot(conf, "ControlPath", *controlPath, "")
 	setConfIfNot(conf, "User", *loginName, "")
-	setConfIfNot(conf, "KnownHostsFile", *knownHostsFile, "")
+	setConfIfNot(conf, "UserKnownHostsFile", *knownHostsFile, "")
 
 	return conf
 }
 
-func updateConfigFromFile(conf *clientconfig.ClientConfig, pth string) {
-	err := config.UpdateFromFile(conf, utils.ParsePath(pth))
+func updateConfigFromFile(conf *clientconfig.ClientConfig, pth string, ctx *config.Context) {
+	err := config.UpdateFromFileWithContext(conf, utils.ParsePath(pth), ctx)
 	if err != nil {
 		if !os.IsNotExist(err) {
 			golog.Panicf("Error updating config from file %s: %v", pth, err)
@@ -127,14 +131,14 @@ func updateConfigFromFile(conf *clientconfig.ClientConfig, pth string) {
 func main() {
 	kingpin.Parse()
 
-	conf := createConfig()
-
 	localUser, err := user.Current()
 	if err != nil {
 		golog.Panicf("Can't find current user: %s", err)
 	}
 
-	verifyNewKeyHandler := PromptAcceptHostKey
+	conf := createConfig(localUser.Username)
+
+	verifyNewKeyHandler := ssh.PromptAcceptHostKey
 	if conf.StrictHostKeyChecking == "yes" {
 		verifyNewKeyHandler = func(hostname string, remote net.Addr, key string) bool {
 			return false
@@ -168,38 +172,81 @@ func main() {
 		golog.Panicf("Invalid application config: %v", err)
 	}
 
-	sshClient, err := ssh.Create(remoteUsername, conf, PromptPassword, verifyNewKeyHandler, appConf)
+	sshClient, err := ssh.Create(remoteUsername, conf, ssh.PromptPassword, ssh.PromptKeyboardInteractive, ssh.PromptPassphrase, verifyNewKeyHandler, appConf)
 	if err != nil {
 		golog.Panicf("Error creating ssh client: %v", err)
 	}
 
 	serverAddress := fmt.Sprintf("%s:%v", conf.HostAddress, conf.Port)
 
+	// Started in the background by another client, to share the connection
+	if ssh.IsControlMaster() {
+		if err := sshClient.RunControlMaster(serverAddress); err != nil {
+			golog.Fatalf("Error running control master: %v", err)
+		}
+		return
+	}
+
 	err = sshClient.Connect(serverAddress)
 	if err != nil {
 		golog.Panicf("Error connecting: %v", err)
 	}
 	defer sshClient.CloseSession()
 
-	if conf.LocalForward != "" {
-		localForward := strings.SplitN(conf.LocalForward, ":", 2)
+	sshClient.SendEnv(conf.SendEnv)
 
-		port, err := strconv.ParseUint(localForward[0], 10, 16)
+	if conf.ForwardAgent == "yes" {
+		err = sshClient.ForwardAgent()
 		if err != nil {
-			golog.Panicf("Error parsing forwarding port: %v", err)
+			log.Debug("Could not forward agent", "error", err)
 		}
+	}
 
-		err = sshClient.StartTunnel(uint16(port), localForward[1])
+	for _, localForward := range conf.LocalForward {
+		fwd, err := ssh.ParseForward(localForward)
+		if err != nil {
+			golog.Panicf("Error parsing forwarding: %v", err)
+		}
+
+		err = sshClient.StartTunnel(fwd.BindAddress, fwd.Port, fwd.Address)
 		if err != nil {
 			golog.Panicf("Error starting tunnel: %v", err)
 		}
 	}
 
+	if conf.RemoteForward != "" {
+		fwd, err := ssh.ParseRemoteForward(conf.RemoteForward)
+		if err != nil {
+			golog.Panicf("Error parsing remote forwarding: %v", err)
+		}
+
+		err = sshClient.StartRemoteTunnel(fwd.BindAddress, fwd.Port, fwd.Address)
+		if err != nil {
+			golog.Panicf("Error starting remote tunnel: %v", err)
+		}
+	}
+
+	if conf.DynamicForward != "" {
+		port, err := strconv.ParseUint(conf.DynamicForward, 10, 16)
+		if err != nil {
+			golog.Panicf("Error parsing dynamic forwarding port: %v", err)
+		}
+
+		err = sshClient.StartSOCKSProxy(uint16(port))
+		if err != nil {
+			golog.Panicf("Error starting SOCKS proxy: %v", err)
+		}
+	}
+
 	// TODO Don't just join those!
 	runCommand := strings.Join((*runCommand)[:], " ")
 
 	if runCommand == "" {
 		err = sshClient.Shell()
+		if status, ok := ssh.ExitStatus(err); ok {
+			sshClient.CloseSession()
+			os.Exit(status)
+		}
 		if err != nil {
 			golog.Panicf("Error starting shell: %v", err)
 		}
@@ -217,6 +264,10 @@ func main() {
 		}
 
 		err = sshClient.WaitSession()
+		if status, ok := ssh.ExitStatus(err); ok {
+			sshClient.CloseSession()
+			os.Exit(status)
+		}
 		if err != nil {
 			golog.Panicf("Error waiting for command to complete: %v", err)
 		}
@@ -0,0 +1,82 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+
+	log "github.com/inconshreveable/log15"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+const agentSocketEnv = "SSH_AUTH_SOCK"
+
+// connectAgent connects to the agent at SSH_AUTH_SOCK. It returns nil if
+// there is no agent.
+func connectAgent() agent.ExtendedAgent {
+	socket := os.Getenv(agentSocketEnv)
+	if socket == "" {
+		return nil
+	}
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		log.Debug("Could not connect to agent", "socket", socket, "error", err)
+		return nil
+	}
+	return agent.NewClient(conn)
+}
+
+// agentSigners returns the signers of the keys held by the agent.
+func (client *Client) agentSigners() []ssh.Signer {
+	if client.agentClient == nil {
+		return nil
+	}
+	signers, err := client.agentClient.Signers()
+	if err != nil {
+		log.Debug("Could not get keys from agent", "error", err)
+		return nil
+	}
+	return signers
+}
+
+// agentHasKey returns whether the agent holds the private key of pubKey.
+func (client *Client) agentHasKey(pubKey ssh.PublicKey) bool {
+	for _, signer := range client.agentSigners() {
+		if bytes.Equal(signer.PublicKey().Marshal(), pubKey.Marshal()) {
+			return true
+		}
+	}
+	return false
+}
+
+// ForwardAgent forwards the agent of the client to the session, which the
+// server then exposes to the session's commands. It must be called before the
+// session is started.
+func (client *Client) ForwardAgent() error {
+	socket := os.Getenv(agentSocketEnv)
+	if socket == "" {
+		return fmt.Errorf("no agent to forward, %s is not set", agentSocketEnv)
+	}
+	err := agent.ForwardToRemote(client.client, socket)
+	if err != nil {
+		return err
+	}
+	return agent.RequestAgentForwarding(client.session)
+}
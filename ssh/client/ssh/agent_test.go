@@ -0,0 +1,109 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+func TestLoadPrivateKey(t *testing.T) {
+	Convey("Given a passphrase-protected private key", t, func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+		der, err := x509.MarshalECPrivateKey(key)
+		So(err, ShouldBeNil)
+		block, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", der, []byte("secret"), x509.PEMCipherAES256)
+		So(err, ShouldBeNil)
+
+		dir, err := ioutil.TempDir("", "identity")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		file := filepath.Join(dir, "id_ecdsa")
+		So(ioutil.WriteFile(file, pem.EncodeToMemory(block), 0600), ShouldBeNil)
+
+		client := &Client{}
+		var prompted string
+		passphrase := func(secret string) PassphraseHandler {
+			return func(file string) ([]byte, error) {
+				prompted = file
+				return []byte(secret), nil
+			}
+		}
+
+		Convey("It is decrypted with the passphrase", func() {
+			signers, err := client.loadPrivateKey(file, passphrase("secret"))
+			So(err, ShouldBeNil)
+			So(prompted, ShouldEqual, file)
+			So(len(signers), ShouldEqual, 1)
+			pubKey, err := ssh.NewPublicKey(&key.PublicKey)
+			So(err, ShouldBeNil)
+			So(signers[0].PublicKey().Marshal(), ShouldResemble, pubKey.Marshal())
+		})
+
+		Convey("A wrong passphrase is an error", func() {
+			_, err := client.loadPrivateKey(file, passphrase("wrong"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("A failing prompt is an error", func() {
+			_, err := client.loadPrivateKey(file, func(string) ([]byte, error) {
+				return nil, errors.New("no terminal")
+			})
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestAgentSigners(t *testing.T) {
+	Convey("Given a client connected to an agent", t, func() {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		So(err, ShouldBeNil)
+		keyring := agent.NewKeyring()
+		So(keyring.Add(agent.AddedKey{PrivateKey: key}), ShouldBeNil)
+
+		clientConn, agentConn := net.Pipe()
+		defer clientConn.Close()
+		go agent.ServeAgent(keyring, agentConn)
+		client := &Client{agentClient: agent.NewClient(clientConn)}
+
+		Convey("The keys of the agent are offered", func() {
+			signers := client.agentSigners()
+			So(len(signers), ShouldEqual, 1)
+			pubKey, err := ssh.NewPublicKey(&key.PublicKey)
+			So(err, ShouldBeNil)
+			So(client.agentHasKey(pubKey), ShouldBeTrue)
+		})
+	})
+
+	Convey("A client without agent offers no keys", t, func() {
+		client := &Client{}
+		So(client.agentSigners(), ShouldBeEmpty)
+	})
+}
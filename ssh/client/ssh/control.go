@@ -0,0 +1,582 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha1"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/user"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+
+	"github.com/netsec-ethz/scion-apps/pkg/appnet"
+	"github.com/netsec-ethz/scion-apps/ssh/config"
+	"github.com/netsec-ethz/scion-apps/ssh/utils"
+)
+
+const (
+	// controlMasterEnv is set for the process started as control master. It
+	// signals that it is ready by writing to file descriptor 3.
+	controlMasterEnv = "SCION_SSH_CONTROL_MASTER"
+	// controlMasterStartTimeout is how long a new control master waits for
+	// its first client, unless it persists forever.
+	controlMasterStartTimeout = 30 * time.Second
+)
+
+// forwardRequests are the global requests for remote forwardings, with the
+// type of the channels they result in and the request cancelling them.
+var forwardRequests = map[string]struct {
+	channelType   string
+	cancelRequest string
+}{
+	"tcpip-forward":     {"forwarded-tcpip", "cancel-tcpip-forward"},
+	"scionquic-forward": {"forwarded-scionquic", "cancel-scionquic-forward"},
+}
+
+// IsControlMaster returns whether this process was started as control master,
+// which should call RunControlMaster instead of Connect.
+func IsControlMaster() bool {
+	return os.Getenv(controlMasterEnv) != ""
+}
+
+// parseControlPersist parses ControlPersist, how long the control master
+// stays idle before exiting. "yes" and 0 persist forever, which is
+// represented by a negative duration, "no" exits once the last client is
+// gone.
+func parseControlPersist(option string) (time.Duration, error) {
+	switch option {
+	case "yes":
+		return -1, nil
+	case "no", "":
+		return 0, nil
+	}
+	d, err := config.ParseTime(option)
+	if err != nil {
+		return 0, fmt.Errorf("invalid ControlPersist %q", option)
+	}
+	if d == 0 {
+		return -1, nil
+	}
+	return d, nil
+}
+
+// controlSocket returns the ControlPath for a connection to addr.
+func (client *Client) controlSocket(addr string) (string, error) {
+	host, port, err := appnet.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+	return utils.ParsePath(expandControlPath(client.controlPath, host, port, client.config.User)), nil
+}
+
+// expandControlPath replaces %h, %p and %r in path by host, port and the
+// remote user, %l and %L by the local host name and its first component, %u
+// by the local user, %C by a hash of %l%h%p%r, and %% by %.
+func expandControlPath(path, host, port, remoteUser string) string {
+	localHost, _ := os.Hostname()
+	localUser := ""
+	if u, err := user.Current(); err == nil {
+		localUser = u.Username
+	}
+
+	var expanded strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] != '%' || i+1 == len(path) {
+			expanded.WriteByte(path[i])
+			continue
+		}
+		i++
+		switch path[i] {
+		case 'h':
+			expanded.WriteString(host)
+		case 'p':
+			expanded.WriteString(port)
+		case 'r':
+			expanded.WriteString(remoteUser)
+		case 'l':
+			expanded.WriteString(localHost)
+		case 'L':
+			expanded.WriteString(strings.SplitN(localHost, ".", 2)[0])
+		case 'u':
+			expanded.WriteString(localUser)
+		case 'C':
+			fmt.Fprintf(&expanded, "%x", sha1.Sum([]byte(localHost+host+port+remoteUser)))
+		case '%':
+			expanded.WriteByte('%')
+		default:
+			expanded.WriteByte('%')
+			expanded.WriteByte(path[i])
+		}
+	}
+	return expanded.String()
+}
+
+// dialControlMaster connects to addr over the control master listening on
+// the ControlPath. If there is none, a new control master is started if
+// ControlMaster is yes or auto. It returns nil if no control master is used.
+func (client *Client) dialControlMaster(addr string) (*ssh.Client, error) {
+	if client.controlPath == "" {
+		return nil, nil
+	}
+	path, err := client.controlSocket(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	sshClient, err := dialControlSocket(path, addr, client.config.User)
+	if err == nil {
+		log.Debug("Connected over control master", "path", path)
+		return sshClient, nil
+	}
+	if client.controlMaster != "yes" && client.controlMaster != "auto" {
+		log.Debug("No control master, connecting directly", "path", path, "error", err)
+		return nil, nil
+	}
+
+	if err := startControlMaster(); err != nil {
+		return nil, err
+	}
+	return dialControlSocket(path, addr, client.config.User)
+}
+
+// dialControlSocket connects to the control master at path. The control
+// master must run as the same user, so it is trusted without host key.
+func dialControlSocket(path, addr, user string) (*ssh.Client, error) {
+	conn, err := net.Dial("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPeer(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, &ssh.ClientConfig{
+		User:            user,
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// startControlMaster runs this program again with the same arguments as
+// control master in the background, and waits until it is ready. The control
+// master authenticates on the terminal, if there is one.
+func startControlMaster() error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(executable, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), controlMasterEnv+"=1")
+	cmd.ExtraFiles = []*os.File{readyWriter}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0); err == nil {
+		defer tty.Close()
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = tty, tty, tty
+	}
+	err = cmd.Start()
+	readyWriter.Close()
+	if err != nil {
+		return fmt.Errorf("failed starting control master: %v", err)
+	}
+
+	if _, err := ready.Read(make([]byte, 1)); err != nil {
+		cmd.Wait()
+		return fmt.Errorf("control master failed to start")
+	}
+	return cmd.Process.Release()
+}
+
+// RunControlMaster connects to addr and serves the connection on the
+// ControlPath, until it is closed or no longer used. It is called instead of
+// Connect in a process started as control master, see IsControlMaster.
+func (client *Client) RunControlMaster(addr string) error {
+	os.Unsetenv(controlMasterEnv)
+	ready := os.NewFile(3, "ready")
+
+	path, err := client.controlSocket(addr)
+	if err != nil {
+		return fmt.Errorf("invalid ControlPath: %v", err)
+	}
+	sshClient, err := client.dial(addr)
+	if err != nil {
+		return err
+	}
+	defer sshClient.Close()
+
+	master, err := newControlMaster(sshClient, client.controlPersist)
+	if err != nil {
+		return err
+	}
+	listener, err := listenControlSocket(path)
+	if err != nil {
+		return fmt.Errorf("failed listening on %s: %v", path, err)
+	}
+
+	// The agent is forwarded for all sessions requesting it
+	if socket := os.Getenv(agentSocketEnv); socket != "" {
+		if err := agent.ForwardToRemote(sshClient, socket); err != nil {
+			log.Debug("Could not forward agent", "error", err)
+		}
+	}
+
+	ready.Write([]byte{1})
+	ready.Close()
+	master.serve(listener)
+	return nil
+}
+
+// listenControlSocket listens on path, replacing a socket no control master
+// listens on anymore. The socket is created accessible by the user only.
+func listenControlSocket(path string) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another control master is listening")
+		}
+		os.Remove(path)
+	}
+	mask := syscall.Umask(0177)
+	defer syscall.Umask(mask)
+	return net.Listen("unix", path)
+}
+
+// checkPeer returns an error unless the process at the other end of the Unix
+// socket conn runs as the same user or as root, as in OpenSSH. A control
+// master grants its clients full use of its connection.
+func checkPeer(conn net.Conn) error {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("not a Unix socket connection")
+	}
+	rawConn, err := unixConn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var cred *syscall.Ucred
+	var credErr error
+	err = rawConn.Control(func(fd uintptr) {
+		cred, credErr = syscall.GetsockoptUcred(int(fd), syscall.SOL_SOCKET, syscall.SO_PEERCRED)
+	})
+	if err != nil {
+		return err
+	}
+	if credErr != nil {
+		return credErr
+	}
+	if int(cred.Uid) != os.Getuid() && cred.Uid != 0 {
+		return fmt.Errorf("peer runs as uid %d", cred.Uid)
+	}
+	return nil
+}
+
+// controlMaster relays the channels and global requests of its clients,
+// which connect over the SSH protocol on a Unix socket, to the server.
+type controlMaster struct {
+	client  *ssh.Client
+	config  *ssh.ServerConfig
+	persist time.Duration
+
+	mutex    sync.Mutex
+	conns    int
+	forwards map[controlForward]*remoteForward
+}
+
+// controlForward identifies the channels of a remote forwarding.
+type controlForward struct {
+	channelType string
+	port        uint32
+}
+
+// remoteForward is a remote forwarding requested by the client conn.
+type remoteForward struct {
+	conn          *ssh.ServerConn
+	cancelRequest string
+	payload       []byte
+}
+
+func newControlMaster(client *ssh.Client, persist time.Duration) (*controlMaster, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	return &controlMaster{
+		client:   client,
+		config:   config,
+		persist:  persist,
+		forwards: make(map[controlForward]*remoteForward),
+	}, nil
+}
+
+// serve accepts clients until the connection to the server is closed, or
+// no client was connected for the ControlPersist time.
+func (m *controlMaster) serve(listener net.Listener) {
+	defer listener.Close()
+
+	for kind := range forwardRequests {
+		go m.routeForwarded(forwardRequests[kind].channelType)
+	}
+	go func() {
+		m.client.Wait()
+		listener.Close()
+	}()
+
+	idle := time.AfterFunc(controlMasterStartTimeout, func() { listener.Close() })
+	if m.persist < 0 {
+		idle.Stop()
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		m.mutex.Lock()
+		m.conns++
+		idle.Stop()
+		m.mutex.Unlock()
+
+		go func() {
+			m.handleConn(conn)
+			m.mutex.Lock()
+			m.conns--
+			if m.conns == 0 && m.persist >= 0 {
+				idle.Reset(m.persist)
+			}
+			m.mutex.Unlock()
+		}()
+	}
+}
+
+func (m *controlMaster) handleConn(conn net.Conn) {
+	defer conn.Close()
+	if err := checkPeer(conn); err != nil {
+		log.Debug("Rejected control client", "error", err)
+		return
+	}
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, m.config)
+	if err != nil {
+		log.Debug("Control client failed to connect", "error", err)
+		return
+	}
+	defer m.removeForwards(sconn)
+
+	go m.handleRequests(sconn, reqs)
+	for newChannel := range chans {
+		go m.relayNewChannel(newChannel)
+	}
+}
+
+// handleRequests relays the global requests of a client to the server.
+func (m *controlMaster) handleRequests(conn *ssh.ServerConn, reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		ok, reply, err := m.client.SendRequest(req.Type, req.WantReply, req.Payload)
+		if err != nil {
+			ok = false
+		}
+		if ok {
+			m.recordForward(conn, req.Type, req.Payload, reply)
+		}
+		if req.WantReply {
+			req.Reply(ok, reply)
+		}
+	}
+}
+
+// recordForward records the remote forwarding established or cancelled by a
+// successful global request of conn, to relay its channels to conn.
+func (m *controlMaster) recordForward(conn *ssh.ServerConn, reqType string, payload, reply []byte) {
+	var req struct {
+		BindAddr string
+		BindPort uint32
+	}
+	if err := ssh.Unmarshal(payload, &req); err != nil {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if kind, ok := forwardRequests[reqType]; ok {
+		if req.BindPort == 0 {
+			var allocated struct {
+				Port uint32
+			}
+			if err := ssh.Unmarshal(reply, &allocated); err != nil {
+				return
+			}
+			req.BindPort = allocated.Port
+		}
+		m.forwards[controlForward{kind.channelType, req.BindPort}] = &remoteForward{
+			conn:          conn,
+			cancelRequest: kind.cancelRequest,
+			payload:       ssh.Marshal(&req),
+		}
+		return
+	}
+	for _, kind := range forwardRequests {
+		if kind.cancelRequest == reqType {
+			delete(m.forwards, controlForward{kind.channelType, req.BindPort})
+		}
+	}
+}
+
+// removeForwards cancels the remote forwardings of conn.
+func (m *controlMaster) removeForwards(conn *ssh.ServerConn) {
+	var cancelled []*remoteForward
+	m.mutex.Lock()
+	for key, fwd := range m.forwards {
+		if fwd.conn == conn {
+			cancelled = append(cancelled, fwd)
+			delete(m.forwards, key)
+		}
+	}
+	m.mutex.Unlock()
+
+	for _, fwd := range cancelled {
+		m.client.SendRequest(fwd.cancelRequest, false, fwd.payload)
+	}
+}
+
+// routeForwarded relays the channels of remote forwardings to the client
+// that requested the forwarding.
+func (m *controlMaster) routeForwarded(channelType string) {
+	for newChannel := range m.client.HandleChannelOpen(channelType) {
+		var data struct {
+			Addr       string
+			Port       uint32
+			OriginAddr string
+			OriginPort uint32
+		}
+		if err := ssh.Unmarshal(newChannel.ExtraData(), &data); err != nil {
+			newChannel.Reject(ssh.ConnectionFailed, "could not parse "+channelType+" payload: "+err.Error())
+			continue
+		}
+		m.mutex.Lock()
+		fwd, exists := m.forwards[controlForward{channelType, data.Port}]
+		m.mutex.Unlock()
+		if !exists {
+			newChannel.Reject(ssh.Prohibited, fmt.Sprintf("no forward for port %d", data.Port))
+			continue
+		}
+
+		go func(newChannel ssh.NewChannel) {
+			downstream, downReqs, err := fwd.conn.OpenChannel(channelType, newChannel.ExtraData())
+			if err != nil {
+				rejectChannel(newChannel, err)
+				return
+			}
+			upstream, upReqs, err := newChannel.Accept()
+			if err != nil {
+				downstream.Close()
+				return
+			}
+			relayChannel(upstream, upReqs, downstream, downReqs)
+		}(newChannel)
+	}
+}
+
+// relayNewChannel opens a channel requested by a client on the server, and
+// relays it.
+func (m *controlMaster) relayNewChannel(newChannel ssh.NewChannel) {
+	upstream, upReqs, err := m.client.OpenChannel(newChannel.ChannelType(), newChannel.ExtraData())
+	if err != nil {
+		rejectChannel(newChannel, err)
+		return
+	}
+	downstream, downReqs, err := newChannel.Accept()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+	relayChannel(downstream, downReqs, upstream, upReqs)
+}
+
+// rejectChannel rejects newChannel with the reason the corresponding channel
+// was rejected.
+func rejectChannel(newChannel ssh.NewChannel, err error) {
+	if openErr, ok := err.(*ssh.OpenChannelError); ok {
+		newChannel.Reject(openErr.Reason, openErr.Message)
+		return
+	}
+	newChannel.Reject(ssh.ConnectionFailed, err.Error())
+}
+
+// relayChannel relays data and requests between two channels, until both are
+// closed.
+func relayChannel(a ssh.Channel, aReqs <-chan *ssh.Request, b ssh.Channel, bReqs <-chan *ssh.Request) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go relayHalf(b, a, aReqs, &wg)
+	go relayHalf(a, b, bReqs, &wg)
+	wg.Wait()
+}
+
+// relayHalf relays the data, extended data and requests of src to dst. Once
+// src is closed and all its data was relayed, dst is closed.
+func relayHalf(dst, src ssh.Channel, srcReqs <-chan *ssh.Request, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	var copies sync.WaitGroup
+	copies.Add(2)
+	go func() {
+		io.Copy(dst, src)
+		copies.Done()
+	}()
+	go func() {
+		io.Copy(dst.Stderr(), src.Stderr())
+		copies.Done()
+	}()
+	go func() {
+		copies.Wait()
+		dst.CloseWrite()
+	}()
+
+	for req := range srcReqs {
+		ok, err := dst.SendRequest(req.Type, req.WantReply, req.Payload)
+		if req.WantReply {
+			req.Reply(ok && err == nil, nil)
+		}
+	}
+	copies.Wait()
+	dst.Close()
+}
@@ -0,0 +1,236 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// startTestServer returns a client connected to a server that echoes the
+// command of exec requests, and opens a forwarded-tcpip channel to port 4242
+// on an open-forward request.
+func startTestServer() (*ssh.Client, error) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	signer, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		return nil, err
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		defer listener.Close()
+		serverConn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		sconn, chans, reqs, err := ssh.NewServerConn(serverConn, config)
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range reqs {
+				switch req.Type {
+				case "tcpip-forward":
+					req.Reply(true, ssh.Marshal(struct{ Port uint32 }{4242}))
+				case "open-forward":
+					req.Reply(true, nil)
+					data := struct {
+						Addr       string
+						Port       uint32
+						OriginAddr string
+						OriginPort uint32
+					}{"0.0.0.0", 4242, "10.0.0.1", 1234}
+					ch, chReqs, err := sconn.OpenChannel("forwarded-tcpip", ssh.Marshal(&data))
+					if err != nil {
+						continue
+					}
+					go ssh.DiscardRequests(chReqs)
+					ch.Write([]byte("hello"))
+					ch.Close()
+				default:
+					req.Reply(false, nil)
+				}
+			}
+		}()
+		for newChannel := range chans {
+			ch, chReqs, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go func() {
+				for req := range chReqs {
+					var cmd struct{ Command string }
+					if req.Type != "exec" || ssh.Unmarshal(req.Payload, &cmd) != nil {
+						req.Reply(false, nil)
+						continue
+					}
+					req.Reply(true, nil)
+					ch.Write([]byte(cmd.Command))
+					ch.Stderr().Write([]byte("err"))
+					ch.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{3}))
+					ch.Close()
+				}
+			}()
+		}
+	}()
+
+	return ssh.Dial("tcp", listener.Addr().String(), &ssh.ClientConfig{
+		User:            "user",
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+	})
+}
+
+func TestControlMaster(t *testing.T) {
+	Convey("Given a control master connected to a server", t, func() {
+		dir, err := ioutil.TempDir("", "control_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "control")
+
+		sshClient, err := startTestServer()
+		So(err, ShouldBeNil)
+		master, err := newControlMaster(sshClient, 0)
+		So(err, ShouldBeNil)
+		listener, err := listenControlSocket(path)
+		So(err, ShouldBeNil)
+		done := make(chan struct{})
+		go func() {
+			master.serve(listener)
+			close(done)
+		}()
+
+		client, err := dialControlSocket(path, "server:22", "user")
+		So(err, ShouldBeNil)
+
+		Convey("Sessions are relayed with their output and exit status", func() {
+			session, err := client.NewSession()
+			So(err, ShouldBeNil)
+			var stdout, stderr bytes.Buffer
+			session.Stdout = &stdout
+			session.Stderr = &stderr
+			err = session.Run("echo hello")
+			So(err, ShouldHaveSameTypeAs, &ssh.ExitError{})
+			So(err.(*ssh.ExitError).ExitStatus(), ShouldEqual, 3)
+			So(stdout.String(), ShouldEqual, "echo hello")
+			So(stderr.String(), ShouldEqual, "err")
+		})
+
+		Convey("Remote forwardings are relayed to the client requesting them", func() {
+			remoteListener, err := client.Listen("tcp", "0.0.0.0:0")
+			So(err, ShouldBeNil)
+			So(remoteListener.Addr().(*net.TCPAddr).Port, ShouldEqual, 4242)
+
+			ok, _, err := client.SendRequest("open-forward", true, nil)
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+			conn, err := remoteListener.Accept()
+			So(err, ShouldBeNil)
+			data, err := ioutil.ReadAll(conn)
+			So(err, ShouldBeNil)
+			So(string(data), ShouldEqual, "hello")
+		})
+
+		Convey("Another control master can't listen on the same path", func() {
+			_, err := listenControlSocket(path)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The control master exits once the last client is gone", func() {
+			client.Close()
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				So("control master still running", ShouldBeEmpty)
+			}
+		})
+
+		client.Close()
+		listener.Close()
+		<-done
+	})
+}
+
+func TestCheckPeer(t *testing.T) {
+	Convey("Connections of the same user are accepted", t, func() {
+		dir, err := ioutil.TempDir("", "control_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "control")
+
+		listener, err := listenControlSocket(path)
+		So(err, ShouldBeNil)
+		defer listener.Close()
+		info, err := os.Stat(path)
+		So(err, ShouldBeNil)
+		So(info.Mode().Perm(), ShouldEqual, 0600)
+
+		conn, err := net.Dial("unix", path)
+		So(err, ShouldBeNil)
+		defer conn.Close()
+		So(checkPeer(conn), ShouldBeNil)
+	})
+
+	Convey("Connections other than over Unix sockets are rejected", t, func() {
+		conn, _ := net.Pipe()
+		defer conn.Close()
+		So(checkPeer(conn), ShouldNotBeNil)
+	})
+}
+
+func TestControlOptions(t *testing.T) {
+	Convey("ControlPersist is parsed", t, func() {
+		for option, expected := range map[string]time.Duration{
+			"no":  0,
+			"yes": -1,
+			"0":   -1,
+			"10m": 10 * time.Minute,
+			"90":  90 * time.Second,
+		} {
+			d, err := parseControlPersist(option)
+			So(err, ShouldBeNil)
+			So(d, ShouldEqual, expected)
+		}
+		_, err := parseControlPersist("forever")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("ControlPath is expanded", t, func() {
+		So(expandControlPath("~/.ssh/%r@%h:%p", "1-ffaa:1:a,[10.0.0.1]", "22", "alice"), ShouldEqual,
+			"~/.ssh/alice@1-ffaa:1:a,[10.0.0.1]:22")
+		So(expandControlPath("/tmp/%C", "host", "22", "alice"), ShouldHaveLength, len("/tmp/")+40)
+		So(expandControlPath("/tmp/%C", "host", "22", "alice"), ShouldNotEqual, expandControlPath("/tmp/%C", "host", "2200", "alice"))
+		So(expandControlPath("100%%", "host", "22", "alice"), ShouldEqual, "100%")
+	})
+}
@@ -0,0 +1,106 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Forward is a port forwarding specification, as given to -L and -R.
+type Forward struct {
+	// BindAddress is the address to listen on, "localhost" by default for -L. The empty string means all interfaces.
+	BindAddress string
+	// Port is the port to listen on.
+	Port uint16
+	// Address is the address to forward connections to; a SCION address of the form ISD-AS,[IP]:port or host:port.
+	Address string
+}
+
+// ParseForward parses a forwarding specification in the OpenSSH syntax [bind_address:]port:host:hostport. As in ssh_config, host:hostport may also be separated by whitespace. IPv6 addresses are enclosed in square brackets, host may also be a SCION address of the form ISD-AS,[IP]. A bind address of "*" binds to all interfaces.
+func ParseForward(spec string) (*Forward, error) {
+	return parseForward(spec, "localhost")
+}
+
+// ParseRemoteForward parses a remote forwarding specification, as given to -R, in the same syntax as ParseForward. Without a bind address, the server decides where to listen, which is loopback unless it allows GatewayPorts.
+func ParseRemoteForward(spec string) (*Forward, error) {
+	return parseForward(spec, "")
+}
+
+func parseForward(spec, defaultBindAddress string) (*Forward, error) {
+	if fields := strings.Fields(spec); len(fields) == 2 {
+		spec = fields[0] + ":" + fields[1]
+	}
+
+	parts := splitForward(spec)
+	if len(parts) < 3 {
+		return nil, fmt.Errorf("invalid forward specification %q", spec)
+	}
+
+	fwd := &Forward{BindAddress: defaultBindAddress}
+	hostPort := parts[len(parts)-1]
+	rest := parts[:len(parts)-1]
+	if _, err := strconv.ParseUint(rest[0], 10, 16); err != nil {
+		// A bind address is given
+		fwd.BindAddress = strings.Trim(rest[0], "[]")
+		if fwd.BindAddress == "*" {
+			fwd.BindAddress = ""
+		}
+		rest = rest[1:]
+	}
+	if len(rest) < 2 {
+		return nil, fmt.Errorf("invalid forward specification %q", spec)
+	}
+
+	port, err := strconv.ParseUint(rest[0], 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in forward specification %q", spec)
+	}
+	fwd.Port = uint16(port)
+	if _, err := strconv.ParseUint(hostPort, 10, 16); err != nil {
+		return nil, fmt.Errorf("invalid host port in forward specification %q", spec)
+	}
+
+	// The host may itself contain colons, e.g. in a SCION address
+	host := strings.Join(rest[1:], ":")
+	if strings.Contains(host, ",") {
+		fwd.Address = host + ":" + hostPort
+	} else {
+		fwd.Address = net.JoinHostPort(strings.Trim(host, "[]"), hostPort)
+	}
+	return fwd, nil
+}
+
+// splitForward splits s at all colons that are not enclosed in square brackets.
+func splitForward(s string) []string {
+	var parts []string
+	depth, start := 0, 0
+	for i, c := range s {
+		switch c {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
@@ -0,0 +1,70 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseForward(t *testing.T) {
+	Convey("Valid forward specifications are parsed", t, func() {
+		testCases := []struct {
+			Spec     string
+			Expected Forward
+		}{
+			{"8080:example.com:80", Forward{"localhost", 8080, "example.com:80"}},
+			{"127.0.0.1:8080:example.com:80", Forward{"127.0.0.1", 8080, "example.com:80"}},
+			{"*:8080:example.com:80", Forward{"", 8080, "example.com:80"}},
+			{":8080:example.com:80", Forward{"", 8080, "example.com:80"}},
+			{"[::1]:8080:[2001:db8::1]:80", Forward{"::1", 8080, "[2001:db8::1]:80"}},
+			{"8080:1-ff00:0:110,[10.0.0.1]:80", Forward{"localhost", 8080, "1-ff00:0:110,[10.0.0.1]:80"}},
+			{"localhost:8080:1-ff00:0:110,[2001:db8::1]:80", Forward{"localhost", 8080, "1-ff00:0:110,[2001:db8::1]:80"}},
+			{"8080 example.com:80", Forward{"localhost", 8080, "example.com:80"}},
+		}
+		for _, tc := range testCases {
+			fwd, err := ParseForward(tc.Spec)
+			So(err, ShouldBeNil)
+			So(*fwd, ShouldResemble, tc.Expected)
+		}
+	})
+
+	Convey("Invalid forward specifications are rejected", t, func() {
+		for _, spec := range []string{
+			"",
+			"8080",
+			"8080:example.com",
+			"localhost:8080:example.com",
+			"99999:example.com:80",
+			"8080:example.com:http",
+		} {
+			_, err := ParseForward(spec)
+			So(err, ShouldNotBeNil)
+			_, err = ParseRemoteForward(spec)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Remote forwardings are bound where the server decides by default", t, func() {
+		fwd, err := ParseRemoteForward("8080:localhost:80")
+		So(err, ShouldBeNil)
+		So(*fwd, ShouldResemble, Forward{"", 8080, "localhost:80"})
+
+		fwd, err = ParseRemoteForward("127.0.0.1:4433:1-ff00:0:110,[10.0.0.1]:443")
+		So(err, ShouldBeNil)
+		So(*fwd, ShouldResemble, Forward{"127.0.0.1", 4433, "1-ff00:0:110,[10.0.0.1]:443"})
+	})
+}
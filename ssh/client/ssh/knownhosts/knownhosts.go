@@ -114,7 +114,7 @@ func wildcardMatch(pat []byte, str []byte) bool {
 }
 
 func (p *hostPattern) match(a addr) bool {
-	return wildcardMatch([]byte(p.addr.host), []byte(a.host)) && p.addr.port == a.port
+	return wildcardMatch([]byte(p.addr.host), []byte(a.host)) && wildcardMatch([]byte(p.addr.port), []byte(a.port))
 }
 
 type keyDBLine struct {
@@ -276,6 +276,11 @@ func newHostnameMatcher(pattern string) (matcher, error) {
 		if err != nil {
 			a.host = p
 			a.port = "22"
+			// Wildcard patterns such as 1-ffaa:1:* can't have a port, they
+			// match any port instead
+			if strings.ContainsAny(p, "*?") {
+				a.port = "*"
+			}
 		}
 		hps = append(hps, hostPattern{
 			negate: negate,
@@ -351,6 +356,37 @@ func (db *hostKeyDB) check(address string, remote net.Addr, remoteKey ssh.Public
 	return db.checkAddrs(addrs, remoteKey)
 }
 
+// checkHostKey checks a host key or host certificate. Certificates must be
+// signed by a certificate authority for the address and name the host as
+// principal. Certificates signed by an unknown authority are checked like the
+// plain key they certify.
+func (db *hostKeyDB) checkHostKey(address string, remote net.Addr, remoteKey ssh.PublicKey) error {
+	cert, ok := remoteKey.(*ssh.Certificate)
+	if !ok {
+		return db.check(address, remote, remoteKey)
+	}
+	if cert.CertType != ssh.HostCert {
+		return fmt.Errorf("knownhosts: certificate presented as host key has type %d", cert.CertType)
+	}
+	if revoked := db.revoked[string(cert.SignatureKey.Marshal())]; revoked != nil {
+		return &RevokedError{Revoked: *revoked}
+	}
+	if !db.IsHostAuthority(cert.SignatureKey, address) {
+		return db.check(address, remote, cert.Key)
+	}
+
+	// ssh.CertChecker can't split SCION addresses, so check the certificate
+	// with the host name ourselves
+	host, _, err := appnet.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("knownhosts: SplitHostPort(%s): %v", address, err)
+	}
+	certChecker := ssh.CertChecker{
+		IsRevoked: db.IsRevoked,
+	}
+	return certChecker.CheckCert(host, cert)
+}
+
 // checkAddrs checks if we can find the given public key for any of
 // the given addresses.  If we only find an entry for the IP address,
 // or only the hostname, then this still succeeds.
@@ -425,12 +461,7 @@ func New(files ...string) (ssh.HostKeyCallback, error) {
 		}
 	}
 
-	var certChecker ssh.CertChecker
-	certChecker.IsHostAuthority = db.IsHostAuthority
-	certChecker.IsRevoked = db.IsRevoked
-	certChecker.HostKeyFallback = db.check
-
-	return certChecker.CheckHostKey, nil
+	return db.checkHostKey, nil
 }
 
 // Normalize normalizes an address into the form used in known_hosts
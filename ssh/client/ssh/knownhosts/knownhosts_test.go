@@ -0,0 +1,94 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package knownhosts
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func newTestSigner() ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		panic(err)
+	}
+	return signer
+}
+
+func TestHostCertificates(t *testing.T) {
+	Convey("Given a known hosts file with a certificate authority for an AS", t, func() {
+		ca := newTestSigner()
+		hostKey := newTestSigner().PublicKey()
+		db := newHostKeyDB()
+		line := "@cert-authority 1-ffaa:1:* " + serialize(ca.PublicKey())
+		So(db.Read(strings.NewReader(line), "known_hosts"), ShouldBeNil)
+
+		const address = "1-ffaa:1:abc,[10.0.0.1]:2200"
+		remote := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 2200}
+		newCert := func(signer ssh.Signer, principals ...string) *ssh.Certificate {
+			cert := &ssh.Certificate{
+				Key:             hostKey,
+				CertType:        ssh.HostCert,
+				ValidPrincipals: principals,
+				ValidAfter:      uint64(time.Now().Add(-time.Hour).Unix()),
+				ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+			}
+			So(cert.SignCert(rand.Reader, signer), ShouldBeNil)
+			return cert
+		}
+
+		Convey("A certificate for the host is accepted", func() {
+			cert := newCert(ca, "1-ffaa:1:abc,[10.0.0.1]")
+			So(db.checkHostKey(address, remote, cert), ShouldBeNil)
+		})
+
+		Convey("A certificate for another host is rejected", func() {
+			cert := newCert(ca, "1-ffaa:1:abc,[10.0.0.2]")
+			So(db.checkHostKey(address, remote, cert), ShouldNotBeNil)
+		})
+
+		Convey("A certificate of an unknown authority is checked as plain key", func() {
+			cert := newCert(newTestSigner(), "1-ffaa:1:abc,[10.0.0.1]")
+			err := db.checkHostKey(address, remote, cert)
+			So(err, ShouldHaveSameTypeAs, &KeyError{})
+		})
+
+		Convey("The authority is not trusted for other ASes", func() {
+			cert := newCert(ca, "2-ffaa:1:abc,[10.0.0.1]")
+			err := db.checkHostKey("2-ffaa:1:abc,[10.0.0.1]:2200", remote, cert)
+			So(err, ShouldHaveSameTypeAs, &KeyError{})
+		})
+
+		Convey("Certificates of revoked authorities are rejected", func() {
+			revoked := "@revoked * " + serialize(ca.PublicKey())
+			So(db.Read(strings.NewReader(revoked), "known_hosts"), ShouldBeNil)
+			cert := newCert(ca, "1-ffaa:1:abc,[10.0.0.1]")
+			err := db.checkHostKey(address, remote, cert)
+			So(err, ShouldHaveSameTypeAs, &RevokedError{})
+		})
+	})
+}
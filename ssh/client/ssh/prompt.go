@@ -0,0 +1,109 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// PromptPassword prompts the user for a password to authenticate with.
+func PromptPassword() (secret string, err error) {
+	fmt.Printf("Password: ")
+	password, _ := terminal.ReadPassword(0)
+	fmt.Println()
+	return string(password), nil
+}
+
+// PromptKeyboardInteractive prints the instruction of a keyboard-interactive
+// challenge and prompts the user for the answers to its questions. Answers to
+// questions without echo are not shown while typing.
+func PromptKeyboardInteractive(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	if name != "" {
+		fmt.Println(name)
+	}
+	if instruction != "" {
+		fmt.Println(instruction)
+	}
+	answers := make([]string, len(questions))
+	for i, question := range questions {
+		fmt.Print(question)
+		if echos[i] {
+			answer, err := readLine()
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = answer
+		} else {
+			answer, err := terminal.ReadPassword(0)
+			fmt.Println()
+			if err != nil {
+				return nil, err
+			}
+			answers[i] = string(answer)
+		}
+	}
+	return answers, nil
+}
+
+// readLine reads a line from stdin without buffering, so that no input of
+// the session is consumed.
+func readLine() (string, error) {
+	var line []byte
+	buf := make([]byte, 1)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n == 1 {
+			if buf[0] == '\n' {
+				break
+			}
+			line = append(line, buf[0])
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return strings.TrimSuffix(string(line), "\r"), nil
+}
+
+// PromptPassphrase prompts the user for the passphrase of an encrypted private key.
+func PromptPassphrase(file string) ([]byte, error) {
+	fmt.Printf("Enter passphrase for key '%s': ", file)
+	passphrase, err := terminal.ReadPassword(0)
+	fmt.Println()
+	return passphrase, err
+}
+
+// PromptAcceptHostKey prompts the user to accept or reject the given host key.
+func PromptAcceptHostKey(hostname string, remote net.Addr, publicKey string) bool {
+	for {
+		fmt.Printf("Key fingerprint SHA256 is: %s do you recognize it? (y/n) ", publicKey)
+		var answer string
+		fmt.Scanln(&answer)
+		answer = strings.ToLower(answer)
+		if strings.HasPrefix(answer, "y") {
+			fmt.Printf("Alright, adding %s to the list of known hosts", publicKey)
+			return true
+		} else if strings.HasPrefix(answer, "n") {
+			return false
+		} else {
+			fmt.Printf("Not a valid answer. Try again")
+		}
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/netsec-ethz/scion-apps/pkg/appnet"
+	"github.com/netsec-ethz/scion-apps/ssh/sssh"
+)
+
+// defaultJumpPort is the port of jump hosts given without port.
+const defaultJumpPort = "22"
+
+// dial connects to the server at addr, over the ProxyCommand or the jump hosts
+// of ProxyJump if configured, and directly over SCION otherwise.
+func (client *Client) dial(addr string) (*ssh.Client, error) {
+	switch {
+	case client.proxyCommand != "":
+		return client.dialProxyCommand(addr)
+	case client.proxyJump != "":
+		return client.dialJumpHosts(addr)
+	}
+	return sssh.DialSCIONWithConf(addr, client.config, client.appConf)
+}
+
+// dialJumpHosts connects to addr over the chain of jump hosts. The first one
+// is connected directly, each following one and finally addr through a
+// direct-scionquic (for SCION addresses) or direct-tcpip channel of the
+// previous one. All hosts are authenticated with the same methods. The
+// connections to the jump hosts are closed with the one to addr.
+func (client *Client) dialJumpHosts(addr string) (*ssh.Client, error) {
+	var jumps []*ssh.Client
+	var via *ssh.Client
+	for _, hop := range splitJumpHosts(client.proxyJump) {
+		user, hopAddr := parseJumpHost(hop, client.config.User)
+		config := *client.config
+		config.User = user
+
+		log.Debug("Connecting to jump host", "host", hopAddr, "user", user)
+		next, err := client.dialVia(via, hopAddr, &config)
+		if err != nil {
+			closeJumpHosts(jumps)
+			return nil, fmt.Errorf("failed connecting to jump host %s: %v", hop, err)
+		}
+		jumps = append(jumps, next)
+		via = next
+	}
+	sshClient, err := client.dialVia(via, addr, client.config)
+	if err != nil {
+		closeJumpHosts(jumps)
+		return nil, err
+	}
+	go func() {
+		sshClient.Wait()
+		closeJumpHosts(jumps)
+	}()
+	return sshClient, nil
+}
+
+// closeJumpHosts closes the connections to jump hosts, starting with the last
+// one, which is connected through the others.
+func closeJumpHosts(jumps []*ssh.Client) {
+	for i := len(jumps) - 1; i >= 0; i-- {
+		jumps[i].Close()
+	}
+}
+
+// dialVia connects to addr through a channel of via, or directly if via is
+// nil.
+func (client *Client) dialVia(via *ssh.Client, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if via == nil {
+		return sssh.DialSCIONWithConf(addr, config, client.appConf)
+	}
+
+	var conn net.Conn
+	var err error
+	if strings.Contains(addr, ",") {
+		conn, err = sssh.TunnelDialSCION(via, addr)
+	} else {
+		conn, err = via.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sshClient, err := sssh.NewClient(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return sshClient, nil
+}
+
+// splitJumpHosts splits the comma-separated list of jump hosts. A comma
+// followed by "[" separates the IA and host of a SCION address instead.
+func splitJumpHosts(list string) []string {
+	var hops []string
+	start := 0
+	for i := 0; i <= len(list); i++ {
+		if i == len(list) || (list[i] == ',' && (i+1 == len(list) || list[i+1] != '[')) {
+			if hop := strings.TrimSpace(list[start:i]); hop != "" {
+				hops = append(hops, hop)
+			}
+			start = i + 1
+		}
+	}
+	return hops
+}
+
+// parseJumpHost parses a jump host of the form [user@]host[:port], where host
+// is a host name or SCION address. Without user, defaultUser is used.
+func parseJumpHost(hop, defaultUser string) (user, addr string) {
+	user = defaultUser
+	if at := strings.Index(hop, "@"); at >= 0 {
+		user, hop = hop[:at], hop[at+1:]
+	}
+	if _, _, err := appnet.SplitHostPort(hop); err != nil {
+		hop = hop + ":" + defaultJumpPort
+	}
+	return user, hop
+}
+
+// dialProxyCommand runs the ProxyCommand with the shell, and connects to addr
+// over its standard input and output.
+func (client *Client) dialProxyCommand(addr string) (*ssh.Client, error) {
+	host, port, err := appnet.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	command := expandProxyCommand(client.proxyCommand, host, port, client.config.User)
+	log.Debug("Running proxy command", "command", command)
+
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed running proxy command: %v", err)
+	}
+
+	conn := &commandConn{cmd: cmd, stdin: stdin, stdout: stdout}
+	sshClient, err := sssh.NewClient(conn, addr, client.config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return sshClient, nil
+}
+
+// expandProxyCommand replaces %h, %p and %r in command by host, port and the
+// remote user, and %% by %.
+func expandProxyCommand(command, host, port, user string) string {
+	var expanded strings.Builder
+	for i := 0; i < len(command); i++ {
+		if command[i] != '%' || i+1 == len(command) {
+			expanded.WriteByte(command[i])
+			continue
+		}
+		i++
+		switch command[i] {
+		case 'h':
+			expanded.WriteString(host)
+		case 'p':
+			expanded.WriteString(port)
+		case 'r':
+			expanded.WriteString(user)
+		case '%':
+			expanded.WriteByte('%')
+		default:
+			expanded.WriteByte('%')
+			expanded.WriteByte(command[i])
+		}
+	}
+	return expanded.String()
+}
+
+// commandConn is a connection over the standard input and output of a
+// command.
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.Reader
+}
+
+func (c *commandConn) Read(b []byte) (int, error) {
+	return c.stdout.Read(b)
+}
+
+func (c *commandConn) Write(b []byte) (int, error) {
+	return c.stdin.Write(b)
+}
+
+// Close closes the standard input of the command and stops it.
+func (c *commandConn) Close() error {
+	err := c.stdin.Close()
+	c.cmd.Process.Kill()
+	c.cmd.Wait()
+	return err
+}
+
+func (c *commandConn) LocalAddr() net.Addr {
+	return commandAddr{}
+}
+
+func (c *commandConn) RemoteAddr() net.Addr {
+	return commandAddr{}
+}
+
+func (c *commandConn) SetDeadline(deadline time.Time) error {
+	return errors.New("scion-ssh: deadline not supported")
+}
+
+func (c *commandConn) SetReadDeadline(deadline time.Time) error {
+	return errors.New("scion-ssh: deadline not supported")
+}
+
+func (c *commandConn) SetWriteDeadline(deadline time.Time) error {
+	return errors.New("scion-ssh: deadline not supported")
+}
+
+type commandAddr struct{}
+
+func (commandAddr) Network() string {
+	return "pipe"
+}
+
+func (commandAddr) String() string {
+	return "proxy command"
+}
@@ -0,0 +1,58 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestJumpHosts(t *testing.T) {
+	Convey("Jump host lists are split at commas outside of SCION addresses", t, func() {
+		So(splitJumpHosts("bastion"), ShouldResemble, []string{"bastion"})
+		So(splitJumpHosts("a,b:2200"), ShouldResemble, []string{"a", "b:2200"})
+		So(splitJumpHosts("alice@1-ffaa:1:a,[10.0.0.1]:2200, 1-ffaa:1:b,[::1],host"), ShouldResemble,
+			[]string{"alice@1-ffaa:1:a,[10.0.0.1]:2200", "1-ffaa:1:b,[::1]", "host"})
+		So(splitJumpHosts(""), ShouldBeEmpty)
+	})
+
+	Convey("Jump hosts are parsed with default user and port", t, func() {
+		testCases := []struct {
+			Hop  string
+			User string
+			Addr string
+		}{
+			{"bastion", "bob", "bastion:22"},
+			{"alice@bastion:2200", "alice", "bastion:2200"},
+			{"1-ffaa:1:a,[10.0.0.1]", "bob", "1-ffaa:1:a,[10.0.0.1]:22"},
+			{"alice@1-ffaa:1:a,[10.0.0.1]:2200", "alice", "1-ffaa:1:a,[10.0.0.1]:2200"},
+		}
+		for _, tc := range testCases {
+			user, addr := parseJumpHost(tc.Hop, "bob")
+			So(user, ShouldEqual, tc.User)
+			So(addr, ShouldEqual, tc.Addr)
+		}
+	})
+}
+
+func TestExpandProxyCommand(t *testing.T) {
+	Convey("Host, port and user are substituted in the proxy command", t, func() {
+		So(expandProxyCommand("nc -X 5 %h %p", "1-ffaa:1:a,[10.0.0.1]", "22", "alice"), ShouldEqual,
+			"nc -X 5 1-ffaa:1:a,[10.0.0.1] 22")
+		So(expandProxyCommand("ssh -W %h:%p %r@gw 100%% %x%", "host", "2200", "alice"), ShouldEqual,
+			"ssh -W host:2200 alice@gw 100% %x%")
+	})
+}
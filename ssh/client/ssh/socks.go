@@ -0,0 +1,187 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// SOCKS5 protocol constants, see RFC 1928.
+const (
+	socksVersion = 0x05
+
+	socksMethodNoAuth       = 0x00
+	socksMethodNoAcceptable = 0xff
+
+	socksCmdConnect = 0x01
+
+	socksAddrIPv4   = 0x01
+	socksAddrDomain = 0x03
+	socksAddrIPv6   = 0x04
+
+	socksReplySucceeded           = 0x00
+	socksReplyHostUnreachable     = 0x04
+	socksReplyCommandNotSupported = 0x07
+	socksReplyAddrNotSupported    = 0x08
+)
+
+// StartSOCKSProxy runs a SOCKS5 server on the given local port, connecting each requested target over a tunnel to the server. Targets can be host names, IP addresses or SCION addresses of the form ISD-AS,[IP], given as domain name; see Dial.
+func (client *Client) StartSOCKSProxy(localPort uint16) error {
+	localListener, err := net.Listen("tcp", net.JoinHostPort("localhost", strconv.Itoa(int(localPort))))
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer localListener.Close()
+		for {
+			localConn, err := localListener.Accept()
+			if err != nil {
+				log.Debug("Error accepting SOCKS listener: ", err)
+				return
+			}
+
+			go func() {
+				err := serveSOCKS(localConn, client.Dial)
+				if err != nil {
+					log.Debug("Error serving SOCKS connection: ", err)
+				}
+			}()
+		}
+	}()
+
+	return nil
+}
+
+// serveSOCKS handles a SOCKS5 connection. Only the CONNECT command without
+// authentication is supported. The requested target is connected with dial.
+func serveSOCKS(conn net.Conn, dial func(addr string) (net.Conn, error)) error {
+	addr, err := socksHandshake(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	remoteConn, err := dial(addr)
+	if err != nil {
+		_ = socksReply(conn, socksReplyHostUnreachable)
+		conn.Close()
+		return fmt.Errorf("dialing %s: %v", addr, err)
+	}
+	if err := socksReply(conn, socksReplySucceeded); err != nil {
+		conn.Close()
+		remoteConn.Close()
+		return err
+	}
+
+	connect(conn, remoteConn)
+	return nil
+}
+
+// socksHandshake negotiates the authentication method and reads the request,
+// returning the address to connect to.
+func socksHandshake(conn net.Conn) (string, error) {
+	var header [2]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return "", err
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+	method := byte(socksMethodNoAcceptable)
+	for _, m := range methods {
+		if m == socksMethodNoAuth {
+			method = socksMethodNoAuth
+		}
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return "", err
+	}
+	if method == socksMethodNoAcceptable {
+		return "", errors.New("no acceptable SOCKS authentication method")
+	}
+
+	var request [4]byte
+	if _, err := io.ReadFull(conn, request[:]); err != nil {
+		return "", err
+	}
+	if request[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", request[0])
+	}
+	if request[1] != socksCmdConnect {
+		_ = socksReply(conn, socksReplyCommandNotSupported)
+		return "", fmt.Errorf("unsupported SOCKS command %d", request[1])
+	}
+
+	var host string
+	switch request[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		ip := make(net.IP, net.IPv4len)
+		if request[3] == socksAddrIPv6 {
+			ip = make(net.IP, net.IPv6len)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksAddrDomain:
+		var length [1]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		_ = socksReply(conn, socksReplyAddrNotSupported)
+		return "", fmt.Errorf("unsupported SOCKS address type %d", request[3])
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(conn, port[:]); err != nil {
+		return "", err
+	}
+	return socksTarget(host, binary.BigEndian.Uint16(port[:])), nil
+}
+
+// socksTarget joins host and port to an address for Dial. SCION addresses
+// are passed on as ISD-AS,[IP]:port.
+func socksTarget(host string, port uint16) string {
+	if strings.Contains(host, ",") {
+		return fmt.Sprintf("%s:%d", host, port)
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(port)))
+}
+
+// socksReply sends a reply with the given status. The bound address is not
+// reported.
+func socksReply(conn net.Conn, status byte) error {
+	_, err := conn.Write([]byte{socksVersion, status, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
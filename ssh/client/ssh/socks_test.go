@@ -0,0 +1,127 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// socksRequest runs a SOCKS5 handshake and CONNECT request for the given raw
+// address on conn, returning the reply status.
+func socksRequest(conn net.Conn, addrType byte, addr []byte, port uint16) (byte, error) {
+	if _, err := conn.Write([]byte{socksVersion, 1, socksMethodNoAuth}); err != nil {
+		return 0, err
+	}
+	var method [2]byte
+	if _, err := io.ReadFull(conn, method[:]); err != nil {
+		return 0, err
+	}
+	req := append([]byte{socksVersion, socksCmdConnect, 0x00, addrType}, addr...)
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return 0, err
+	}
+	var reply [10]byte
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return 0, err
+	}
+	return reply[1], nil
+}
+
+func domain(name string) []byte {
+	return append([]byte{byte(len(name))}, name...)
+}
+
+func TestSOCKS(t *testing.T) {
+	Convey("Given a SOCKS connection", t, func() {
+		local, proxy := net.Pipe()
+		defer local.Close()
+
+		dialed := make(chan string, 1)
+		var remote net.Conn
+		dial := func(addr string) (net.Conn, error) {
+			if addr == "unreachable:80" {
+				dialed <- addr
+				return nil, errors.New("unreachable")
+			}
+			var c net.Conn
+			c, remote = net.Pipe()
+			dialed <- addr
+			return c, nil
+		}
+		done := make(chan error, 1)
+		go func() { done <- serveSOCKS(proxy, dial) }()
+
+		Convey("Connecting to an IPv4 address dials it and forwards data", func() {
+			status, err := socksRequest(local, socksAddrIPv4, []byte{192, 0, 2, 1}, 80)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, socksReplySucceeded)
+			So(<-dialed, ShouldEqual, "192.0.2.1:80")
+			So(<-done, ShouldBeNil)
+
+			go local.Write([]byte("ping"))
+			buf := make([]byte, 4)
+			_, err = io.ReadFull(remote, buf)
+			So(err, ShouldBeNil)
+			So(string(buf), ShouldEqual, "ping")
+			remote.Close()
+		})
+
+		Convey("Connecting to an IPv6 address dials it", func() {
+			status, err := socksRequest(local, socksAddrIPv6, net.ParseIP("2001:db8::1"), 443)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, socksReplySucceeded)
+			So(<-dialed, ShouldEqual, "[2001:db8::1]:443")
+			remote.Close()
+		})
+
+		Convey("Connecting to a host name dials it", func() {
+			status, err := socksRequest(local, socksAddrDomain, domain("example.com"), 80)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, socksReplySucceeded)
+			So(<-dialed, ShouldEqual, "example.com:80")
+			remote.Close()
+		})
+
+		Convey("Connecting to a SCION address dials it", func() {
+			status, err := socksRequest(local, socksAddrDomain, domain("1-ff00:0:110,[10.0.0.1]"), 8080)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, socksReplySucceeded)
+			So(<-dialed, ShouldEqual, "1-ff00:0:110,[10.0.0.1]:8080")
+			remote.Close()
+		})
+
+		Convey("Failing to dial is reported to the client", func() {
+			status, err := socksRequest(local, socksAddrDomain, domain("unreachable"), 80)
+			So(err, ShouldBeNil)
+			So(status, ShouldEqual, socksReplyHostUnreachable)
+			So(<-done, ShouldNotBeNil)
+		})
+
+		Convey("Other commands are rejected", func() {
+			go local.Write([]byte{socksVersion, 1, socksMethodNoAuth, socksVersion, 0x02, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 80})
+			var reply [12]byte
+			_, err := io.ReadFull(local, reply[:])
+			So(err, ShouldBeNil)
+			So(reply[3], ShouldEqual, socksReplyCommandNotSupported)
+			So(<-done, ShouldNotBeNil)
+		})
+	})
+}
@@ -15,19 +15,25 @@
 package ssh
 
 import (
+	"context"
 	"crypto/sha256"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	log "github.com/inconshreveable/log15"
+	"github.com/pkg/sftp"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 
 	"github.com/netsec-ethz/scion-apps/pkg/appnet/appquic"
 	"github.com/netsec-ethz/scion-apps/ssh/client/clientconfig"
@@ -38,12 +44,27 @@ import (
 	"github.com/netsec-ethz/scion-apps/ssh/utils"
 )
 
+// defaultIdentityFiles are the private key files tried if no IdentityFile is
+// configured.
+var defaultIdentityFiles = []string{
+	"~/.ssh/id_rsa",
+	"~/.ssh/id_ecdsa",
+	"~/.ssh/id_ed25519",
+	"~/.ssh/id_dsa",
+}
+
 // AuthenticationHandler is a function that represents an authentication method.
 type AuthenticationHandler func() (secret string, err error)
 
+// KeyboardInteractiveHandler is a function that answers the questions of a keyboard-interactive challenge, often by user interaction
+type KeyboardInteractiveHandler func(name, instruction string, questions []string, echos []bool) (answers []string, err error)
+
 // VerifyHostKeyHandler is a function that verifies host keys, often by user interaction
 type VerifyHostKeyHandler func(hostname string, remote net.Addr, key string) bool
 
+// PassphraseHandler is a function that returns the passphrase of an encrypted private key file.
+type PassphraseHandler func(file string) (passphrase []byte, err error)
+
 // Client is a struct representing an SSH client. It consists of a connection to the server, and at most one terminal session (per SSH specification, a connection may not serve multiple sessions)
 type Client struct {
 	config                          *ssh.ClientConfig
@@ -54,37 +75,92 @@ type Client struct {
 	client  *ssh.Client
 	session *ssh.Session
 	appConf *scionutils.PathAppConf
+
+	agentClient agent.ExtendedAgent
+
+	proxyCommand string
+	proxyJump    string
+
+	controlMaster  string
+	controlPath    string
+	controlPersist time.Duration
 }
 
 // Create creates a new unconnected Client.
 func Create(username string, config *clientconfig.ClientConfig, passAuthHandler AuthenticationHandler,
-	verifyNewKeyHandler VerifyHostKeyHandler, appConf *scionutils.PathAppConf) (*Client, error) {
+	kbdInteractiveHandler KeyboardInteractiveHandler, passphraseHandler PassphraseHandler, verifyNewKeyHandler VerifyHostKeyHandler, appConf *scionutils.PathAppConf) (*Client, error) {
 	client := &Client{
 		config: &ssh.ClientConfig{
 			User: username,
 		},
 		appConf: appConf,
 	}
+	if config.ProxyCommand != "none" {
+		client.proxyCommand = config.ProxyCommand
+	}
+	if config.ProxyJump != "none" {
+		client.proxyJump = config.ProxyJump
+	}
+	if config.ControlPath != "none" {
+		client.controlPath = config.ControlPath
+		client.controlMaster = config.ControlMaster
+		var err error
+		client.controlPersist, err = parseControlPersist(config.ControlPersist)
+		if err != nil {
+			return nil, err
+		}
+	}
 
-	var authMethods []ssh.AuthMethod
+	authMethods := make(map[string]ssh.AuthMethod)
 
-	// Load client private key
+	// Use the keys of the agent, then the client private keys. They must be
+	// offered by a single auth method, as each method is only tried once.
 	if config.PubkeyAuthentication == "yes" {
-		for i := len(config.IdentityFile) - 1; i >= 0; i-- {
-			am, err := loadPrivateKey(utils.ParsePath(config.IdentityFile[i]))
-			if err != nil {
-				log.Debug("Error loading private key, skipped.", "IdentityFile", config.IdentityFile[i], "err", err)
-			} else {
-				log.Debug("Loaded private key", "IdentityFile", config.IdentityFile[i])
-				authMethods = append(authMethods, am)
-			}
+		client.agentClient = connectAgent()
+
+		identityFiles := config.IdentityFile
+		if len(identityFiles) == 0 {
+			identityFiles = defaultIdentityFiles
 		}
+		// The keys are loaded when needed, so that connections over a control
+		// master don't prompt for passphrases
+		var signers []ssh.Signer
+		var loadSigners sync.Once
+		authMethods["publickey"] = ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			loadSigners.Do(func() {
+				for _, identityFile := range identityFiles {
+					keySigners, err := client.loadPrivateKey(utils.ParsePath(identityFile), passphraseHandler)
+					if err != nil {
+						log.Debug("Error loading private key, skipped.", "IdentityFile", identityFile, "err", err)
+					} else {
+						log.Debug("Loaded private key", "IdentityFile", identityFile)
+						signers = append(signers, keySigners...)
+					}
+				}
+			})
+			return append(client.agentSigners(), signers...), nil
+		})
+	}
+
+	// Use keyboard-interactive auth
+	if config.KbdInteractiveAuthentication == "yes" {
+		log.Debug("Configuring keyboard-interactive auth")
+		authMethods["keyboard-interactive"] = ssh.KeyboardInteractive(ssh.KeyboardInteractiveChallenge(kbdInteractiveHandler))
 	}
 
 	// Use password auth
 	if config.PasswordAuthentication == "yes" {
 		log.Debug("Configuring password auth")
-		authMethods = append(authMethods, ssh.PasswordCallback(passAuthHandler))
+		authMethods["password"] = ssh.PasswordCallback(passAuthHandler)
+	}
+
+	// Servers requiring several methods only accept the public key last, so
+	// the order of the methods is configurable
+	for _, method := range strings.Split(config.PreferredAuthentications, ",") {
+		if authMethod, ok := authMethods[strings.TrimSpace(method)]; ok {
+			client.config.Auth = append(client.config.Auth, authMethod)
+			delete(authMethods, strings.TrimSpace(method))
+		}
 	}
 
 	if config.StrictHostKeyChecking != "no" {
@@ -110,17 +186,22 @@ func Create(username string, config *clientconfig.ClientConfig, passAuthHandler
 		log.Debug("Not verifying host key!")
 		client.config.HostKeyCallback = ssh.InsecureIgnoreHostKey()
 	}
-	client.config.Auth = authMethods
-
 	return client, nil
 }
 
-// Connect connects the Client to the given address.
+// Connect connects the Client to the given address, over the control master
+// if one is configured.
 func (client *Client) Connect(addr string) error {
-	goClient, err := sssh.DialSCIONWithConf(addr, client.config, client.appConf)
+	goClient, err := client.dialControlMaster(addr)
 	if err != nil {
 		return err
 	}
+	if goClient == nil {
+		goClient, err = client.dial(addr)
+		if err != nil {
+			return err
+		}
+	}
 
 	client.client = goClient
 
@@ -132,6 +213,37 @@ func (client *Client) Connect(addr string) error {
 	return nil
 }
 
+// SendEnv sends the local environment variables matching one of patterns to
+// the server. Each pattern may contain several whitespace-separated patterns.
+// Variables the server does not accept are skipped.
+func (client *Client) SendEnv(patterns []string) {
+	var names []string
+	for _, pattern := range patterns {
+		names = append(names, strings.Fields(pattern)...)
+	}
+	for _, variable := range os.Environ() {
+		kv := strings.SplitN(variable, "=", 2)
+		for _, pattern := range names {
+			if matched, _ := path.Match(pattern, kv[0]); matched {
+				err := client.session.Setenv(kv[0], kv[1])
+				if err != nil {
+					log.Debug("Server rejected environment variable", "name", kv[0])
+				}
+				break
+			}
+		}
+	}
+}
+
+// ExitStatus returns the exit status of the remote command if err reports
+// that it failed or was killed by a signal.
+func ExitStatus(err error) (int, bool) {
+	if exitErr, ok := err.(*ssh.ExitError); ok {
+		return exitErr.ExitStatus(), true
+	}
+	return 0, false
+}
+
 // RunSession runs a terminal session, waiting for it to end.
 func (client *Client) RunSession(cmd string) error {
 	return client.session.Run(cmd)
@@ -175,11 +287,15 @@ func (client *Client) forward(addr string, localConn net.Conn) error {
 		return err
 	}
 
+	connect(localConn, remoteConn)
+	return nil
+}
+
+func connect(localConn, remoteConn net.Conn) {
 	close := func() {
 		localConn.Close()
 		remoteConn.Close()
 	}
-	_ = close
 
 	var once sync.Once
 	go func() {
@@ -190,12 +306,10 @@ func (client *Client) forward(addr string, localConn net.Conn) error {
 		io.Copy(remoteConn, localConn)
 		once.Do(close)
 	}()
-
-	return nil
 }
 
-// StartTunnel creates a new tunnel to the given address, forwarding all connections on the given port over the server to the given address. If the given address is a SCION address, QUIC is used; else TCP.
-func (client *Client) StartTunnel(localPort uint16, addr string) error {
+// StartTunnel creates a new tunnel to the given address, forwarding all connections on the given bind address and port over the server to the given address. If the given address is a SCION address, QUIC is used and the bind address is ignored; else TCP.
+func (client *Client) StartTunnel(bindAddress string, localPort uint16, addr string) error {
 	if strings.Contains(addr, ",") {
 		localListener, err := appquic.ListenPort(localPort, nil, nil)
 		if err != nil {
@@ -205,13 +319,13 @@ func (client *Client) StartTunnel(localPort uint16, addr string) error {
 		go func() {
 			defer localListener.Close()
 			for {
-				sess, err := localListener.Accept()
+				sess, err := localListener.Accept(context.Background())
 				if err != nil {
 					log.Debug("Error accepting tunnel listener: ", err)
 					continue
 				}
 
-				stream, err := sess.AcceptStream()
+				stream, err := sess.AcceptStream(context.Background())
 				if err != nil {
 					log.Debug("Error accepting tunnel listener session: ", err)
 					continue
@@ -225,7 +339,7 @@ func (client *Client) StartTunnel(localPort uint16, addr string) error {
 			}
 		}()
 	} else {
-		localListener, err := net.Listen("tcp", fmt.Sprintf("0.0.0.0:%v", localPort))
+		localListener, err := net.Listen("tcp", net.JoinHostPort(bindAddress, strconv.Itoa(int(localPort))))
 		if err != nil {
 			return err
 		}
@@ -251,6 +365,58 @@ func (client *Client) StartTunnel(localPort uint16, addr string) error {
 	return nil
 }
 
+// StartRemoteTunnel requests the server to listen on the given bind address and port, forwarding all connections over the client to the given address. An empty bind address requests all interfaces. If the given address is a SCION address, the server listens for QUIC sessions on its SCION address and QUIC is used to connect to the address; else TCP.
+func (client *Client) StartRemoteTunnel(bindAddress string, remotePort uint16, addr string) error {
+	var remoteListener net.Listener
+	var err error
+	if strings.Contains(addr, ",") {
+		remoteListener, err = sssh.TunnelListenSCION(client.client, remotePort)
+	} else {
+		bindAddr := &net.TCPAddr{IP: net.IPv4zero, Port: int(remotePort)}
+		if bindAddress != "" {
+			bindAddr, err = net.ResolveTCPAddr("tcp", net.JoinHostPort(bindAddress, strconv.Itoa(int(remotePort))))
+			if err != nil {
+				return err
+			}
+		}
+		remoteListener, err = client.client.ListenTCP(bindAddr)
+	}
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		defer remoteListener.Close()
+		for {
+			remoteConn, err := remoteListener.Accept()
+			if err != nil {
+				log.Debug("Error accepting remote tunnel listener: ", err)
+				return
+			}
+
+			go func() {
+				localConn, err := dialLocal(addr)
+				if err != nil {
+					log.Debug("Error forwarding remote connection: ", err)
+					remoteConn.Close()
+					return
+				}
+				connect(localConn, remoteConn)
+			}()
+		}
+	}()
+
+	return nil
+}
+
+// dialLocal dials the given address directly from the client. If the given address is a SCION address, QUIC is used; else TCP.
+func dialLocal(addr string) (net.Conn, error) {
+	if strings.Contains(addr, ",") {
+		return quicconn.Dial(addr)
+	}
+	return net.Dial("tcp", addr)
+}
+
 // Dial dials the given address over a tunnel to the server. If the given address is a SCION address, QUIC is used; else TCP.
 func (client *Client) Dial(addr string) (net.Conn, error) {
 	if strings.Contains(addr, ",") {
@@ -271,12 +437,22 @@ func (client *Client) DialSCION(addr string) (net.Conn, error) {
 	return sssh.TunnelDialSCION(client.client, addr)
 }
 
-// CloseSession closes the current session
+// NewSFTPClient starts the sftp subsystem on the server and returns an SFTP client using it.
+func (client *Client) NewSFTPClient() (*sftp.Client, error) {
+	return sftp.NewClient(client.client)
+}
+
+// CloseSession closes the current session and the connection, including
+// those to jump hosts.
 func (client *Client) CloseSession() {
 	client.session.Close()
+	client.client.Close()
 }
 
-func loadPrivateKey(filePath string) (ssh.AuthMethod, error) {
+// loadPrivateKey loads a private key, and its certificate if there is one.
+// Encrypted keys are decrypted with a passphrase from passphraseHandler, unless
+// they are held by the agent.
+func (client *Client) loadPrivateKey(filePath string, passphraseHandler PassphraseHandler) ([]ssh.Signer, error) {
 	absolutePath, err := filepath.Abs(filePath)
 	if err != nil {
 		return nil, err
@@ -288,16 +464,65 @@ func loadPrivateKey(filePath string) (ssh.AuthMethod, error) {
 	}
 
 	privateKey, err := ssh.ParsePrivateKey(key)
+	if missing, ok := err.(*ssh.PassphraseMissingError); ok {
+		if missing.PublicKey != nil && client.agentHasKey(missing.PublicKey) {
+			return nil, nil
+		}
+		passphrase, err := passphraseHandler(absolutePath)
+		if err != nil {
+			return nil, err
+		}
+		privateKey, err = ssh.ParsePrivateKeyWithPassphrase(key, passphrase)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Offer the certificate of the key first, if there is one
+	certSigner, err := loadCertificate(absolutePath+"-cert.pub", privateKey)
+	if os.IsNotExist(err) {
+		return []ssh.Signer{privateKey}, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	return []ssh.Signer{certSigner, privateKey}, nil
+}
+
+// loadCertificate loads the certificate at filePath for the private key
+// signer.
+func loadCertificate(filePath string, signer ssh.Signer) (ssh.Signer, error) {
+	certBytes, err := ioutil.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	pubKey, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
 	if err != nil {
 		return nil, err
 	}
+	cert, ok := pubKey.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a certificate", filePath)
+	}
 
-	return ssh.PublicKeys(privateKey), nil
+	return ssh.NewCertSigner(cert, signer)
 }
 
 func (client *Client) verifyHostKey(hostname string, remote net.Addr, key ssh.PublicKey) error {
 	log.Debug("Checking new host signature host: %s", remote.String())
 
+	// Certificates of unknown authorities are accepted as plain host keys
+	if cert, ok := key.(*ssh.Certificate); ok {
+		err := client.knownHostsFileHandler(hostname, remote, key)
+		if _, unknown := err.(*knownhosts.KeyError); !unknown {
+			return err
+		}
+		key = cert.Key
+	}
+
 	err := client.knownHostsFileHandler(hostname, remote, key)
 	if err != nil {
 		switch e := err.(type) {
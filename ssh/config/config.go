@@ -15,28 +15,47 @@
 package config
 
 import (
-	"bufio"
 	"fmt"
 	"io"
-	"log"
 	"os"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 // Config is an interface representing a configuration file
 type Config interface {
 }
 
-// UpdateFromString updates the given config from the single-line configuration string.
+var optionRegex = regexp.MustCompile(`^(\S+?)\s*(?:\s|=)\s*(.*)$`)
+
+// UpdateFromString updates the given config from the single-line configuration
+// string. Options are checked as in a configuration file.
 func UpdateFromString(conf Config, confOption string) error {
-	split := regexp.MustCompile(`(.*?)\s*[\s=]\s*(.*)`).FindStringSubmatch(confOption)
-	if len(split) < 3 {
-		return fmt.Errorf("can't parse config file line: %s", confOption)
+	name, value, err := splitOption(confOption)
+	if err != nil {
+		return err
 	}
 
-	return Set(conf, split[1], split[2])
+	return newParser(conf, nil).set(name, value, true)
+}
+
+// splitOption splits a configuration line into keyword and value. Keyword
+// and value are separated by whitespace or "=", a value enclosed in double
+// quotes is unquoted.
+func splitOption(confOption string) (string, string, error) {
+	split := optionRegex.FindStringSubmatch(strings.TrimSpace(confOption))
+	if split == nil {
+		return "", "", fmt.Errorf("can't parse config file line: %s", confOption)
+	}
+	value := strings.TrimSpace(split[2])
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) &&
+		!strings.Contains(value[1:len(value)-1], `"`) {
+		value = value[1 : len(value)-1]
+	}
+	return split[1], value, nil
 }
 
 func cToString(valueA interface{}) string {
@@ -50,26 +69,48 @@ func cToString(valueA interface{}) string {
 	return fmt.Sprintf("%v", valueA)
 }
 
+// lookupField returns the field of conf for the option name. Option names are
+// case-insensitive, and fields may have additional names in their alias tag.
+func lookupField(conf Config, name string) (reflect.StructField, bool) {
+	confType := reflect.TypeOf(conf).Elem()
+	for i := 0; i < confType.NumField(); i++ {
+		field := confType.Field(i)
+		if strings.EqualFold(field.Name, name) {
+			return field, true
+		}
+		for _, alias := range strings.Fields(field.Tag.Get("alias")) {
+			if strings.EqualFold(alias, name) {
+				return field, true
+			}
+		}
+	}
+	return reflect.StructField{}, false
+}
+
 // Set sets the given option on the given configuration file.
 func Set(conf Config, name string, valueA interface{}) error {
 	value := cToString(valueA)
 
-	typeToSet, _ := reflect.TypeOf(conf).Elem().FieldByName(name)
-	fieldToSet := reflect.ValueOf(conf).Elem().FieldByName(name)
-	if !fieldToSet.IsValid() || !fieldToSet.CanSet() {
-		return fmt.Errorf("unknown config option: %s %+v", name, fieldToSet)
+	typeToSet, exists := lookupField(conf, name)
+	if !exists {
+		return fmt.Errorf("unknown config option: %s", name)
+	}
+	fieldToSet := reflect.ValueOf(conf).Elem().FieldByIndex(typeToSet.Index)
+	if !fieldToSet.CanSet() {
+		return fmt.Errorf("unknown config option: %s", name)
 	}
 
-	checkRegexStr := fmt.Sprintf("^%s$", typeToSet.Tag.Get("regex"))
-	// log.Printf("%s %v", checkRegexStr, typeToSet.Tag)
-	checkRegex := regexp.MustCompile(checkRegexStr)
-	if !checkRegex.MatchString(value) {
-		return fmt.Errorf("value for option %s doesn't fit regex %s: %s", name, checkRegexStr, value)
+	if regex, ok := typeToSet.Tag.Lookup("regex"); ok {
+		checkRegexStr := fmt.Sprintf("^%s$", regex)
+		checkRegex := regexp.MustCompile(checkRegexStr)
+		if !checkRegex.MatchString(value) {
+			return fmt.Errorf("value for option %s doesn't fit regex %s: %s", typeToSet.Name, checkRegexStr, value)
+		}
 	}
 
 	val, add, err := parseConfigValue(strings.TrimSpace(value), fieldToSet.Type())
 	if err != nil {
-		return fmt.Errorf("can't parse config value for option %s: %s", name, value)
+		return fmt.Errorf("can't parse config value for option %s: %s: %v", typeToSet.Name, value, err)
 	}
 
 	if add {
@@ -89,53 +130,101 @@ func SetIfNot(conf Config, name string, value, not interface{}) (bool, error) {
 	return false, Set(conf, name, value)
 }
 
-// UpdateFromFile automatically reads a file and updates the configuration object from its contents.
+// UpdateFromFile automatically reads a file and updates the configuration
+// object from its contents. Only the Host and Match blocks matching any host
+// apply, see UpdateFromFileWithContext.
 func UpdateFromFile(conf Config, path string) error {
-	file, err := os.Open(path)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	return UpdateFromReader(conf, file)
+	return UpdateFromFileWithContext(conf, path, nil)
 }
 
-// UpdateFromReader takes a reader and updates the configuration object from its contents.
+// UpdateFromReader takes a reader and updates the configuration object from
+// its contents. Only the Host and Match blocks matching any host apply, see
+// UpdateFromReaderWithContext.
 func UpdateFromReader(conf Config, reader io.Reader) error {
-	lines := make([]string, 0)
-
-	scanner := bufio.NewScanner(reader)
-	for scanner.Scan() {
-		text := strings.TrimSpace(scanner.Text())
-		if strings.HasPrefix(text, "#") || len(text) == 0 {
-			continue
-		}
-		lines = append(lines, text)
-	}
-
-	for i := len(lines) - 1; i >= 0; i-- {
-		err := UpdateFromString(conf, lines[i])
-		if err != nil {
-			log.Printf("Error while updating config: %v", err)
-		}
-	}
+	return UpdateFromReaderWithContext(conf, reader, "config", nil)
+}
 
-	err := scanner.Err()
+// UpdateFromFileWithContext reads a file and updates the configuration object
+// from its contents, applying the Host and Match blocks matching ctx.
+func UpdateFromFileWithContext(conf Config, path string, ctx *Context) error {
+	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 
-	return nil
+	return newParser(conf, ctx).parse(file, path, true, 0)
+}
+
+// UpdateFromReaderWithContext takes a reader and updates the configuration
+// object from its contents, applying the Host and Match blocks matching ctx.
+// name is used in error messages and to resolve relative Include paths.
+func UpdateFromReaderWithContext(conf Config, reader io.Reader, name string, ctx *Context) error {
+	return newParser(conf, ctx).parse(reader, name, true, 0)
 }
 
+var durationType = reflect.TypeOf(time.Duration(0))
+
 func parseConfigValue(confval string, tpye reflect.Type) (reflect.Value, bool, error) {
+	if tpye == durationType {
+		d, err := ParseTime(confval)
+		return reflect.ValueOf(d), false, err
+	}
 	switch tpye.Kind() {
 	case reflect.Slice:
 		val, _, err := parseConfigValue(confval, tpye.Elem())
 		return val, true, err
 	case reflect.String:
-		return reflect.ValueOf(confval), false, nil
+		return reflect.ValueOf(confval).Convert(tpye), false, nil
+	case reflect.Bool:
+		switch strings.ToLower(confval) {
+		case "yes", "true":
+			return reflect.ValueOf(true).Convert(tpye), false, nil
+		case "no", "false":
+			return reflect.ValueOf(false).Convert(tpye), false, nil
+		}
+		return reflect.Zero(tpye), false, fmt.Errorf("expected yes or no")
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, err := strconv.ParseInt(confval, 10, tpye.Bits())
+		return reflect.ValueOf(i).Convert(tpye), false, err
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		u, err := strconv.ParseUint(confval, 10, tpye.Bits())
+		return reflect.ValueOf(u).Convert(tpye), false, err
 	default:
 		return reflect.Zero(tpye), false, fmt.Errorf("Config field type not supported! %v", tpye)
 	}
 }
+
+var timeRegex = regexp.MustCompile(`(\d+)([sSmMhHdDwW]?)`)
+
+// ParseTime parses a time in the format of OpenSSH, a sequence of numbers
+// each followed by an optional unit: s (seconds, the default), m (minutes),
+// h (hours), d (days) or w (weeks), such as "1h30m" or "90".
+func ParseTime(value string) (time.Duration, error) {
+	units := map[string]time.Duration{
+		"":  time.Second,
+		"s": time.Second,
+		"m": time.Minute,
+		"h": time.Hour,
+		"d": 24 * time.Hour,
+		"w": 7 * 24 * time.Hour,
+	}
+	if value == "" {
+		return 0, fmt.Errorf("invalid time %q", value)
+	}
+	var total time.Duration
+	rest := value
+	for rest != "" {
+		match := timeRegex.FindStringSubmatchIndex(rest)
+		if match == nil || match[0] != 0 {
+			return 0, fmt.Errorf("invalid time %q", value)
+		}
+		n, err := strconv.Atoi(rest[match[2]:match[3]])
+		if err != nil {
+			return 0, fmt.Errorf("invalid time %q", value)
+		}
+		total += time.Duration(n) * units[strings.ToLower(rest[match[4]:match[5]])]
+		rest = rest[match[1]:]
+	}
+	return total, nil
+}
@@ -70,5 +70,12 @@ func TestConfig(t *testing.T) {
 			So(b, ShouldEqual, false)
 			So(len(myStruct.B), ShouldEqual, 1)
 		})
+
+		Convey("Options from strings are checked like configuration file lines", func() {
+			So(UpdateFromString(myStruct, "FooBar yes"), ShouldNotBeNil)
+			So(UpdateFromString(myStruct, "AllowUsers alice"), ShouldNotBeNil)
+			So(UpdateFromString(myStruct, "A=abc"), ShouldBeNil)
+			So(myStruct.A, ShouldEqual, "abc")
+		})
 	})
 }
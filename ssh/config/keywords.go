@@ -0,0 +1,235 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import "strings"
+
+// openSSHKeywords are the options of ssh_config(5) and sshd_config(5),
+// including deprecated ones and common distribution patches. Those that are
+// not supported are ignored, so that existing configuration files can be used.
+var openSSHKeywords = map[string]bool{
+	"acceptenv":                        true,
+	"addkeystoagent":                   true,
+	"addressfamily":                    true,
+	"allowagentforwarding":             true,
+	"allowgroups":                      true,
+	"allowstreamlocalforwarding":       true,
+	"allowtcpforwarding":               true,
+	"allowusers":                       true,
+	"authenticationmethods":            true,
+	"authorizedkeyscommand":            true,
+	"authorizedkeyscommanduser":        true,
+	"authorizedkeysfile":               true,
+	"authorizedprincipalscommand":      true,
+	"authorizedprincipalscommanduser":  true,
+	"authorizedprincipalsfile":         true,
+	"banner":                           true,
+	"batchmode":                        true,
+	"bindaddress":                      true,
+	"bindinterface":                    true,
+	"canonicaldomains":                 true,
+	"canonicalizefallbacklocal":        true,
+	"canonicalizehostname":             true,
+	"canonicalizemaxdots":              true,
+	"canonicalizepermittedcnames":      true,
+	"casignaturealgorithms":            true,
+	"certificatefile":                  true,
+	"challengeresponseauthentication":  true,
+	"checkhostip":                      true,
+	"chrootdirectory":                  true,
+	"cipher":                           true,
+	"ciphers":                          true,
+	"clearallforwardings":              true,
+	"clientalivecountmax":              true,
+	"clientaliveinterval":              true,
+	"compression":                      true,
+	"compressionlevel":                 true,
+	"connectionattempts":               true,
+	"connecttimeout":                   true,
+	"controlmaster":                    true,
+	"controlpath":                      true,
+	"controlpersist":                   true,
+	"debianbanner":                     true,
+	"denygroups":                       true,
+	"denyusers":                        true,
+	"disableforwarding":                true,
+	"dynamicforward":                   true,
+	"enablesshkeysign":                 true,
+	"escapechar":                       true,
+	"exitonforwardfailure":             true,
+	"exposeauthinfo":                   true,
+	"fingerprinthash":                  true,
+	"forcecommand":                     true,
+	"forkafterauthentication":          true,
+	"forwardagent":                     true,
+	"forwardx11":                       true,
+	"forwardx11timeout":                true,
+	"forwardx11trusted":                true,
+	"gatewayports":                     true,
+	"globalknownhostsfile":             true,
+	"gssapiauthentication":             true,
+	"gssapicleanupcredentials":         true,
+	"gssapiclientidentity":             true,
+	"gssapidelegatecredentials":        true,
+	"gssapikexalgorithms":              true,
+	"gssapikeyexchange":                true,
+	"gssapirenewalforcesrekey":         true,
+	"gssapiserveridentity":             true,
+	"gssapistorecredentialsonrekey":    true,
+	"gssapistrictacceptorcheck":        true,
+	"gssapitrustdns":                   true,
+	"hashknownhosts":                   true,
+	"hostbasedacceptedalgorithms":      true,
+	"hostbasedauthentication":          true,
+	"hostbasedkeytypes":                true,
+	"hostbasedusesnamefrompacketonly":  true,
+	"hostcertificate":                  true,
+	"hostkey":                          true,
+	"hostkeyagent":                     true,
+	"hostkeyalgorithms":                true,
+	"hostkeyalias":                     true,
+	"hostname":                         true,
+	"identitiesonly":                   true,
+	"identityagent":                    true,
+	"identityfile":                     true,
+	"ignorerhosts":                     true,
+	"ignoreuserknownhosts":             true,
+	"ipqos":                            true,
+	"kbdinteractiveauthentication":     true,
+	"kbdinteractivedevices":            true,
+	"kerberosauthentication":           true,
+	"kerberosgetafstoken":              true,
+	"kerberosorlocalpasswd":            true,
+	"kerberosticketcleanup":            true,
+	"kexalgorithms":                    true,
+	"keyregenerationinterval":          true,
+	"knownhostscommand":                true,
+	"listenaddress":                    true,
+	"localcommand":                     true,
+	"localforward":                     true,
+	"logingracetime":                   true,
+	"loglevel":                         true,
+	"logverbose":                       true,
+	"macs":                             true,
+	"maxauthtries":                     true,
+	"maxsessions":                      true,
+	"maxstartups":                      true,
+	"modulifile":                       true,
+	"nohostauthenticationforlocalhost": true,
+	"numberofpasswordprompts":          true,
+	"passwordauthentication":           true,
+	"permitemptypasswords":             true,
+	"permitlisten":                     true,
+	"permitlocalcommand":               true,
+	"permitopen":                       true,
+	"permitremoteopen":                 true,
+	"permitrootlogin":                  true,
+	"permittty":                        true,
+	"permittunnel":                     true,
+	"permituserenvironment":            true,
+	"permituserrc":                     true,
+	"persourcemaxstartups":             true,
+	"persourcenetblocksize":            true,
+	"pidfile":                          true,
+	"pkcs11provider":                   true,
+	"port":                             true,
+	"preferredauthentications":         true,
+	"printlastlog":                     true,
+	"printmotd":                        true,
+	"protocol":                         true,
+	"proxycommand":                     true,
+	"proxyjump":                        true,
+	"proxyusefdpass":                   true,
+	"pubkeyacceptedalgorithms":         true,
+	"pubkeyacceptedkeytypes":           true,
+	"pubkeyauthentication":             true,
+	"pubkeyauthoptions":                true,
+	"rdomain":                          true,
+	"rekeylimit":                       true,
+	"remotecommand":                    true,
+	"remoteforward":                    true,
+	"requesttty":                       true,
+	"revokedhostkeys":                  true,
+	"revokedkeys":                      true,
+	"rhostsrsaauthentication":          true,
+	"rsaauthentication":                true,
+	"securitykeyprovider":              true,
+	"sendenv":                          true,
+	"serveralivecountmax":              true,
+	"serveraliveinterval":              true,
+	"serverkeybits":                    true,
+	"sessiontype":                      true,
+	"setenv":                           true,
+	"smartcarddevice":                  true,
+	"stdinnull":                        true,
+	"streamlocalbindmask":              true,
+	"streamlocalbindunlink":            true,
+	"stricthostkeychecking":            true,
+	"strictmodes":                      true,
+	"subsystem":                        true,
+	"syslogfacility":                   true,
+	"tcpkeepalive":                     true,
+	"trustedusercakeys":                true,
+	"tunnel":                           true,
+	"tunneldevice":                     true,
+	"updatehostkeys":                   true,
+	"usedns":                           true,
+	"usekeychain":                      true,
+	"uselogin":                         true,
+	"usepam":                           true,
+	"useprivilegedport":                true,
+	"useprivilegeseparation":           true,
+	"user":                             true,
+	"userknownhostsfile":               true,
+	"useroaming":                       true,
+	"verifyhostkeydns":                 true,
+	"versionaddendum":                  true,
+	"visualhostkey":                    true,
+	"x11displayoffset":                 true,
+	"x11forwarding":                    true,
+	"x11uselocalhost":                  true,
+	"xauthlocation":                    true,
+}
+
+func isOpenSSHKeyword(keyword string) bool {
+	return openSSHKeywords[strings.ToLower(keyword)]
+}
+
+// accessControlKeywords are the options of sshd_config(5) that restrict access
+// to the server. They are not supported, and ignoring them would leave the
+// server less restricted than configured, so they are an error instead.
+var accessControlKeywords = map[string]bool{
+	"allowgroups":                     true,
+	"allowtcpforwarding":              true,
+	"allowusers":                      true,
+	"authorizedkeyscommand":           true,
+	"authorizedkeyscommanduser":       true,
+	"authorizedprincipalscommand":     true,
+	"authorizedprincipalscommanduser": true,
+	"denygroups":                      true,
+	"denyusers":                       true,
+	"disableforwarding":               true,
+	"maxsessions":                     true,
+	"permitemptypasswords":            true,
+	"permitlisten":                    true,
+	"permitopen":                      true,
+	"permitrootlogin":                 true,
+	"permittty":                       true,
+	"revokedkeys":                     true,
+}
+
+func isAccessControlKeyword(keyword string) bool {
+	return accessControlKeywords[strings.ToLower(keyword)]
+}
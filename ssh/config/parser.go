@@ -0,0 +1,296 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/netsec-ethz/scion-apps/ssh/utils"
+)
+
+// maxIncludeDepth limits nested Include directives.
+const maxIncludeDepth = 16
+
+// Context describes the connection that Host and Match blocks are matched
+// against. A nil Context is used for configurations that apply to all
+// connections, like sshd_config: it only matches "Host *", and Match blocks
+// other than "Match all" are an error.
+type Context struct {
+	// Host is the host name or SCION address given on the command line
+	Host string
+	// User is the remote user name
+	User string
+	// LocalUser is the name of the local user
+	LocalUser string
+}
+
+// parser applies configuration files in the format of ssh_config(5) and
+// sshd_config(5). As in OpenSSH, the first value of an option is used, while
+// options taking a list of values accumulate them.
+type parser struct {
+	conf Config
+	ctx  *Context
+	// global is set if the configuration applies to all connections
+	global        bool
+	seen          map[string]bool
+	ignoreUnknown []string
+}
+
+func newParser(conf Config, ctx *Context) *parser {
+	p := &parser{
+		conf: conf,
+		ctx:  ctx,
+		seen: make(map[string]bool),
+	}
+	if ctx == nil {
+		p.ctx = &Context{}
+		p.global = true
+	}
+	return p
+}
+
+// parse applies the lines of reader. active is whether the lines before the
+// first Host or Match block apply.
+func (p *parser) parse(reader io.Reader, name string, active bool, depth int) error {
+	scanner := bufio.NewScanner(reader)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		text := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(text, "#") || len(text) == 0 {
+			continue
+		}
+
+		keyword, value, err := splitOption(text)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", name, lineNum, err)
+		}
+		switch strings.ToLower(keyword) {
+		case "host":
+			active = p.matchHost(value)
+		case "match":
+			active, err = p.matchCriteria(value)
+		case "include":
+			if active {
+				err = p.include(name, value, depth)
+			}
+		case "ignoreunknown":
+			if active {
+				p.ignoreUnknown = append(p.ignoreUnknown, strings.Split(value, ",")...)
+			}
+		default:
+			err = p.set(keyword, value, active)
+		}
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", name, lineNum, err)
+		}
+	}
+
+	return scanner.Err()
+}
+
+// set sets the option keyword, if active and no value was set before.
+// Unknown options are an error, except for options of OpenSSH that are not
+// supported, and those listed in IgnoreUnknown. Unsupported options that
+// restrict access are always an error.
+func (p *parser) set(keyword, value string, active bool) error {
+	field, exists := lookupField(p.conf, keyword)
+	if !exists {
+		if isAccessControlKeyword(keyword) {
+			return fmt.Errorf("unsupported option %s, its restrictions would not be enforced", keyword)
+		}
+		if isOpenSSHKeyword(keyword) || matchPatternList(strings.Join(p.ignoreUnknown, ","), keyword) {
+			return nil
+		}
+		return fmt.Errorf("unknown option %s", keyword)
+	}
+	if !active {
+		return nil
+	}
+
+	if field.Type.Kind() != reflect.Slice {
+		if p.seen[field.Name] {
+			return nil
+		}
+		p.seen[field.Name] = true
+	}
+	return Set(p.conf, field.Name, value)
+}
+
+// include applies the files matching the whitespace-separated glob patterns.
+// Relative paths are relative to the directory of the including file.
+func (p *parser) include(name, value string, depth int) error {
+	if depth >= maxIncludeDepth {
+		return fmt.Errorf("too many nested includes")
+	}
+	patterns := strings.Fields(value)
+	if len(patterns) == 0 {
+		return fmt.Errorf("missing Include path")
+	}
+	for _, pattern := range patterns {
+		pattern = utils.ParsePath(pattern)
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(name), pattern)
+		}
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			if err := p.includeFile(file, depth); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (p *parser) includeFile(file string, depth int) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return p.parse(f, file, true, depth+1)
+}
+
+// matchHost returns whether the Host line with the whitespace-separated
+// patterns matches. A matching negated pattern prevents the match.
+func (p *parser) matchHost(value string) bool {
+	host := strings.ToLower(p.ctx.Host)
+	matched := false
+	for _, pattern := range strings.Fields(strings.ToLower(value)) {
+		if strings.HasPrefix(pattern, "!") {
+			if matchWildcard(pattern[1:], host) {
+				return false
+			}
+		} else if matchWildcard(pattern, host) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// matchCriteria returns whether all criteria of a Match line match. Criteria
+// may be negated with a leading "!". Without a Context, only "Match all" is
+// allowed, as the other criteria depend on the connection.
+func (p *parser) matchCriteria(value string) (bool, error) {
+	args := strings.Fields(value)
+	if len(args) == 0 {
+		return false, fmt.Errorf("missing Match criteria")
+	}
+	if p.global && !(len(args) == 1 && strings.ToLower(args[0]) == "all") {
+		return false, fmt.Errorf("Match blocks are not supported in this configuration")
+	}
+	result := true
+	for i := 0; i < len(args); i++ {
+		criterion := strings.ToLower(args[i])
+		negated := strings.HasPrefix(criterion, "!")
+		criterion = strings.TrimPrefix(criterion, "!")
+
+		if criterion == "all" {
+			if len(args) != 1 {
+				return false, fmt.Errorf("Match all must appear alone")
+			}
+			return !negated, nil
+		}
+
+		if i+1 >= len(args) {
+			return false, fmt.Errorf("missing argument for Match %s", criterion)
+		}
+		i++
+		var matched bool
+		switch criterion {
+		case "host", "originalhost":
+			matched = matchPatternList(strings.ToLower(args[i]), strings.ToLower(p.ctx.Host))
+		case "user":
+			matched = matchPatternList(args[i], p.ctx.User)
+		case "localuser":
+			matched = matchPatternList(args[i], p.ctx.LocalUser)
+		default:
+			return false, fmt.Errorf("unsupported Match criterion %s", criterion)
+		}
+		if matched == negated {
+			result = false
+		}
+	}
+	return result, nil
+}
+
+// matchPatternList returns whether s matches the comma-separated list of
+// patterns, which may contain the wildcards "*" and "?". If s matches a
+// pattern negated with a leading "!", the list does not match.
+func matchPatternList(list, s string) bool {
+	matched := false
+	for _, pattern := range splitPatternList(list) {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		if strings.HasPrefix(pattern, "!") {
+			if matchWildcard(pattern[1:], s) {
+				return false
+			}
+		} else if matchWildcard(pattern, s) {
+			matched = true
+		}
+	}
+	return matched
+}
+
+// splitPatternList splits a comma-separated list of patterns. A comma
+// followed by "[" separates the IA and host of a SCION address instead.
+func splitPatternList(list string) []string {
+	var patterns []string
+	start := 0
+	for i := 0; i < len(list); i++ {
+		if list[i] == ',' && (i+1 >= len(list) || list[i+1] != '[') {
+			patterns = append(patterns, list[start:i])
+			start = i + 1
+		}
+	}
+	return append(patterns, list[start:])
+}
+
+// matchWildcard matches s against pattern, where "*" matches any sequence of
+// characters and "?" a single character. Unlike path.Match, brackets have no
+// special meaning, as they are part of SCION addresses.
+func matchWildcard(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for i := len(s); i >= 0; i-- {
+				if matchWildcard(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern, s = pattern[1:], s[1:]
+	}
+	return len(s) == 0
+}
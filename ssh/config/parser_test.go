@@ -0,0 +1,228 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testConfig struct {
+	User          string
+	HostAddress   string `alias:"HostName"`
+	Port          string `regex:"\\d+"`
+	ListenAddress []string
+	Compression   bool
+	MaxAuthTries  int
+	Timeout       time.Duration
+}
+
+func parseString(conf Config, s string, ctx *Context) error {
+	return UpdateFromReaderWithContext(conf, strings.NewReader(s), "test_config", ctx)
+}
+
+func TestParser(t *testing.T) {
+	Convey("Given a config file with options and lists", t, func() {
+		configString := `
+			# comment
+			port 22
+			Port=2222
+			ListenAddress 10.0.0.1
+			ListenAddress "[::1]:22"
+			Compression yes
+			MaxAuthTries 3
+			Timeout 1m30
+		`
+
+		Convey("The first value of an option is used and lists accumulate", func() {
+			conf := &testConfig{}
+			So(parseString(conf, configString, nil), ShouldBeNil)
+			So(conf.Port, ShouldEqual, "22")
+			So(conf.ListenAddress, ShouldResemble, []string{"10.0.0.1", "[::1]:22"})
+		})
+
+		Convey("Values are parsed according to their type", func() {
+			conf := &testConfig{}
+			So(parseString(conf, configString, nil), ShouldBeNil)
+			So(conf.Compression, ShouldBeTrue)
+			So(conf.MaxAuthTries, ShouldEqual, 3)
+			So(conf.Timeout, ShouldEqual, 90*time.Second)
+		})
+
+		Convey("Invalid values are reported with their line", func() {
+			conf := &testConfig{}
+			err := parseString(conf, "User a\nCompression maybe\n", nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "test_config:2:")
+		})
+	})
+
+	Convey("Given a config file with unknown options", t, func() {
+		Convey("Unknown options are an error", func() {
+			conf := &testConfig{}
+			err := parseString(conf, "User a\nFooBar yes\n", nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldEqual, "test_config:2: unknown option FooBar")
+		})
+
+		Convey("Unsupported OpenSSH options are ignored", func() {
+			conf := &testConfig{}
+			So(parseString(conf, "ServerAliveInterval 30\nUser a\n", nil), ShouldBeNil)
+			So(conf.User, ShouldEqual, "a")
+		})
+
+		Convey("Options listed in IgnoreUnknown are ignored", func() {
+			conf := &testConfig{}
+			So(parseString(conf, "IgnoreUnknown Foo*,Baz\nFooBar yes\nBaz 1\n", nil), ShouldBeNil)
+		})
+
+		Convey("Unsupported options restricting access are an error", func() {
+			conf := &testConfig{}
+			err := parseString(conf, "IgnoreUnknown *\nAllowUsers alice\n", nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "test_config:2:")
+			So(parseString(conf, "Host other\nPermitRootLogin no\n", &Context{Host: "gateway"}), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given a config file with Host and Match blocks", t, func() {
+		configString := `
+			Host gateway
+			    HostName 17-ffaa:0:1,[192.168.0.1]
+			Host 17-ffaa:1:* !17-ffaa:1:2,*
+			    Port 2200
+			Match user root host 17-ffaa:1:1,[10.0.0.1],*.example.org
+			    Port 2201
+			Match !localuser alice host *.example.org
+			    User bob
+			Host *
+			    Port 22
+		`
+
+		Convey("Host patterns are matched against host names", func() {
+			conf := &testConfig{}
+			So(parseString(conf, configString, &Context{Host: "gateway"}), ShouldBeNil)
+			So(conf.HostAddress, ShouldEqual, "17-ffaa:0:1,[192.168.0.1]")
+			So(conf.Port, ShouldEqual, "22")
+		})
+
+		Convey("Host patterns are matched against SCION addresses", func() {
+			conf := &testConfig{}
+			So(parseString(conf, configString, &Context{Host: "17-ffaa:1:1,[10.0.0.1]"}), ShouldBeNil)
+			So(conf.Port, ShouldEqual, "2200")
+
+			conf = &testConfig{}
+			So(parseString(conf, configString, &Context{Host: "17-ffaa:1:2,[10.0.0.1]"}), ShouldBeNil)
+			So(conf.Port, ShouldEqual, "22")
+		})
+
+		Convey("All criteria of a Match block must match", func() {
+			conf := &testConfig{}
+			So(parseString(conf, configString, &Context{Host: "a.example.org", User: "root", LocalUser: "carol"}), ShouldBeNil)
+			So(conf.Port, ShouldEqual, "2201")
+			So(conf.User, ShouldEqual, "bob")
+
+			conf = &testConfig{}
+			So(parseString(conf, configString, &Context{Host: "a.example.org", LocalUser: "alice"}), ShouldBeNil)
+			So(conf.User, ShouldEqual, "")
+		})
+
+		Convey("Without context, only blocks matching any host apply", func() {
+			conf := &testConfig{}
+			So(parseString(conf, "Host gateway\nPort 2200\nMatch all\nPort 22\n", nil), ShouldBeNil)
+			So(conf.Port, ShouldEqual, "22")
+		})
+
+		Convey("Without context, Match blocks depending on the connection are an error", func() {
+			conf := &testConfig{}
+			err := parseString(conf, configString, nil)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldStartWith, "test_config:6:")
+		})
+
+		Convey("Unknown options in blocks that don't match are still an error", func() {
+			conf := &testConfig{}
+			err := parseString(conf, "Host other\nFooBar yes\n", &Context{Host: "gateway"})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Unsupported Match criteria are an error", func() {
+			conf := &testConfig{}
+			So(parseString(conf, "Match exec true\n", &Context{}), ShouldNotBeNil)
+			So(parseString(conf, "Match address 10.0.0.1\n", &Context{}), ShouldNotBeNil)
+		})
+	})
+
+	Convey("Given config files with Include", t, func() {
+		dir, err := ioutil.TempDir("", "config_test")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		So(os.Mkdir(filepath.Join(dir, "config.d"), 0700), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "config.d", "a.conf"), []byte("User a\nListenAddress 10.0.0.1\n"), 0600), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "config.d", "b.conf"), []byte("User b\nListenAddress 10.0.0.2\n"), 0600), ShouldBeNil)
+		So(ioutil.WriteFile(filepath.Join(dir, "loop"), []byte("Include loop\n"), 0600), ShouldBeNil)
+
+		Convey("Included files are applied in place", func() {
+			conf := &testConfig{}
+			main := filepath.Join(dir, "config")
+			So(ioutil.WriteFile(main, []byte("Include config.d/*.conf\nUser c\n"), 0600), ShouldBeNil)
+			So(UpdateFromFile(conf, main), ShouldBeNil)
+			So(conf.User, ShouldEqual, "a")
+			So(conf.ListenAddress, ShouldResemble, []string{"10.0.0.1", "10.0.0.2"})
+		})
+
+		Convey("Include in a block that doesn't match is skipped", func() {
+			conf := &testConfig{}
+			main := filepath.Join(dir, "config")
+			So(ioutil.WriteFile(main, []byte("Host other\nInclude config.d/*.conf\n"), 0600), ShouldBeNil)
+			So(UpdateFromFileWithContext(conf, main, &Context{Host: "gateway"}), ShouldBeNil)
+			So(conf.User, ShouldEqual, "")
+		})
+
+		Convey("Recursive includes are an error", func() {
+			conf := &testConfig{}
+			So(UpdateFromFile(conf, filepath.Join(dir, "loop")), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestParseTime(t *testing.T) {
+	Convey("Times in the format of OpenSSH are parsed", t, func() {
+		for value, expected := range map[string]time.Duration{
+			"0":     0,
+			"600":   10 * time.Minute,
+			"10m":   10 * time.Minute,
+			"1h30m": 90 * time.Minute,
+			"1w2d":  9 * 24 * time.Hour,
+			"2M5S":  125 * time.Second,
+		} {
+			d, err := ParseTime(value)
+			So(err, ShouldBeNil)
+			So(d, ShouldEqual, expected)
+		}
+
+		for _, value := range []string{"", "m", "1x", "-1", "1.5h", "1h 30m"} {
+			_, err := ParseTime(value)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
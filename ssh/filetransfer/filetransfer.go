@@ -0,0 +1,248 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package filetransfer copies files and directories between the local host
+// and an SFTP server, as used by scion-scp and scion-sftp.
+package filetransfer
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/pkg/sftp"
+)
+
+// Options control how files are copied.
+type Options struct {
+	// Recursive allows copying directories with their contents
+	Recursive bool
+	// Resume continues partial transfers: files that already exist at the
+	// destination and are smaller than the source are appended to instead of
+	// being overwritten, files of the same size are skipped.
+	Resume bool
+	// Progress receives progress output if not nil
+	Progress io.Writer
+}
+
+// Upload copies the local file or directory src to dst on the server. If dst
+// is an existing directory, src is copied into it.
+func Upload(client *sftp.Client, src, dst string, opts *Options) error {
+	return copyPath(localFS{}, src, remoteFS{client}, dst, opts)
+}
+
+// Download copies the file or directory src on the server to the local dst.
+// If dst is an existing directory, src is copied into it.
+func Download(client *sftp.Client, src, dst string, opts *Options) error {
+	return copyPath(remoteFS{client}, src, localFS{}, dst, opts)
+}
+
+// file is implemented by both *os.File and *sftp.File.
+type file interface {
+	io.ReadWriteCloser
+	io.Seeker
+}
+
+// fileSystem abstracts the local and the remote side of a transfer.
+type fileSystem interface {
+	Stat(name string) (os.FileInfo, error)
+	ReadDir(name string) ([]os.FileInfo, error)
+	Mkdir(name string, mode os.FileMode) error
+	Open(name string) (file, error)
+	// OpenFile opens name for writing, creating it with mode if it does not exist.
+	OpenFile(name string, mode os.FileMode, truncate bool) (file, error)
+	Join(elem ...string) string
+	Base(name string) string
+}
+
+func copyPath(srcFS fileSystem, src string, dstFS fileSystem, dst string, opts *Options) error {
+	info, err := srcFS.Stat(src)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() && !opts.Recursive {
+		return fmt.Errorf("%s: is a directory (not copied without recursion)", src)
+	}
+	if dst == "" {
+		dst = "."
+	}
+	if dstInfo, err := dstFS.Stat(dst); err == nil && dstInfo.IsDir() {
+		dst = dstFS.Join(dst, srcFS.Base(src))
+	}
+	return copyEntry(srcFS, src, info, dstFS, dst, opts)
+}
+
+func copyEntry(srcFS fileSystem, src string, info os.FileInfo, dstFS fileSystem, dst string, opts *Options) error {
+	if !info.IsDir() {
+		return copyFile(srcFS, src, info, dstFS, dst, opts)
+	}
+
+	if err := dstFS.Mkdir(dst, info.Mode().Perm()); err != nil {
+		if dstInfo, statErr := dstFS.Stat(dst); statErr != nil || !dstInfo.IsDir() {
+			return err
+		}
+	}
+	entries, err := srcFS.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		err := copyEntry(srcFS, srcFS.Join(src, entry.Name()), entry, dstFS, dstFS.Join(dst, entry.Name()), opts)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyFile(srcFS fileSystem, src string, info os.FileInfo, dstFS fileSystem, dst string, opts *Options) error {
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("%s: not a regular file", src)
+	}
+
+	var offset int64
+	if opts.Resume {
+		if dstInfo, err := dstFS.Stat(dst); err == nil && dstInfo.Mode().IsRegular() && dstInfo.Size() <= info.Size() {
+			offset = dstInfo.Size()
+		}
+	}
+
+	r, err := srcFS.Open(src)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	w, err := dstFS.OpenFile(dst, info.Mode().Perm(), offset == 0)
+	if err != nil {
+		return err
+	}
+	if offset > 0 {
+		if _, err := r.Seek(offset, io.SeekStart); err != nil {
+			w.Close()
+			return err
+		}
+		if _, err := w.Seek(offset, io.SeekStart); err != nil {
+			w.Close()
+			return err
+		}
+	}
+
+	var reader io.Reader = r
+	var p *progress
+	if opts.Progress != nil {
+		p = newProgress(opts.Progress, srcFS.Base(src), info.Size(), offset)
+		reader = io.TeeReader(r, p)
+	}
+	_, err = io.Copy(w, reader)
+	if closeErr := w.Close(); err == nil {
+		err = closeErr
+	}
+	if p != nil {
+		p.finish()
+	}
+	return err
+}
+
+// localFS is the local file system.
+type localFS struct{}
+
+func (localFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (localFS) ReadDir(name string) ([]os.FileInfo, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return f.Readdir(-1)
+}
+
+func (localFS) Mkdir(name string, mode os.FileMode) error {
+	return os.Mkdir(name, mode)
+}
+
+func (localFS) Open(name string) (file, error) {
+	return os.Open(name)
+}
+
+func (localFS) OpenFile(name string, mode os.FileMode, truncate bool) (file, error) {
+	flags := os.O_WRONLY | os.O_CREATE
+	if truncate {
+		flags |= os.O_TRUNC
+	}
+	return os.OpenFile(name, flags, mode)
+}
+
+func (localFS) Join(elem ...string) string {
+	return filepath.Join(elem...)
+}
+
+func (localFS) Base(name string) string {
+	return filepath.Base(name)
+}
+
+// remoteFS is the file system of an SFTP server.
+type remoteFS struct {
+	client *sftp.Client
+}
+
+func (fs remoteFS) Stat(name string) (os.FileInfo, error) {
+	return fs.client.Stat(name)
+}
+
+func (fs remoteFS) ReadDir(name string) ([]os.FileInfo, error) {
+	return fs.client.ReadDir(name)
+}
+
+func (fs remoteFS) Mkdir(name string, mode os.FileMode) error {
+	if err := fs.client.Mkdir(name); err != nil {
+		return err
+	}
+	return fs.client.Chmod(name, mode)
+}
+
+func (fs remoteFS) Open(name string) (file, error) {
+	return fs.client.Open(name)
+}
+
+func (fs remoteFS) OpenFile(name string, mode os.FileMode, truncate bool) (file, error) {
+	_, statErr := fs.client.Stat(name)
+	flags := os.O_WRONLY | os.O_CREATE
+	if truncate {
+		flags |= os.O_TRUNC
+	}
+	f, err := fs.client.OpenFile(name, flags)
+	if err != nil {
+		return nil, err
+	}
+	if os.IsNotExist(statErr) {
+		if err := f.Chmod(mode); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (remoteFS) Join(elem ...string) string {
+	return path.Join(elem...)
+}
+
+func (remoteFS) Base(name string) string {
+	return path.Base(name)
+}
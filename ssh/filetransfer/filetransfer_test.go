@@ -0,0 +1,135 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filetransfer
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// newTestClient returns an SFTP client connected to an in-process server,
+// serving the local file system.
+func newTestClient() (*sftp.Client, func()) {
+	clientReader, serverWriter := io.Pipe()
+	serverReader, clientWriter := io.Pipe()
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{serverReader, serverWriter})
+	So(err, ShouldBeNil)
+	go server.Serve()
+	client, err := sftp.NewClientPipe(clientReader, clientWriter)
+	So(err, ShouldBeNil)
+	return client, func() {
+		server.Close()
+		client.Close()
+	}
+}
+
+func writeFile(name, content string) {
+	So(os.MkdirAll(filepath.Dir(name), 0755), ShouldBeNil)
+	So(ioutil.WriteFile(name, []byte(content), 0640), ShouldBeNil)
+}
+
+func readFile(name string) string {
+	content, err := ioutil.ReadFile(name)
+	So(err, ShouldBeNil)
+	return string(content)
+}
+
+func TestTransfer(t *testing.T) {
+	Convey("Given an SFTP client and a source directory", t, func() {
+		client, closeClient := newTestClient()
+		defer closeClient()
+
+		dir, err := ioutil.TempDir("", "filetransfer")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		src := filepath.Join(dir, "src")
+		writeFile(filepath.Join(src, "a.txt"), "hello")
+		writeFile(filepath.Join(src, "sub", "b.txt"), strings.Repeat("scion", 1000))
+		dst := filepath.Join(dir, "dst")
+		So(os.Mkdir(dst, 0755), ShouldBeNil)
+
+		Convey("A single file is uploaded into an existing directory", func() {
+			err := Upload(client, filepath.Join(src, "a.txt"), dst, &Options{})
+			So(err, ShouldBeNil)
+			So(readFile(filepath.Join(dst, "a.txt")), ShouldEqual, "hello")
+			info, err := os.Stat(filepath.Join(dst, "a.txt"))
+			So(err, ShouldBeNil)
+			So(info.Mode().Perm(), ShouldEqual, os.FileMode(0640))
+		})
+
+		Convey("A single file is downloaded to a new name", func() {
+			err := Download(client, filepath.Join(src, "a.txt"), filepath.Join(dst, "c.txt"), &Options{})
+			So(err, ShouldBeNil)
+			So(readFile(filepath.Join(dst, "c.txt")), ShouldEqual, "hello")
+		})
+
+		Convey("Directories are only copied recursively", func() {
+			err := Download(client, src, dst, &Options{})
+			So(err, ShouldNotBeNil)
+
+			err = Download(client, src, dst, &Options{Recursive: true})
+			So(err, ShouldBeNil)
+			So(readFile(filepath.Join(dst, "src", "a.txt")), ShouldEqual, "hello")
+			So(readFile(filepath.Join(dst, "src", "sub", "b.txt")), ShouldEqual, strings.Repeat("scion", 1000))
+
+			err = Upload(client, src, filepath.Join(dst, "up"), &Options{Recursive: true})
+			So(err, ShouldBeNil)
+			So(readFile(filepath.Join(dst, "up", "sub", "b.txt")), ShouldEqual, strings.Repeat("scion", 1000))
+		})
+
+		Convey("Partial transfers are resumed", func() {
+			partial := filepath.Join(dst, "b.txt")
+			writeFile(partial, "scionsc")
+			err := Download(client, filepath.Join(src, "sub", "b.txt"), partial, &Options{Resume: true})
+			So(err, ShouldBeNil)
+			So(readFile(partial), ShouldEqual, strings.Repeat("scion", 1000))
+
+			// Without resume, the file is overwritten
+			writeFile(partial, "xxx")
+			err = Upload(client, filepath.Join(src, "a.txt"), partial, &Options{})
+			So(err, ShouldBeNil)
+			So(readFile(partial), ShouldEqual, "hello")
+		})
+
+		Convey("Progress is reported", func() {
+			var progress bytes.Buffer
+			err := Upload(client, filepath.Join(src, "a.txt"), dst, &Options{Progress: &progress})
+			So(err, ShouldBeNil)
+			So(progress.String(), ShouldContainSubstring, "a.txt")
+			So(progress.String(), ShouldContainSubstring, "100%")
+			So(progress.String(), ShouldEndWith, "\n")
+		})
+	})
+}
+
+func TestFormatBytes(t *testing.T) {
+	Convey("Byte counts are formatted with binary prefixes", t, func() {
+		So(formatBytes(0), ShouldEqual, "0B")
+		So(formatBytes(1023), ShouldEqual, "1023B")
+		So(formatBytes(1024), ShouldEqual, "1.0KiB")
+		So(formatBytes(1536*1024), ShouldEqual, "1.5MiB")
+	})
+}
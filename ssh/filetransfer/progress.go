@@ -0,0 +1,90 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package filetransfer
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+const progressInterval = 200 * time.Millisecond
+
+// progress prints the progress of a single file transfer on one line, like
+// scp does.
+type progress struct {
+	w     io.Writer
+	name  string
+	total int64
+	done  int64
+
+	start     time.Time
+	resumed   int64
+	lastPrint time.Time
+}
+
+func newProgress(w io.Writer, name string, total, done int64) *progress {
+	p := &progress{
+		w:       w,
+		name:    name,
+		total:   total,
+		done:    done,
+		start:   time.Now(),
+		resumed: done,
+	}
+	p.print()
+	return p
+}
+
+// Write counts the transferred bytes.
+func (p *progress) Write(b []byte) (int, error) {
+	p.done += int64(len(b))
+	if time.Since(p.lastPrint) >= progressInterval {
+		p.print()
+	}
+	return len(b), nil
+}
+
+func (p *progress) finish() {
+	p.print()
+	fmt.Fprintln(p.w)
+}
+
+func (p *progress) print() {
+	p.lastPrint = time.Now()
+	percent := int64(100)
+	if p.total > 0 {
+		percent = p.done * 100 / p.total
+	}
+	var rate float64
+	if elapsed := time.Since(p.start).Seconds(); elapsed > 0 {
+		rate = float64(p.done-p.resumed) / elapsed
+	}
+	fmt.Fprintf(p.w, "\r%-40s %3d%% %9s %9s/s", p.name, percent, formatBytes(float64(p.done)), formatBytes(rate))
+}
+
+// formatBytes formats a number of bytes with a binary unit prefix.
+func formatBytes(n float64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%.0fB", n)
+	}
+	i := -1
+	for n >= 1024 && i < len(units)-1 {
+		n /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f%ciB", n, units[i])
+}
@@ -15,6 +15,7 @@
 package quicconn
 
 import (
+	"context"
 	"crypto/tls"
 	"net"
 	"time"
@@ -44,13 +45,57 @@ func New(conn net.PacketConn, raddr net.Addr) (*QuicConn, error) {
 }
 
 func newQuicConn(session quic.Session) (*QuicConn, error) {
-	stream, err := session.OpenStreamSync()
+	stream, err := session.OpenStreamSync(context.Background())
 	if err != nil {
 		return nil, err
 	}
 	return &QuicConn{Session: session, Stream: stream}, nil
 }
 
+// Listen listens for QUIC sessions on the given port and returns a Listener
+// accepting the first stream of each session as a QuicConn
+func Listen(port uint16) (*Listener, error) {
+	listener, err := appquic.ListenPort(port, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Listener{listener: listener}, nil
+}
+
+var _ net.Listener = (*Listener)(nil)
+
+// Listener is a struct wrapping a QUIC listener into a net.Listener.
+type Listener struct {
+	listener quic.Listener
+}
+
+// Accept waits for the next session and returns its first stream as a
+// QuicConn. Sessions failing to open a stream are closed and skipped.
+func (l *Listener) Accept() (net.Conn, error) {
+	for {
+		session, err := l.listener.Accept(context.Background())
+		if err != nil {
+			return nil, err
+		}
+		stream, err := session.AcceptStream(context.Background())
+		if err != nil {
+			_ = session.CloseWithError(0, "")
+			continue
+		}
+		return &QuicConn{Session: session, Stream: stream}, nil
+	}
+}
+
+// Close closes the listener.
+func (l *Listener) Close() error {
+	return l.listener.Close()
+}
+
+// Addr returns the listener's network address.
+func (l *Listener) Addr() net.Addr {
+	return l.listener.Addr()
+}
+
 var _ net.Conn = (*QuicConn)(nil)
 
 // QuicConn is a struct wrapping a single QUIC stream into a net.Conn connection.
@@ -0,0 +1,249 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// scion-scp copies files to and from a SCION ssh server, using SFTP.
+package main
+
+import (
+	"fmt"
+	golog "log"
+	"os"
+	"os/user"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh/terminal"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/netsec-ethz/scion-apps/ssh/client/clientconfig"
+	"github.com/netsec-ethz/scion-apps/ssh/client/ssh"
+	"github.com/netsec-ethz/scion-apps/ssh/config"
+	"github.com/netsec-ethz/scion-apps/ssh/filetransfer"
+	"github.com/netsec-ethz/scion-apps/ssh/scionutils"
+	"github.com/netsec-ethz/scion-apps/ssh/utils"
+)
+
+var (
+	files         = kingpin.Arg("files", "Source files followed by the target. Remote files are given as [user@]host:path, SCION hosts may be enclosed in square brackets").Required().Strings()
+	port          = kingpin.Flag("port", "The server's port").Default("0").Short('P').Uint16()
+	recursive     = kingpin.Flag("recursive", "Recursively copy entire directories").Short('r').Bool()
+	resume        = kingpin.Flag("resume", "Resume partial transfers").Short('a').Bool()
+	quiet         = kingpin.Flag("quiet", "Disable the progress output").Short('q').Bool()
+	options       = kingpin.Flag("option", "Set an option").Short('o').Strings()
+	configFiles   = kingpin.Flag("config", "Configuration files").Short('c').Default("/etc/ssh/ssh_config", "~/.ssh/config").Strings()
+	jumpHosts     = kingpin.Flag("jump-host", "Connect over the comma-separated jump hosts, each given as [user@]host[:port]").Short('J').String()
+	identityFile  = kingpin.Flag("identity", "Identity (private key) file").Short('i').ExistingFile()
+	pathSelection = kingpin.Flag("selection", "Path selection mode").Default("arbitrary").Enum("static", "arbitrary", "random", "round-robin")
+)
+
+// fileArg is a source or target argument, either a local or a remote path.
+type fileArg struct {
+	User string
+	Host string
+	Path string
+}
+
+func (f fileArg) isRemote() bool {
+	return f.Host != ""
+}
+
+var scionHostRegex = regexp.MustCompile(`^\d+-[\d:A-Fa-f]+,\[[^\]]+\]`)
+
+// parseFileArg parses an argument of the form [user@]host:path. The host may
+// be enclosed in square brackets, which is necessary for IPv6 addresses and
+// allowed for SCION addresses. Arguments without a colon before the first
+// slash are local paths.
+func parseFileArg(arg string) fileArg {
+	rest := arg
+	var usr string
+	if at := strings.Index(rest, "@"); at > 0 && !strings.ContainsAny(rest[:at], "/:[") {
+		usr, rest = rest[:at], rest[at+1:]
+	}
+
+	var host string
+	switch {
+	case strings.HasPrefix(rest, "["):
+		depth := 0
+		for i, c := range rest {
+			if c == '[' {
+				depth++
+			} else if c == ']' {
+				depth--
+			}
+			if depth == 0 {
+				host, rest = rest[1:i], rest[i+1:]
+				break
+			}
+		}
+	case scionHostRegex.MatchString(rest):
+		host = scionHostRegex.FindString(rest)
+		rest = rest[len(host):]
+	default:
+		colon := strings.Index(rest, ":")
+		if colon <= 0 || strings.Contains(rest[:colon], "/") {
+			return fileArg{Path: arg}
+		}
+		host, rest = rest[:colon], rest[colon:]
+	}
+	if host == "" || !strings.HasPrefix(rest, ":") {
+		return fileArg{Path: arg}
+	}
+	return fileArg{User: usr, Host: host, Path: rest[1:]}
+}
+
+func createConfig(username, host, localUser string) *clientconfig.ClientConfig {
+	conf := clientconfig.Create()
+
+	ctx := &config.Context{Host: host, User: username, LocalUser: localUser}
+	for _, configFile := range *configFiles {
+		err := config.UpdateFromFileWithContext(conf, utils.ParsePath(configFile), ctx)
+		if err != nil && !os.IsNotExist(err) {
+			golog.Fatalf("Error updating config from file %s: %v", configFile, err)
+		}
+	}
+	for _, option := range *options {
+		err := config.UpdateFromString(conf, option)
+		if err != nil {
+			golog.Fatalf("Error updating config from --option flag: %v", err)
+		}
+	}
+
+	if _, err := config.SetIfNot(conf, "Port", *port, 0); err != nil {
+		golog.Fatalf("Invalid port: %v", err)
+	}
+	// The host address may be set by HostName in a Host block
+	if conf.HostAddress == "" {
+		if _, err := config.SetIfNot(conf, "HostAddress", host, ""); err != nil {
+			golog.Fatalf("Invalid host: %v", err)
+		}
+	}
+	if *jumpHosts != "" {
+		conf.ProxyCommand = ""
+		if _, err := config.SetIfNot(conf, "ProxyJump", *jumpHosts, ""); err != nil {
+			golog.Fatalf("Invalid jump host: %v", err)
+		}
+	}
+	if *identityFile != "" {
+		conf.IdentityFile = append([]string{*identityFile}, conf.IdentityFile...)
+	}
+	return conf
+}
+
+func connect(remote fileArg) (*sftp.Client, error) {
+	localUser, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	conf := createConfig(remote.User, remote.Host, localUser.Username)
+
+	username := remote.User
+	if username == "" {
+		username = conf.User
+	}
+	if username == "" {
+		username = localUser.Username
+	}
+
+	appConf, err := scionutils.NewPathAppConf(nil, *pathSelection)
+	if err != nil {
+		return nil, err
+	}
+	client, err := ssh.Create(username, conf, ssh.PromptPassword, ssh.PromptKeyboardInteractive, ssh.PromptPassphrase, ssh.PromptAcceptHostKey, appConf)
+	if err != nil {
+		return nil, err
+	}
+	addr := fmt.Sprintf("%s:%v", conf.HostAddress, conf.Port)
+	// Started in the background by another client, to share the connection
+	if ssh.IsControlMaster() {
+		if err := client.RunControlMaster(addr); err != nil {
+			golog.Fatalf("Error running control master: %v", err)
+		}
+		os.Exit(0)
+	}
+	err = client.Connect(addr)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewSFTPClient()
+}
+
+func main() {
+	kingpin.Parse()
+
+	if len(*files) < 2 {
+		kingpin.Fatalf("expected at least one source and a target")
+	}
+	var sources []fileArg
+	for _, arg := range (*files)[:len(*files)-1] {
+		sources = append(sources, parseFileArg(arg))
+	}
+	target := parseFileArg((*files)[len(*files)-1])
+
+	// All sources must be on the same host, on the other side than the target
+	remote := target
+	if !target.isRemote() {
+		remote = sources[0]
+	}
+	for _, source := range sources {
+		if source.isRemote() == target.isRemote() {
+			kingpin.Fatalf("copying between two local or two remote files is not supported")
+		}
+		if source.isRemote() && (source.Host != remote.Host || source.User != remote.User) {
+			kingpin.Fatalf("all remote sources must be on the same host")
+		}
+	}
+
+	client, err := connect(remote)
+	if err != nil {
+		golog.Fatalf("Error connecting: %v", err)
+	}
+	defer client.Close()
+
+	opts := &filetransfer.Options{
+		Recursive: *recursive,
+		Resume:    *resume,
+	}
+	if !*quiet && terminal.IsTerminal(int(os.Stdout.Fd())) {
+		opts.Progress = os.Stdout
+	}
+
+	if len(sources) > 1 {
+		var info os.FileInfo
+		if target.isRemote() {
+			info, err = client.Stat(target.Path)
+		} else {
+			info, err = os.Stat(target.Path)
+		}
+		if err != nil || !info.IsDir() {
+			golog.Fatalf("Target %s is not a directory", target.Path)
+		}
+	}
+
+	failed := false
+	for _, source := range sources {
+		if target.isRemote() {
+			err = filetransfer.Upload(client, source.Path, target.Path, opts)
+		} else {
+			err = filetransfer.Download(client, source.Path, target.Path, opts)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "scion-scp: %v\n", err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestParseFileArg(t *testing.T) {
+	Convey("File arguments are parsed", t, func() {
+		testCases := []struct {
+			Arg      string
+			Expected fileArg
+		}{
+			{"file.txt", fileArg{Path: "file.txt"}},
+			{"./dir/file:1.txt", fileArg{Path: "./dir/file:1.txt"}},
+			{"/tmp/a:b", fileArg{Path: "/tmp/a:b"}},
+			{"host:file.txt", fileArg{Host: "host", Path: "file.txt"}},
+			{"user@host:", fileArg{User: "user", Host: "host", Path: ""}},
+			{"[1-ffaa:1:abc,[127.0.0.1]]:remote.txt", fileArg{Host: "1-ffaa:1:abc,[127.0.0.1]", Path: "remote.txt"}},
+			{"user@[1-ffaa:1:abc,[::1]]:/tmp/x", fileArg{User: "user", Host: "1-ffaa:1:abc,[::1]", Path: "/tmp/x"}},
+			{"1-ffaa:1:abc,[127.0.0.1]:remote.txt", fileArg{Host: "1-ffaa:1:abc,[127.0.0.1]", Path: "remote.txt"}},
+			{"[::1]:dir/", fileArg{Host: "::1", Path: "dir/"}},
+			{"[unbalanced:file", fileArg{Path: "[unbalanced:file"}},
+		}
+		for _, tc := range testCases {
+			So(parseFileArg(tc.Arg), ShouldResemble, tc.Expected)
+		}
+	})
+}
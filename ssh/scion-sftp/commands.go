@@ -0,0 +1,235 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+
+	"github.com/netsec-ethz/scion-apps/ssh/filetransfer"
+)
+
+type command struct {
+	usage string
+	help  string
+	run   func(s *shell, args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"cd":     {"cd path", "Change remote directory to path", (*shell).cd},
+		"chmod":  {"chmod mode path", "Change permissions of remote file path to mode", (*shell).chmod},
+		"get":    {"get [-r] [-a] remote [local]", "Download file; -r for directories, -a to resume", (*shell).get},
+		"help":   {"help", "Display this help text", (*shell).help},
+		"lcd":    {"lcd path", "Change local directory to path", (*shell).lcd},
+		"lpwd":   {"lpwd", "Print local working directory", (*shell).lpwd},
+		"ls":     {"ls [path]", "Display remote directory listing", (*shell).ls},
+		"mkdir":  {"mkdir path", "Create remote directory", (*shell).mkdir},
+		"put":    {"put [-r] [-a] local [remote]", "Upload file; -r for directories, -a to resume", (*shell).put},
+		"pwd":    {"pwd", "Print remote working directory", (*shell).pwd},
+		"reget":  {"reget [-r] remote [local]", "Resume download of file", (*shell).reget},
+		"rename": {"rename oldpath newpath", "Rename remote file", (*shell).rename},
+		"reput":  {"reput [-r] local [remote]", "Resume upload of file", (*shell).reput},
+		"rm":     {"rm path", "Delete remote file", (*shell).rm},
+		"rmdir":  {"rmdir path", "Remove remote directory", (*shell).rmdir},
+	}
+}
+
+func (s *shell) execute(name string, args []string) error {
+	cmd, exists := commands[name]
+	if !exists {
+		return errors.New("invalid command, see help")
+	}
+	return cmd.run(s, args)
+}
+
+// remotePath resolves p relative to the remote working directory.
+func (s *shell) remotePath(p string) string {
+	if path.IsAbs(p) {
+		return p
+	}
+	return path.Join(s.cwd, p)
+}
+
+// parseTransferArgs parses the arguments of get and put.
+func parseTransferArgs(args []string, resume bool) (*filetransfer.Options, string, string, error) {
+	opts := &filetransfer.Options{Resume: resume}
+	var paths []string
+	for _, arg := range args {
+		switch arg {
+		case "-r":
+			opts.Recursive = true
+		case "-a":
+			opts.Resume = true
+		default:
+			paths = append(paths, arg)
+		}
+	}
+	switch len(paths) {
+	case 1:
+		return opts, paths[0], "", nil
+	case 2:
+		return opts, paths[0], paths[1], nil
+	default:
+		return nil, "", "", errors.New("expected a source and an optional target")
+	}
+}
+
+func (s *shell) transfer(args []string, resume, upload bool) error {
+	opts, src, dst, err := parseTransferArgs(args, resume)
+	if err != nil {
+		return err
+	}
+	opts.Progress = s.progress
+	// Without target, files are copied into the working directory
+	if upload {
+		return filetransfer.Upload(s.client, src, s.remotePath(dst), opts)
+	}
+	if dst == "" {
+		dst = "."
+	}
+	return filetransfer.Download(s.client, s.remotePath(src), dst, opts)
+}
+
+func (s *shell) get(args []string) error {
+	return s.transfer(args, false, false)
+}
+
+func (s *shell) reget(args []string) error {
+	return s.transfer(args, true, false)
+}
+
+func (s *shell) put(args []string) error {
+	return s.transfer(args, false, true)
+}
+
+func (s *shell) reput(args []string) error {
+	return s.transfer(args, true, true)
+}
+
+func (s *shell) cd(args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected a path")
+	}
+	dir := s.remotePath(args[0])
+	info, err := s.client.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s: not a directory", dir)
+	}
+	s.cwd = dir
+	return nil
+}
+
+func (s *shell) pwd(args []string) error {
+	fmt.Fprintf(s.out, "Remote working directory: %s\n", s.cwd)
+	return nil
+}
+
+func (s *shell) lcd(args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected a path")
+	}
+	return os.Chdir(args[0])
+}
+
+func (s *shell) lpwd(args []string) error {
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(s.out, "Local working directory: %s\n", dir)
+	return nil
+}
+
+func (s *shell) ls(args []string) error {
+	dir := s.cwd
+	if len(args) > 0 {
+		dir = s.remotePath(args[0])
+	}
+	entries, err := s.client.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() {
+			name += "/"
+		}
+		fmt.Fprintf(s.out, "%s %10d %s %s\n", entry.Mode(), entry.Size(), entry.ModTime().Format("Jan _2 15:04"), name)
+	}
+	return nil
+}
+
+func (s *shell) mkdir(args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected a path")
+	}
+	return s.client.Mkdir(s.remotePath(args[0]))
+}
+
+func (s *shell) rmdir(args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected a path")
+	}
+	return s.client.RemoveDirectory(s.remotePath(args[0]))
+}
+
+func (s *shell) rm(args []string) error {
+	if len(args) != 1 {
+		return errors.New("expected a path")
+	}
+	return s.client.Remove(s.remotePath(args[0]))
+}
+
+func (s *shell) rename(args []string) error {
+	if len(args) != 2 {
+		return errors.New("expected old and new path")
+	}
+	return s.client.Rename(s.remotePath(args[0]), s.remotePath(args[1]))
+}
+
+func (s *shell) chmod(args []string) error {
+	if len(args) != 2 {
+		return errors.New("expected mode and path")
+	}
+	mode, err := strconv.ParseUint(args[0], 8, 32)
+	if err != nil {
+		return fmt.Errorf("invalid mode %s", args[0])
+	}
+	return s.client.Chmod(s.remotePath(args[1]), os.FileMode(mode))
+}
+
+func (s *shell) help(args []string) error {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(s.out, "%-30s %s\n", commands[name].usage, commands[name].help)
+	}
+	fmt.Fprintf(s.out, "%-30s %s\n", "exit", "Quit sftp")
+	return nil
+}
@@ -0,0 +1,97 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestShell(t *testing.T) {
+	Convey("Given a shell connected to an in-process SFTP server", t, func() {
+		clientReader, serverWriter := io.Pipe()
+		serverReader, clientWriter := io.Pipe()
+		server, err := sftp.NewServer(struct {
+			io.Reader
+			io.WriteCloser
+		}{serverReader, serverWriter})
+		So(err, ShouldBeNil)
+		go server.Serve()
+		client, err := sftp.NewClientPipe(clientReader, clientWriter)
+		So(err, ShouldBeNil)
+		defer client.Close()
+		defer server.Close()
+
+		dir, err := ioutil.TempDir("", "scion-sftp")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		local := filepath.Join(dir, "local.txt")
+		So(ioutil.WriteFile(local, []byte("hello"), 0644), ShouldBeNil)
+
+		var out bytes.Buffer
+		s, err := newShell(client, &out)
+		So(err, ShouldBeNil)
+
+		Convey("A batch of commands is executed", func() {
+			script := strings.Join([]string{
+				"# comment",
+				"cd " + dir,
+				"mkdir remote",
+				"put " + local + " remote/copy.txt",
+				"cd remote",
+				"pwd",
+				"ls",
+				"rename copy.txt renamed.txt",
+				"get renamed.txt " + filepath.Join(dir, "back.txt"),
+				"rm renamed.txt",
+				"cd ..",
+				"rmdir remote",
+			}, "\n")
+			So(s.run(strings.NewReader(script), false), ShouldBeTrue)
+			So(out.String(), ShouldContainSubstring, "Remote working directory: "+filepath.Join(dir, "remote"))
+			So(out.String(), ShouldContainSubstring, "copy.txt")
+			content, err := ioutil.ReadFile(filepath.Join(dir, "back.txt"))
+			So(err, ShouldBeNil)
+			So(string(content), ShouldEqual, "hello")
+			_, err = os.Stat(filepath.Join(dir, "remote"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("A batch stops at the first failing command", func() {
+			script := "cd " + filepath.Join(dir, "missing") + "\nmkdir " + filepath.Join(dir, "created")
+			So(s.run(strings.NewReader(script), false), ShouldBeFalse)
+			_, err := os.Stat(filepath.Join(dir, "created"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Convey("Unknown commands fail", func() {
+			So(s.execute("frobnicate", nil), ShouldNotBeNil)
+		})
+
+		Convey("Exit ends the session", func() {
+			So(s.run(strings.NewReader("exit\nmkdir "+filepath.Join(dir, "created")), false), ShouldBeTrue)
+			_, err := os.Stat(filepath.Join(dir, "created"))
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+	})
+}
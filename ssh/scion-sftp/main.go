@@ -0,0 +1,211 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// scion-sftp is an interactive SFTP client for SCION ssh servers.
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	golog "log"
+	"os"
+	"os/user"
+	"strings"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh/terminal"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/netsec-ethz/scion-apps/ssh/client/clientconfig"
+	"github.com/netsec-ethz/scion-apps/ssh/client/ssh"
+	"github.com/netsec-ethz/scion-apps/ssh/config"
+	"github.com/netsec-ethz/scion-apps/ssh/scionutils"
+	"github.com/netsec-ethz/scion-apps/ssh/utils"
+)
+
+var (
+	destination   = kingpin.Arg("destination", "Server SCION address (without the port), optionally preceded by user@").Required().String()
+	port          = kingpin.Flag("port", "The server's port").Default("0").Short('P').Uint16()
+	batchFile     = kingpin.Flag("batchfile", "Read commands from file instead of stdin").Short('b').ExistingFile()
+	options       = kingpin.Flag("option", "Set an option").Short('o').Strings()
+	configFiles   = kingpin.Flag("config", "Configuration files").Short('c').Default("/etc/ssh/ssh_config", "~/.ssh/config").Strings()
+	jumpHosts     = kingpin.Flag("jump-host", "Connect over the comma-separated jump hosts, each given as [user@]host[:port]").Short('J').String()
+	identityFile  = kingpin.Flag("identity", "Identity (private key) file").Short('i').ExistingFile()
+	pathSelection = kingpin.Flag("selection", "Path selection mode").Default("arbitrary").Enum("static", "arbitrary", "random", "round-robin")
+)
+
+func createConfig(username, host, localUser string) *clientconfig.ClientConfig {
+	conf := clientconfig.Create()
+
+	ctx := &config.Context{Host: host, User: username, LocalUser: localUser}
+	for _, configFile := range *configFiles {
+		err := config.UpdateFromFileWithContext(conf, utils.ParsePath(configFile), ctx)
+		if err != nil && !os.IsNotExist(err) {
+			golog.Fatalf("Error updating config from file %s: %v", configFile, err)
+		}
+	}
+	for _, option := range *options {
+		err := config.UpdateFromString(conf, option)
+		if err != nil {
+			golog.Fatalf("Error updating config from --option flag: %v", err)
+		}
+	}
+
+	if _, err := config.SetIfNot(conf, "Port", *port, 0); err != nil {
+		golog.Fatalf("Invalid port: %v", err)
+	}
+	// The host address may be set by HostName in a Host block
+	if conf.HostAddress == "" {
+		if _, err := config.SetIfNot(conf, "HostAddress", host, ""); err != nil {
+			golog.Fatalf("Invalid host: %v", err)
+		}
+	}
+	if *jumpHosts != "" {
+		conf.ProxyCommand = ""
+		if _, err := config.SetIfNot(conf, "ProxyJump", *jumpHosts, ""); err != nil {
+			golog.Fatalf("Invalid jump host: %v", err)
+		}
+	}
+	if *identityFile != "" {
+		conf.IdentityFile = append([]string{*identityFile}, conf.IdentityFile...)
+	}
+	return conf
+}
+
+func connect(username, host string) (*sftp.Client, error) {
+	localUser, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	conf := createConfig(username, host, localUser.Username)
+
+	if username == "" {
+		username = conf.User
+	}
+	if username == "" {
+		username = localUser.Username
+	}
+
+	appConf, err := scionutils.NewPathAppConf(nil, *pathSelection)
+	if err != nil {
+		return nil, err
+	}
+	client, err := ssh.Create(username, conf, ssh.PromptPassword, ssh.PromptKeyboardInteractive, ssh.PromptPassphrase, ssh.PromptAcceptHostKey, appConf)
+	if err != nil {
+		return nil, err
+	}
+	addr := fmt.Sprintf("%s:%v", conf.HostAddress, conf.Port)
+	// Started in the background by another client, to share the connection
+	if ssh.IsControlMaster() {
+		if err := client.RunControlMaster(addr); err != nil {
+			golog.Fatalf("Error running control master: %v", err)
+		}
+		os.Exit(0)
+	}
+	err = client.Connect(addr)
+	if err != nil {
+		return nil, err
+	}
+	return client.NewSFTPClient()
+}
+
+func main() {
+	kingpin.Parse()
+
+	username, host := "", *destination
+	if at := strings.Index(host, "@"); at >= 0 {
+		username, host = host[:at], host[at+1:]
+	}
+
+	client, err := connect(username, host)
+	if err != nil {
+		golog.Fatalf("Error connecting: %v", err)
+	}
+	defer client.Close()
+
+	input := os.Stdin
+	interactive := terminal.IsTerminal(int(os.Stdin.Fd()))
+	if *batchFile != "" {
+		input, err = os.Open(*batchFile)
+		if err != nil {
+			golog.Fatalf("Error opening batch file: %v", err)
+		}
+		defer input.Close()
+		interactive = false
+	}
+
+	s, err := newShell(client, os.Stdout)
+	if err != nil {
+		golog.Fatalf("Error starting SFTP session: %v", err)
+	}
+	if interactive {
+		s.progress = os.Stdout
+	}
+	if !s.run(input, interactive) {
+		os.Exit(1)
+	}
+}
+
+// shell runs the commands of an SFTP session.
+type shell struct {
+	client   *sftp.Client
+	out      io.Writer
+	progress io.Writer
+	cwd      string
+}
+
+func newShell(client *sftp.Client, out io.Writer) (*shell, error) {
+	cwd, err := client.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	return &shell{client: client, out: out, cwd: cwd}, nil
+}
+
+// run reads and executes commands until the input ends or the user quits.
+// In batch mode, it stops at the first failing command. It returns false if
+// a command failed.
+func (s *shell) run(input io.Reader, interactive bool) bool {
+	ok := true
+	scanner := bufio.NewScanner(input)
+	for {
+		if interactive {
+			fmt.Fprint(s.out, "sftp> ")
+		}
+		if !scanner.Scan() {
+			if interactive {
+				fmt.Fprintln(s.out)
+			}
+			return ok
+		}
+		args := strings.Fields(scanner.Text())
+		if len(args) == 0 || strings.HasPrefix(args[0], "#") {
+			continue
+		}
+		if !interactive {
+			fmt.Fprintf(s.out, "sftp> %s\n", scanner.Text())
+		}
+		if args[0] == "exit" || args[0] == "quit" || args[0] == "bye" {
+			return ok
+		}
+		if err := s.execute(args[0], args[1:]); err != nil {
+			fmt.Fprintf(s.out, "%s: %v\n", args[0], err)
+			ok = false
+			if !interactive {
+				return false
+			}
+		}
+	}
+}
@@ -15,10 +15,16 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	golog "log"
+	"net"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/lucas-clemente/quic-go"
 	"gopkg.in/alecthomas/kingpin.v2"
 
 	"github.com/netsec-ethz/scion-apps/pkg/appnet/appquic"
@@ -33,6 +39,8 @@ import (
 
 const (
 	version = "1.0"
+	// streamTimeout is how long to wait for the stream of a new session
+	streamTimeout = 30 * time.Second
 )
 
 var (
@@ -51,13 +59,10 @@ func createConfig() *serverconfig.ServerConfig {
 	for _, option := range *options {
 		err := config.UpdateFromString(conf, option)
 		if err != nil {
-			log.Debug("Error updating config from --option flag: %v", err)
+			golog.Panicf("Error updating config from --option flag: %v", err)
 		}
 	}
 
-	// TODO: Set port from listening address
-	// setConfIfNot(conf, "Port", *PORT, 0)
-
 	return conf
 }
 
@@ -71,6 +76,14 @@ func updateConfigFromFile(conf *serverconfig.ServerConfig, pth string) {
 }
 
 func main() {
+	// The server runs itself with this single argument for the sftp subsystem
+	if len(os.Args) == 2 && os.Args[1] == ssh.SFTPServerArg {
+		if err := ssh.ServeSFTP(); err != nil {
+			golog.Fatalf("SFTP server failed: %v", err)
+		}
+		return
+	}
+
 	kingpin.Parse()
 	log.Debug("Starting SCION SSH server...")
 
@@ -81,32 +94,95 @@ func main() {
 		golog.Panicf("Error creating ssh server: %v", err)
 	}
 
-	port, err := strconv.Atoi(conf.Port)
+	addresses, err := listenAddresses(conf)
 	if err != nil {
-		golog.Panicf("Can't parse port %v: %v", conf.Port, err)
+		golog.Panicf("Invalid ListenAddress: %v", err)
+	}
+	var listeners []quic.Listener
+	for _, address := range addresses {
+		listener, err := appquic.Listen(address, nil, nil)
+		if err != nil {
+			golog.Panicf("Failed to listen on %v (%v)", address, err)
+		}
+		listeners = append(listeners, listener)
+	}
+
+	log.Debug("Starting to wait for connections")
+	for _, listener := range listeners[1:] {
+		go acceptConnections(listener, sshServer)
 	}
+	acceptConnections(listeners[0], sshServer)
+}
 
-	log.Debug("Currently, ListenAddress.Port is ignored (only value from config taken)")
-	listener, err := appquic.ListenPort(uint16(port), nil, nil)
+// listenAddresses returns the addresses of the ListenAddress options, which
+// are either host, host:port or [host]:port. Without port, Port is used. An
+// empty host or "*", as well as no ListenAddress option, listens on the
+// default local address.
+func listenAddresses(conf *serverconfig.ServerConfig) ([]*net.UDPAddr, error) {
+	defaultPort, err := strconv.Atoi(conf.Port)
 	if err != nil {
-		golog.Panicf("Failed to listen (%v)", err)
+		return nil, fmt.Errorf("can't parse port %v: %v", conf.Port, err)
+	}
+	if len(conf.ListenAddress) == 0 {
+		return []*net.UDPAddr{{Port: defaultPort}}, nil
 	}
 
-	log.Debug("Starting to wait for connections")
+	var addresses []*net.UDPAddr
+	for _, listenAddress := range conf.ListenAddress {
+		host, port := listenAddress, defaultPort
+		if strings.HasPrefix(listenAddress, "[") || strings.Count(listenAddress, ":") == 1 {
+			var portStr string
+			host, portStr, err = net.SplitHostPort(listenAddress)
+			if err != nil {
+				host, portStr = strings.Trim(listenAddress, "[]"), conf.Port
+			}
+			port, err = strconv.Atoi(portStr)
+			if err != nil || port < 0 || port > 65535 {
+				return nil, fmt.Errorf("invalid port in %s", listenAddress)
+			}
+		}
+		address := &net.UDPAddr{Port: port}
+		if host != "" && host != "*" {
+			ip, err := net.ResolveIPAddr("ip", host)
+			if err != nil {
+				return nil, fmt.Errorf("invalid address %s: %v", listenAddress, err)
+			}
+			address.IP, address.Zone = ip.IP, ip.Zone
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, nil
+}
+
+func acceptConnections(listener quic.Listener, sshServer *ssh.Server) {
 	for {
 		//TODO: Check when to close the connections
-		sess, err := listener.Accept()
+		sess, err := listener.Accept(context.Background())
 		if err != nil {
 			log.Debug("Failed to accept session: %v", err)
 			continue
 		}
-		stream, err := sess.AcceptStream()
+		admission, err := sshServer.Admit(sess.RemoteAddr())
 		if err != nil {
-			log.Debug("Failed to accept incoming connection (%v)", err)
+			log.Debug("Rejected session", "remoteAddress", sess.RemoteAddr(), "error", err)
+			sess.CloseWithError(0, err.Error())
 			continue
 		}
 
-		qc := &quicconn.QuicConn{Session: sess, Stream: stream}
-		go sshServer.HandleConnection(qc)
+		// Don't block the accept loop on clients that never open a stream
+		go func() {
+			ctx, cancel := context.WithTimeout(context.Background(), streamTimeout)
+			defer cancel()
+			stream, err := sess.AcceptStream(ctx)
+			if err != nil {
+				log.Debug("Failed to accept incoming connection (%v)", err)
+				sess.CloseWithError(0, "no stream opened")
+				admission.Close()
+				return
+			}
+
+			qc := &quicconn.QuicConn{Session: sess, Stream: stream}
+			sshServer.HandleConnection(qc, admission)
+		}()
 	}
 }
@@ -0,0 +1,63 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion-apps/ssh/server/serverconfig"
+)
+
+func TestListenAddresses(t *testing.T) {
+	Convey("Listen addresses are parsed, using Port if they have none", t, func() {
+		testCases := []struct {
+			ListenAddress []string
+			Expected      []string
+		}{
+			{nil, []string{":2200"}},
+			{[]string{"10.0.0.1"}, []string{"10.0.0.1:2200"}},
+			{[]string{"10.0.0.1:22"}, []string{"10.0.0.1:22"}},
+			{[]string{"::1"}, []string{"[::1]:2200"}},
+			{[]string{"[::1]"}, []string{"[::1]:2200"}},
+			{[]string{"[::1]:22"}, []string{"[::1]:22"}},
+			{[]string{"*"}, []string{":2200"}},
+			{[]string{"*:22"}, []string{":22"}},
+			{[]string{":22", "10.0.0.1"}, []string{":22", "10.0.0.1:2200"}},
+		}
+		for _, tc := range testCases {
+			conf := serverconfig.Create()
+			conf.Port = "2200"
+			conf.ListenAddress = tc.ListenAddress
+			addresses, err := listenAddresses(conf)
+			So(err, ShouldBeNil)
+			var actual []string
+			for _, address := range addresses {
+				actual = append(actual, address.String())
+			}
+			So(actual, ShouldResemble, tc.Expected)
+		}
+	})
+
+	Convey("Invalid ports are an error", t, func() {
+		for _, listenAddress := range []string{"10.0.0.1:ssh", "10.0.0.1:65536", "[::1]:-1"} {
+			conf := serverconfig.Create()
+			conf.ListenAddress = []string{listenAddress}
+			_, err := listenAddresses(conf)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
@@ -14,23 +14,66 @@
 
 package serverconfig
 
+import "time"
+
 // ServerConfig is a struct containing configuration for the server.
 type ServerConfig struct {
-	AuthorizedKeysFile     string `regex:".*"`
-	Port                   string `regex:"0*([0-5]?\\d{0,4}|6([0-4]\\d{3}|5([0-4]\\d{2}|5([0-2]\\d|3[0-5]))))"`
-	PasswordAuthentication string `regex:"(yes|no)"`
-	PubkeyAuthentication   string `regex:"(yes|no)"`
-	HostKey                string `regex:".*"`
-	MaxAuthTries           string `regex:"[1-9]\\d*"`
+	AuthorizedKeysFile           string        `regex:".*"`
+	Port                         string        `regex:"0*([0-5]?\\d{0,4}|6([0-4]\\d{3}|5([0-4]\\d{2}|5([0-2]\\d|3[0-5]))))"`
+	ListenAddress                []string      `regex:".*"`
+	PasswordAuthentication       string        `regex:"(yes|no)"`
+	PubkeyAuthentication         string        `regex:"(yes|no)"`
+	KbdInteractiveAuthentication string        `regex:"(yes|no)"`
+	AuthenticationMethods        string        `regex:".*"`
+	HostKey                      string        `regex:".*"`
+	MaxAuthTries                 int           `regex:"[1-9]\\d*"`
+	GatewayPorts                 string        `regex:"(yes|no)"`
+	AcceptEnv                    []string      `regex:".*"`
+	ForceCommand                 string        `regex:".*"`
+	ChrootDirectory              string        `regex:".*"`
+	TrustedUserCAKeys            string        `regex:".*"`
+	AuthorizedPrincipalsFile     string        `regex:".*"`
+	HostCertificate              string        `regex:".*"`
+	AllowAgentForwarding         string        `regex:"(yes|no)"`
+	LoginGraceTime               time.Duration `regex:"(\\d+[sSmMhHdDwW]?)+"`
+	MaxStartups                  string        `regex:"\\d+(:\\d+:\\d+)?"`
+	MaxConnections               string        `regex:"(none|[1-9]\\d*)"`
+	PerSourceMaxStartups         string        `regex:"(none|[1-9]\\d*)"`
+	PerSourceRateLimit           string        `regex:"(none|[1-9]\\d*/([\\d.]+(ns|us|ms|s|m|h))+)"`
+	PerIARateLimit               string        `regex:"(none|[1-9]\\d*/([\\d.]+(ns|us|ms|s|m|h))+)"`
+	BanAfterAuthFailures         string        `regex:"(none|[1-9]\\d*)"`
+	BanTime                      time.Duration `regex:"(\\d+[sSmMhHdDwW]?)+"`
+	AuditLog                     string        `regex:".*"`
+	SessionRecordingDirectory    string        `regex:".*"`
 }
 
 // Create creates a new ServerConfig with the default values.
 func Create() *ServerConfig {
 	return &ServerConfig{
-		AuthorizedKeysFile:     ".ssh/authorized_keys",
-		Port:                   "22",
-		PasswordAuthentication: "yes",
-		PubkeyAuthentication:   "yes",
-		HostKey:                "/etc/ssh/ssh_host_key",
+		AuthorizedKeysFile:           ".ssh/authorized_keys",
+		Port:                         "22",
+		PasswordAuthentication:       "yes",
+		PubkeyAuthentication:         "yes",
+		KbdInteractiveAuthentication: "yes",
+		AuthenticationMethods:        "any",
+		HostKey:                      "/etc/ssh/ssh_host_key",
+		MaxAuthTries:                 6,
+		GatewayPorts:                 "no",
+		ForceCommand:                 "",
+		ChrootDirectory:              "none",
+		TrustedUserCAKeys:            "none",
+		AuthorizedPrincipalsFile:     "none",
+		HostCertificate:              "none",
+		AllowAgentForwarding:         "yes",
+		LoginGraceTime:               2 * time.Minute,
+		MaxStartups:                  "10:30:100",
+		MaxConnections:               "none",
+		PerSourceMaxStartups:         "none",
+		PerSourceRateLimit:           "none",
+		PerIARateLimit:               "none",
+		BanAfterAuthFailures:         "none",
+		BanTime:                      10 * time.Minute,
+		AuditLog:                     "none",
+		SessionRecordingDirectory:    "none",
 	}
 }
@@ -0,0 +1,121 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+
+	log "github.com/inconshreveable/log15"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Request and channel type of OpenSSH agent forwarding.
+const (
+	agentRequestType = "auth-agent-req@openssh.com"
+	agentChannelType = "auth-agent@openssh.com"
+)
+
+// permitsAgentForwarding returns whether the authenticated user may forward
+// its agent.
+func permitsAgentForwarding(perms *ssh.Permissions) bool {
+	_, denied := perms.Extensions[noAgentForwardingExtension]
+	return !denied
+}
+
+// forwardAgent creates the agent socket for a session of conn, if agent
+// forwarding is allowed.
+func (s *Server) forwardAgent(conn *ssh.ServerConn) (*agentSocket, error) {
+	if !s.allowAgentForwarding || !permitsAgentForwarding(conn.Permissions) {
+		return nil, fmt.Errorf("agent forwarding is disabled")
+	}
+	usr, err := sessionUser(conn.Permissions)
+	if err != nil {
+		return nil, err
+	}
+	credential, err := userCredential(usr)
+	if err != nil {
+		return nil, err
+	}
+	return listenAgent(conn, int(credential.Uid), int(credential.Gid))
+}
+
+// agentSocket is a Unix socket in the session of a user, forwarding
+// connections to the agent of the client.
+type agentSocket struct {
+	dir      string
+	listener net.Listener
+}
+
+// listenAgent creates an agent socket accessible only to the user with the
+// given uid and gid.
+func listenAgent(conn ssh.Conn, uid, gid int) (*agentSocket, error) {
+	dir, err := ioutil.TempDir("", "ssh-")
+	if err != nil {
+		return nil, err
+	}
+	a := &agentSocket{dir: dir}
+
+	a.listener, err = net.Listen("unix", a.path())
+	if err == nil {
+		err = os.Chown(dir, uid, gid)
+	}
+	if err == nil {
+		err = os.Chown(a.path(), uid, gid)
+	}
+	if err != nil {
+		a.Close()
+		return nil, err
+	}
+
+	go a.serve(conn)
+	return a, nil
+}
+
+// path returns the path of the socket, to be set as SSH_AUTH_SOCK.
+func (a *agentSocket) path() string {
+	return filepath.Join(a.dir, "agent.sock")
+}
+
+func (a *agentSocket) serve(conn ssh.Conn) {
+	for {
+		localConn, err := a.listener.Accept()
+		if err != nil {
+			log.Debug("Agent socket closed", "error", err)
+			return
+		}
+
+		channel, requests, err := conn.OpenChannel(agentChannelType, nil)
+		if err != nil {
+			log.Debug("Could not open agent channel", "error", err)
+			localConn.Close()
+			continue
+		}
+		go ssh.DiscardRequests(requests)
+		handleTunnelForRemoteConnection(channel, localConn)
+	}
+}
+
+// Close stops forwarding and removes the socket.
+func (a *agentSocket) Close() error {
+	if a.listener != nil {
+		a.listener.Close()
+	}
+	return os.RemoveAll(a.dir)
+}
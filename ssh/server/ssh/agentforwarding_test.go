@@ -0,0 +1,57 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"os"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAgentSocket(t *testing.T) {
+	Convey("Given an agent socket", t, func() {
+		agent, err := listenAgent(nil, os.Getuid(), os.Getgid())
+		So(err, ShouldBeNil)
+
+		Convey("The socket is only accessible to the user", func() {
+			info, err := os.Stat(agent.dir)
+			So(err, ShouldBeNil)
+			So(info.Mode().Perm(), ShouldEqual, 0700)
+			info, err = os.Stat(agent.path())
+			So(err, ShouldBeNil)
+			So(info.Mode()&os.ModeSocket, ShouldNotEqual, 0)
+		})
+
+		Convey("Closing removes the socket", func() {
+			So(agent.Close(), ShouldBeNil)
+			_, err := os.Stat(agent.dir)
+			So(os.IsNotExist(err), ShouldBeTrue)
+		})
+
+		Reset(func() { agent.Close() })
+	})
+}
+
+func TestPermitsAgentForwarding(t *testing.T) {
+	Convey("Agent forwarding can be denied by the key options", t, func() {
+		So(permitsAgentForwarding(&ssh.Permissions{}), ShouldBeTrue)
+		So(permitsAgentForwarding(&ssh.Permissions{
+			Extensions: map[string]string{noAgentForwardingExtension: ""},
+		}), ShouldBeFalse)
+	})
+}
@@ -0,0 +1,161 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/scionproto/scion/go/lib/snet"
+	"github.com/scionproto/scion/go/lib/spath"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// authMethodExtension is set by the server to the authentication methods the
+// client completed, for the audit log.
+const authMethodExtension = "auth-method"
+
+// auditEvent is a line of the audit log.
+type auditEvent struct {
+	Time        time.Time `json:"time"`
+	Event       string    `json:"event"`
+	Remote      string    `json:"remote,omitempty"`
+	Path        string    `json:"path,omitempty"`
+	User        string    `json:"user,omitempty"`
+	Method      string    `json:"method,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	Command     string    `json:"command,omitempty"`
+	Forward     string    `json:"forward,omitempty"`
+	Recording   string    `json:"recording,omitempty"`
+	Duration    float64   `json:"duration,omitempty"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// auditLog writes events as JSON lines.
+type auditLog struct {
+	mutex   sync.Mutex
+	encoder *json.Encoder
+}
+
+func newAuditLog(w io.Writer) *auditLog {
+	return &auditLog{encoder: json.NewEncoder(w)}
+}
+
+// openAuditLog opens the audit log file for appending, creating it if needed.
+func openAuditLog(file string) (*auditLog, error) {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return newAuditLog(f), nil
+}
+
+func (a *auditLog) write(event auditEvent) {
+	if a == nil {
+		return
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if err := a.encoder.Encode(event); err != nil {
+		log.Error("Failed writing audit log", "error", err)
+	}
+}
+
+// newAuditEvent returns an event describing the client of c. perms are the
+// permissions of the authenticated user, or nil during authentication.
+func newAuditEvent(event string, c ssh.ConnMetadata, perms *ssh.Permissions) auditEvent {
+	e := auditEvent{
+		Time:  time.Now(),
+		Event: event,
+		User:  c.User(),
+	}
+	if addr := c.RemoteAddr(); addr != nil {
+		e.Remote = addr.String()
+		e.Path = pathString(addr)
+	}
+	if perms != nil {
+		e.Method = perms.Extensions[authMethodExtension]
+		e.Fingerprint = perms.Extensions["pubkey-fp"]
+	}
+	return e
+}
+
+// audit writes an event of the authenticated connection conn to the audit
+// log. fill sets the fields specific to the event.
+func (s *Server) audit(conn *ssh.ServerConn, event string, fill func(e *auditEvent)) {
+	if s.auditLog == nil {
+		return
+	}
+	e := newAuditEvent(event, conn, conn.Permissions)
+	if fill != nil {
+		fill(&e)
+	}
+	s.auditLog.write(e)
+}
+
+// auditAuth writes failed and partially successful authentication attempts
+// to the audit log. Successful logins are written once the connection is
+// established.
+func (s *Server) auditAuth(c ssh.ConnMetadata, method string, err error) {
+	if err == nil || method == "none" {
+		return
+	}
+	e := newAuditEvent("auth-failure", c, nil)
+	e.Method = method
+	if err == errPartialSuccess {
+		e.Event = "auth-partial"
+	} else {
+		e.Error = err.Error()
+	}
+	s.auditLog.write(e)
+}
+
+// pathString describes the SCION path of a remote address by the interfaces
+// of its hop fields, with the path segments separated by "|". Addresses
+// without path result in an empty string.
+func pathString(addr net.Addr) string {
+	a, ok := addr.(*snet.UDPAddr)
+	if !ok || a.Path == nil || a.Path.IsEmpty() {
+		return ""
+	}
+	raw := a.Path.Raw
+	var segments []string
+	for offset := 0; offset < len(raw); {
+		info, err := spath.InfoFFromRaw(raw[offset:])
+		if err != nil {
+			break
+		}
+		offset += spath.InfoFieldLength
+		hops := make([]string, 0, info.Hops)
+		for i := 0; i < int(info.Hops) && offset < len(raw); i++ {
+			hop, err := spath.HopFFromRaw(raw[offset:])
+			if err != nil {
+				break
+			}
+			hops = append(hops, fmt.Sprintf("%d>%d", hop.ConsIngress, hop.ConsEgress))
+			offset += spath.HopFieldLength
+		}
+		segments = append(segments, fmt.Sprintf("%d: %s", info.ISD, strings.Join(hops, " ")))
+	}
+	return strings.Join(segments, " | ")
+}
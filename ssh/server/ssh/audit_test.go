@@ -0,0 +1,70 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAuditLog(t *testing.T) {
+	Convey("Given a server with an audit log", t, func() {
+		var buf bytes.Buffer
+		s := &Server{auditLog: newAuditLog(&buf)}
+		conn := testConnMetadata{user: "alice", remoteAddr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 4242}}
+
+		readEvents := func() []map[string]interface{} {
+			var events []map[string]interface{}
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				var event map[string]interface{}
+				So(json.Unmarshal([]byte(line), &event), ShouldBeNil)
+				events = append(events, event)
+			}
+			return events
+		}
+
+		Convey("Failed and partially successful attempts are written as JSON lines", func() {
+			s.auditAuth(conn, "password", errors.New("authenticate: failed"))
+			s.auditAuth(conn, "keyboard-interactive", errPartialSuccess)
+			s.auditAuth(conn, "none", errors.New("no auth passed yet"))
+			s.auditAuth(conn, "publickey", nil)
+
+			events := readEvents()
+			So(events, ShouldHaveLength, 2)
+			So(events[0]["event"], ShouldEqual, "auth-failure")
+			So(events[0]["user"], ShouldEqual, "alice")
+			So(events[0]["remote"], ShouldEqual, "10.0.0.1:4242")
+			So(events[0]["method"], ShouldEqual, "password")
+			So(events[0]["error"], ShouldEqual, "authenticate: failed")
+			So(events[1]["event"], ShouldEqual, "auth-partial")
+			So(events[1], ShouldNotContainKey, "error")
+		})
+
+		Convey("Events of authenticated users contain the method and key fingerprint", func() {
+			perms := userPermissions("alice")
+			perms.Extensions = map[string]string{authMethodExtension: "publickey", "pubkey-fp": "SHA256:abc"}
+			e := newAuditEvent("login", conn, perms)
+			So(e.Method, ShouldEqual, "publickey")
+			So(e.Fingerprint, ShouldEqual, "SHA256:abc")
+			So(e.Path, ShouldEqual, "")
+		})
+	})
+}
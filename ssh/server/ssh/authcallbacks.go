@@ -16,7 +16,10 @@ package ssh
 
 import (
 	"fmt"
-	"io/ioutil"
+	"os/user"
+	"time"
+
+	log "github.com/inconshreveable/log15"
 
 	"golang.org/x/crypto/ssh"
 
@@ -40,51 +43,80 @@ func (s *Server) PasswordAuth(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions
 		return nil, fmt.Errorf("authenticate: %s", err.Error())
 	}
 
-	return &ssh.Permissions{
-		CriticalOptions: map[string]string{
-			"user": c.User(),
-		},
-	}, nil
+	return s.authenticated(c, passwordMethod, userPermissions(c.User()))
 }
 
-func loadAuthorizedKeys(file string) (map[string]bool, error) {
-	authKeys := make(map[string]bool)
-
-	authorizedKeysBytes, err := ioutil.ReadFile(file)
+// KeyboardInteractiveAuth authenticates the client using keyboard-interactive
+// authentication, relaying the PAM conversation to the client. Each prompt is
+// sent as a separate challenge, messages are sent as instructions without
+// questions.
+func (s *Server) KeyboardInteractiveAuth(c ssh.ConnMetadata, client ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+	t, err := pam.StartFunc("", c.User(), func(style pam.Style, msg string) (string, error) {
+		switch style {
+		case pam.PromptEchoOff, pam.PromptEchoOn:
+			answers, err := client(c.User(), "", []string{msg}, []bool{style == pam.PromptEchoOn})
+			if err != nil {
+				return "", err
+			}
+			if len(answers) != 1 {
+				return "", fmt.Errorf("expected 1 answer, got %d", len(answers))
+			}
+			return answers[0], nil
+		case pam.ErrorMsg, pam.TextInfo:
+			_, err := client(c.User(), msg, nil, nil)
+			return "", err
+		}
+		return "", fmt.Errorf("unsupported message style")
+	})
 	if err != nil {
 		return nil, err
 	}
+	err = t.Authenticate(0)
+	if err != nil {
+		return nil, fmt.Errorf("authenticate: %s", err.Error())
+	}
 
-	for len(authorizedKeysBytes) > 0 {
-		pubKey, _, _, rest, err := ssh.ParseAuthorizedKey(authorizedKeysBytes)
-		if err != nil {
-			return nil, err
-		}
+	return s.authenticated(c, keyboardInteractiveMethod, userPermissions(c.User()))
+}
 
-		authKeys[string(pubKey.Marshal())] = true
-		authorizedKeysBytes = rest
+func userPermissions(username string) *ssh.Permissions {
+	return &ssh.Permissions{
+		CriticalOptions: map[string]string{
+			"user": username,
+		},
 	}
-
-	return authKeys, nil
 }
 
-// PublicKeyAuth authenticates the client using a public key.
+// PublicKeyAuth authenticates the client using a public key, listed in one of
+// the authorized keys files of the user.
 func (s *Server) PublicKeyAuth(c ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
-	authKeys, err := loadAuthorizedKeys(s.authorizedKeysFile)
+	perms, err := s.publicKeyPermissions(c, pubKey)
 	if err != nil {
-		return nil, fmt.Errorf("failed loading authorized files: %v", err)
+		return nil, err
+	}
+	return s.authenticated(c, publicKeyMethod, perms)
+}
+
+func (s *Server) publicKeyPermissions(c ssh.ConnMetadata, pubKey ssh.PublicKey) (*ssh.Permissions, error) {
+	if cert, ok := pubKey.(*ssh.Certificate); ok {
+		return s.CertificateAuth(c, cert)
 	}
 
-	if authKeys[string(pubKey.Marshal())] {
-		return &ssh.Permissions{
-			CriticalOptions: map[string]string{
-				"user": c.User(),
-			},
-			Extensions: map[string]string{
-				// Record the public key used for authentication
-				"pubkey-fp": ssh.FingerprintSHA256(pubKey),
-			},
-		}, nil
+	usr, err := user.Lookup(c.User())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, file := range authorizedKeysFiles(s.authorizedKeysFile, usr) {
+		authKeys, err := loadAuthorizedKeys(file)
+		if err != nil {
+			log.Debug("Failed loading authorized keys", "file", file, "error", err)
+			continue
+		}
+
+		if key, ok := authKeys[string(pubKey.Marshal())]; ok {
+			return key.permissions(c.User(), pubKey, c.RemoteAddr(), time.Now())
+		}
 	}
 
 	return nil, fmt.Errorf("unknown public key for %q", c.User())
@@ -0,0 +1,161 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	publicKeyMethod           = "publickey"
+	passwordMethod            = "password"
+	keyboardInteractiveMethod = "keyboard-interactive"
+)
+
+// authProgressTimeout is how long the methods a client completed are kept
+// for connections that never finish authenticating.
+const authProgressTimeout = 10 * time.Minute
+
+var errPartialSuccess = errors.New("further authentication required")
+
+// parseAuthenticationMethods parses the AuthenticationMethods option, a
+// whitespace-separated list of comma-separated lists of methods. The client
+// must complete all methods of one of the lists. "any" requires a single
+// method, which is represented by nil.
+//
+// publickey can't be combined with other methods. The server library runs the
+// public key callback once per key, usually for the client's query before it
+// proves that it holds the key, and caches the result. It can't report a
+// partial success either, so a client that tries its key before the other
+// methods, as OpenSSH does by default, would never be let in.
+func parseAuthenticationMethods(option string) ([][]string, error) {
+	option = strings.TrimSpace(option)
+	if option == "" || option == "any" {
+		return nil, nil
+	}
+	var lists [][]string
+	for _, field := range strings.Fields(option) {
+		var list []string
+		for _, method := range strings.Split(field, ",") {
+			switch method {
+			case publicKeyMethod, passwordMethod, keyboardInteractiveMethod:
+				list = append(list, method)
+			default:
+				return nil, fmt.Errorf("unsupported authentication method %q", method)
+			}
+		}
+		if len(list) > 1 {
+			for _, method := range list {
+				if method == publicKeyMethod {
+					return nil, fmt.Errorf("%s can't be combined with other methods", publicKeyMethod)
+				}
+			}
+		}
+		lists = append(lists, list)
+	}
+	return lists, nil
+}
+
+// authProgress records the methods each connection completed so far,
+// identified by its session ID.
+type authProgress struct {
+	mutex  sync.Mutex
+	states map[string]*authState
+}
+
+type authState struct {
+	user    string
+	methods map[string]bool
+	started time.Time
+}
+
+func newAuthProgress() *authProgress {
+	return &authProgress{states: make(map[string]*authState)}
+}
+
+// completed records method for c and returns all methods c completed.
+func (p *authProgress) completed(c ssh.ConnMetadata, method string) map[string]bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	for id, state := range p.states {
+		if now.Sub(state.started) > authProgressTimeout {
+			delete(p.states, id)
+		}
+	}
+
+	id := string(c.SessionID())
+	state, ok := p.states[id]
+	// Methods completed for another user do not count
+	if !ok || state.user != c.User() {
+		state = &authState{user: c.User(), methods: make(map[string]bool), started: now}
+		p.states[id] = state
+	}
+
+	done := map[string]bool{method: true}
+	for m := range state.methods {
+		done[m] = true
+	}
+	state.methods[method] = true
+	return done
+}
+
+func (p *authProgress) forget(c ssh.ConnMetadata) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.states, string(c.SessionID()))
+}
+
+// authenticated is called when c successfully authenticated using method. It
+// returns perms if this completes one of the lists of AuthenticationMethods,
+// and an error otherwise, in which case the client has to continue with
+// another method.
+func (s *Server) authenticated(c ssh.ConnMetadata, method string, perms *ssh.Permissions) (*ssh.Permissions, error) {
+	if perms.Extensions == nil {
+		perms.Extensions = make(map[string]string)
+	}
+	if s.authenticationMethods == nil {
+		perms.Extensions[authMethodExtension] = method
+		return perms, nil
+	}
+
+	done := s.authProgress.completed(c, method)
+	for _, list := range s.authenticationMethods {
+		if completesList(list, method, done) {
+			s.authProgress.forget(c)
+			perms.Extensions[authMethodExtension] = strings.Join(list, ",")
+			return perms, nil
+		}
+	}
+	return nil, errPartialSuccess
+}
+
+func completesList(list []string, method string, done map[string]bool) bool {
+	contains := false
+	for _, m := range list {
+		if !done[m] {
+			return false
+		}
+		contains = contains || m == method
+	}
+	return contains
+}
@@ -0,0 +1,94 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParseAuthenticationMethods(t *testing.T) {
+	Convey("AuthenticationMethods are parsed", t, func() {
+		lists, err := parseAuthenticationMethods("any")
+		So(err, ShouldBeNil)
+		So(lists, ShouldBeNil)
+
+		lists, err = parseAuthenticationMethods("password,keyboard-interactive  publickey")
+		So(err, ShouldBeNil)
+		So(lists, ShouldResemble, [][]string{{"password", "keyboard-interactive"}, {"publickey"}})
+
+		_, err = parseAuthenticationMethods("publickey,hostbased")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("publickey can't be combined with other methods", t, func() {
+		_, err := parseAuthenticationMethods("publickey,keyboard-interactive")
+		So(err, ShouldNotBeNil)
+		_, err = parseAuthenticationMethods("password keyboard-interactive,publickey")
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestAuthenticated(t *testing.T) {
+	Convey("Given a server requiring publickey, or password and keyboard-interactive", t, func() {
+		s := &Server{
+			authenticationMethods: [][]string{
+				{publicKeyMethod},
+				{passwordMethod, keyboardInteractiveMethod},
+			},
+			authProgress: newAuthProgress(),
+		}
+		alice := testConnMetadata{user: "alice"}
+		perms := userPermissions("alice")
+
+		Convey("A single method of a list is not sufficient", func() {
+			_, err := s.authenticated(alice, keyboardInteractiveMethod, perms)
+			So(err, ShouldEqual, errPartialSuccess)
+			_, err = s.authenticated(alice, keyboardInteractiveMethod, perms)
+			So(err, ShouldEqual, errPartialSuccess)
+		})
+
+		Convey("A public key completes its list", func() {
+			result, err := s.authenticated(alice, publicKeyMethod, perms)
+			So(err, ShouldBeNil)
+			So(result, ShouldEqual, perms)
+			So(result.Extensions[authMethodExtension], ShouldEqual, publicKeyMethod)
+		})
+
+		Convey("Methods completed in any order of a list succeed", func() {
+			_, err := s.authenticated(alice, keyboardInteractiveMethod, perms)
+			So(err, ShouldEqual, errPartialSuccess)
+			_, err = s.authenticated(alice, passwordMethod, perms)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Methods completed for another user do not count", func() {
+			_, err := s.authenticated(testConnMetadata{user: "bob"}, keyboardInteractiveMethod, userPermissions("bob"))
+			So(err, ShouldEqual, errPartialSuccess)
+			_, err = s.authenticated(alice, passwordMethod, perms)
+			So(err, ShouldEqual, errPartialSuccess)
+		})
+	})
+
+	Convey("Without AuthenticationMethods, any method succeeds", t, func() {
+		s := &Server{authProgress: newAuthProgress()}
+		perms := &ssh.Permissions{}
+		result, err := s.authenticated(testConnMetadata{user: "alice"}, passwordMethod, perms)
+		So(err, ShouldBeNil)
+		So(result, ShouldEqual, perms)
+	})
+}
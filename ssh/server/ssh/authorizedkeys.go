@@ -0,0 +1,295 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/scionproto/scion/go/lib/snet"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Names of the permissions restricting what an authenticated user may do.
+const (
+	forceCommandOption         = "force-command"
+	noPtyExtension             = "no-pty"
+	noPortForwardingExtension  = "no-port-forwarding"
+	noAgentForwardingExtension = "no-agent-forwarding"
+)
+
+// authorizedKey holds the options of an authorized key, see the
+// AUTHORIZED_KEYS FILE FORMAT section of sshd(8).
+type authorizedKey struct {
+	command           string
+	from              string
+	expiry            time.Time
+	noPty             bool
+	noPortForwarding  bool
+	noAgentForwarding bool
+}
+
+// authorizedKeysFiles returns the authorized keys files of usr. Relative
+// paths are relative to the home directory of the user.
+func authorizedKeysFiles(files string, usr *user.User) []string {
+	var paths []string
+	for _, file := range strings.Fields(files) {
+		if file == "none" {
+			continue
+		}
+		file = expandUserPath(file, usr)
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(usr.HomeDir, file)
+		}
+		paths = append(paths, file)
+	}
+	return paths
+}
+
+// loadAuthorizedKeys reads an authorized keys file. Keys with invalid options
+// are skipped.
+func loadAuthorizedKeys(file string) (map[string]*authorizedKey, error) {
+	authKeys := make(map[string]*authorizedKey)
+
+	authorizedKeysBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	for len(authorizedKeysBytes) > 0 {
+		pubKey, _, options, rest, err := ssh.ParseAuthorizedKey(authorizedKeysBytes)
+		if err != nil {
+			return nil, err
+		}
+		authorizedKeysBytes = rest
+
+		key, err := parseKeyOptions(options)
+		if err != nil {
+			log.Debug("Skipping authorized key", "file", file, "error", err)
+			continue
+		}
+		authKeys[string(pubKey.Marshal())] = key
+	}
+
+	return authKeys, nil
+}
+
+// parseKeyOptions parses the options of an authorized key. Unknown options are
+// an error, so that keys are never less restricted than intended.
+func parseKeyOptions(options []string) (*authorizedKey, error) {
+	key := &authorizedKey{}
+	for _, option := range options {
+		name, value := option, ""
+		if eq := strings.Index(option, "="); eq >= 0 {
+			var err error
+			name = option[:eq]
+			value, err = unquoteOption(option[eq+1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid option %s: %v", name, err)
+			}
+		}
+		switch strings.ToLower(name) {
+		case "command":
+			key.command = value
+		case "from":
+			key.from = value
+		case "expiry-time":
+			expiry, err := parseExpiryTime(value)
+			if err != nil {
+				return nil, err
+			}
+			key.expiry = expiry
+		case "no-pty":
+			key.noPty = true
+		case "no-port-forwarding":
+			key.noPortForwarding = true
+		case "no-agent-forwarding":
+			key.noAgentForwarding = true
+		case "restrict":
+			key.noPty = true
+			key.noPortForwarding = true
+			key.noAgentForwarding = true
+		case "pty":
+			key.noPty = false
+		case "port-forwarding":
+			key.noPortForwarding = false
+		case "agent-forwarding":
+			key.noAgentForwarding = false
+		case "no-x11-forwarding", "no-user-rc", "x11-forwarding", "user-rc":
+			// X11 forwarding and rc files are not supported anyway
+		default:
+			return nil, fmt.Errorf("unsupported option %s", name)
+		}
+	}
+	return key, nil
+}
+
+func unquoteOption(value string) (string, error) {
+	if len(value) < 2 || value[0] != '"' || value[len(value)-1] != '"' {
+		return "", fmt.Errorf("value must be enclosed in double quotes")
+	}
+	return strings.Replace(value[1:len(value)-1], `\"`, `"`, -1), nil
+}
+
+// parseExpiryTime parses a time of the form YYYYMMDD[HHMM[SS]] in local time.
+func parseExpiryTime(value string) (time.Time, error) {
+	layouts := map[int]string{
+		8:  "20060102",
+		12: "200601021504",
+		14: "20060102150405",
+	}
+	layout, ok := layouts[len(value)]
+	if !ok {
+		return time.Time{}, fmt.Errorf("invalid expiry time %s", value)
+	}
+	return time.ParseInLocation(layout, value, time.Local)
+}
+
+// permissions checks that the key may be used from addr at time now, and
+// returns the permissions of a user authenticated with it.
+func (k *authorizedKey) permissions(username string, pubKey ssh.PublicKey, addr net.Addr, now time.Time) (*ssh.Permissions, error) {
+	if !k.expiry.IsZero() && now.After(k.expiry) {
+		return nil, fmt.Errorf("key expired at %v", k.expiry)
+	}
+	if k.from != "" && !matchFrom(k.from, addr) {
+		return nil, fmt.Errorf("key not allowed from %v", addr)
+	}
+
+	perms := &ssh.Permissions{
+		CriticalOptions: map[string]string{
+			"user": username,
+		},
+		Extensions: map[string]string{
+			// Record the public key used for authentication
+			"pubkey-fp": ssh.FingerprintSHA256(pubKey),
+		},
+	}
+	if k.command != "" {
+		perms.CriticalOptions[forceCommandOption] = k.command
+	}
+	if k.noPty {
+		perms.Extensions[noPtyExtension] = ""
+	}
+	if k.noPortForwarding {
+		perms.Extensions[noPortForwardingExtension] = ""
+	}
+	if k.noAgentForwarding {
+		perms.Extensions[noAgentForwardingExtension] = ""
+	}
+	return perms, nil
+}
+
+// matchFrom matches addr against a comma-separated list of patterns, as in
+// the from= option of authorized keys. Patterns may be negated with a leading
+// "!" and contain the wildcards "*" and "?". A pattern is either
+//   - an ISD-AS, e.g. 1-ffaa:1:* (matches any host in these ASes),
+//   - an IP address or CIDR block, e.g. 10.0.0.* or 10.0.0.0/8,
+//   - or a SCION address ISD-AS,[IP] matching both.
+//
+// IP addresses are only unique within an AS, so a SCION address is only
+// admitted by patterns with an ISD-AS; negated IP patterns still reject it.
+// The address is rejected if it matches a negated pattern or no pattern.
+func matchFrom(patterns string, addr net.Addr) bool {
+	ia, ip := splitRemoteAddr(addr)
+	matched := false
+	for _, pattern := range splitFromPatterns(patterns) {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+		if ia != "" && !negated && !iaPatternRegex.MatchString(pattern) {
+			continue
+		}
+		if !matchAddrPattern(pattern, ia, ip) {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// splitFromPatterns splits a pattern list at commas, keeping SCION address
+// patterns of the form ISD-AS,[IP] together.
+func splitFromPatterns(patterns string) []string {
+	var result []string
+	for _, pattern := range strings.Split(patterns, ",") {
+		if strings.HasPrefix(pattern, "[") && len(result) > 0 {
+			result[len(result)-1] += "," + pattern
+			continue
+		}
+		result = append(result, pattern)
+	}
+	return result
+}
+
+var iaPatternRegex = regexp.MustCompile(`^[\d*?]+-`)
+
+func matchAddrPattern(pattern, ia string, ip net.IP) bool {
+	if comma := strings.Index(pattern, ",["); comma >= 0 && strings.HasSuffix(pattern, "]") {
+		return matchIAPattern(pattern[:comma], ia) && matchIPPattern(pattern[comma+2:len(pattern)-1], ip)
+	}
+	if iaPatternRegex.MatchString(pattern) {
+		return matchIAPattern(pattern, ia)
+	}
+	return matchIPPattern(pattern, ip)
+}
+
+func matchIAPattern(pattern, ia string) bool {
+	return ia != "" && matchWildcard(pattern, ia)
+}
+
+func matchIPPattern(pattern string, ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	if strings.Contains(pattern, "/") {
+		_, network, err := net.ParseCIDR(pattern)
+		return err == nil && network.Contains(ip)
+	}
+	return matchWildcard(pattern, ip.String())
+}
+
+// matchWildcard matches s against a pattern where "*" matches any sequence of
+// characters and "?" any single character.
+func matchWildcard(pattern, s string) bool {
+	expr := regexp.QuoteMeta(pattern)
+	expr = strings.Replace(expr, `\*`, ".*", -1)
+	expr = strings.Replace(expr, `\?`, ".", -1)
+	matched, err := regexp.MatchString("^"+expr+"$", s)
+	return err == nil && matched
+}
+
+// splitRemoteAddr returns the ISD-AS (empty for non-SCION addresses) and the
+// IP of a remote address.
+func splitRemoteAddr(addr net.Addr) (string, net.IP) {
+	switch a := addr.(type) {
+	case *snet.UDPAddr:
+		return a.IA.String(), a.Host.IP
+	case *net.TCPAddr:
+		return "", a.IP
+	case *net.UDPAddr:
+		return "", a.IP
+	}
+	return "", nil
+}
@@ -0,0 +1,188 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/go/lib/snet"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestAuthorizedKeysFiles(t *testing.T) {
+	Convey("Authorized keys files are expanded per user", t, func() {
+		usr := &user.User{Username: "alice", HomeDir: "/home/alice"}
+		So(authorizedKeysFiles(".ssh/authorized_keys", usr), ShouldResemble, []string{"/home/alice/.ssh/authorized_keys"})
+		So(authorizedKeysFiles("%h/.ssh/authorized_keys /etc/ssh/keys/%u", usr), ShouldResemble,
+			[]string{"/home/alice/.ssh/authorized_keys", "/etc/ssh/keys/alice"})
+		So(authorizedKeysFiles("none", usr), ShouldBeEmpty)
+	})
+}
+
+func TestLoadAuthorizedKeys(t *testing.T) {
+	Convey("Given an authorized keys file with options", t, func() {
+		restricted := newTestKey()
+		plain := newTestKey()
+		invalid := newTestKey()
+		content := `command="echo \"hi\"",from="1-ffaa:1:*",no-pty ` + string(ssh.MarshalAuthorizedKey(restricted)) +
+			string(ssh.MarshalAuthorizedKey(plain)) +
+			`tunnel="0" ` + string(ssh.MarshalAuthorizedKey(invalid))
+		file, err := ioutil.TempFile("", "authorized_keys")
+		So(err, ShouldBeNil)
+		defer os.Remove(file.Name())
+		_, err = file.WriteString(content)
+		So(err, ShouldBeNil)
+		file.Close()
+
+		authKeys, err := loadAuthorizedKeys(file.Name())
+		So(err, ShouldBeNil)
+
+		Convey("The options of the keys are parsed", func() {
+			So(authKeys[string(restricted.Marshal())], ShouldResemble, &authorizedKey{
+				command: `echo "hi"`,
+				from:    "1-ffaa:1:*",
+				noPty:   true,
+			})
+			So(authKeys[string(plain.Marshal())], ShouldResemble, &authorizedKey{})
+		})
+
+		Convey("Keys with unsupported options are skipped", func() {
+			So(authKeys, ShouldNotContainKey, string(invalid.Marshal()))
+		})
+	})
+}
+
+func TestParseKeyOptions(t *testing.T) {
+	Convey("restrict disables everything but what is enabled again", t, func() {
+		key, err := parseKeyOptions([]string{"restrict", "pty"})
+		So(err, ShouldBeNil)
+		So(key.noPty, ShouldBeFalse)
+		So(key.noPortForwarding, ShouldBeTrue)
+		So(key.noAgentForwarding, ShouldBeTrue)
+	})
+
+	Convey("Option values must be quoted", t, func() {
+		_, err := parseKeyOptions([]string{"command=ls"})
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("Expiry times are parsed in local time", t, func() {
+		key, err := parseKeyOptions([]string{`expiry-time="202012311830"`})
+		So(err, ShouldBeNil)
+		So(key.expiry, ShouldEqual, time.Date(2020, 12, 31, 18, 30, 0, 0, time.Local))
+		_, err = parseKeyOptions([]string{`expiry-time="2020"`})
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestKeyPermissions(t *testing.T) {
+	Convey("Given a public key", t, func() {
+		pubKey := newTestKey()
+		addr, err := snet.ParseUDPAddr("1-ffaa:1:abc,[10.0.0.1]:4242")
+		So(err, ShouldBeNil)
+		now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.Local)
+
+		Convey("Restrictions are recorded in the permissions", func() {
+			key := &authorizedKey{command: "uptime", noPty: true, noPortForwarding: true}
+			perms, err := key.permissions("alice", pubKey, addr, now)
+			So(err, ShouldBeNil)
+			So(perms.CriticalOptions["user"], ShouldEqual, "alice")
+			So(perms.CriticalOptions[forceCommandOption], ShouldEqual, "uptime")
+			So(perms.Extensions, ShouldContainKey, noPtyExtension)
+			So(perms.Extensions, ShouldContainKey, noPortForwardingExtension)
+			So(perms.Extensions, ShouldNotContainKey, noAgentForwardingExtension)
+		})
+
+		Convey("Expired keys are rejected", func() {
+			key := &authorizedKey{expiry: now.Add(-time.Hour)}
+			_, err := key.permissions("alice", pubKey, addr, now)
+			So(err, ShouldNotBeNil)
+			key.expiry = now.Add(time.Hour)
+			_, err = key.permissions("alice", pubKey, addr, now)
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Keys are rejected from other addresses", func() {
+			key := &authorizedKey{from: "2-*"}
+			_, err := key.permissions("alice", pubKey, addr, now)
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestMatchFrom(t *testing.T) {
+	Convey("Given a SCION and a TCP address", t, func() {
+		scionAddr, err := snet.ParseUDPAddr("1-ffaa:1:abc,[10.0.0.1]:4242")
+		So(err, ShouldBeNil)
+		tcpAddr := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 22}
+
+		testCases := []struct {
+			Patterns string
+			SCION    bool
+			TCP      bool
+		}{
+			{"1-ffaa:1:abc", true, false},
+			{"1-ffaa:1:*", true, false},
+			{"1-*,!1-ffaa:1:abc", false, false},
+			{"2-ffaa:1:abc", false, false},
+			{"10.0.0.1", false, true},
+			{"10.0.0.*", false, true},
+			{"10.0.0.0/24", false, true},
+			{"10.0.1.0/24", false, false},
+			{"1-ffaa:1:abc,[10.0.0.1]", true, false},
+			{"1-ffaa:1:abc,[10.0.0.2],10.0.0.1", false, true},
+			{"1-ffaa:1:*,[10.0.0.?]", true, false},
+			{"*,!10.0.0.1", false, false},
+			{"1-ffaa:1:abc,!10.0.0.1", false, false},
+		}
+		for _, tc := range testCases {
+			So(matchFrom(tc.Patterns, scionAddr), ShouldEqual, tc.SCION)
+			So(matchFrom(tc.Patterns, tcpAddr), ShouldEqual, tc.TCP)
+		}
+	})
+
+	Convey("The same IP from another ISD-AS is refused", t, func() {
+		otherAddr, err := snet.ParseUDPAddr("2-ffaa:1:abc,[10.0.0.1]:4242")
+		So(err, ShouldBeNil)
+		So(matchFrom("1-ffaa:1:abc,[10.0.0.1]", otherAddr), ShouldBeFalse)
+		So(matchFrom("10.0.0.1", otherAddr), ShouldBeFalse)
+		So(matchFrom("10.0.0.0/8", otherAddr), ShouldBeFalse)
+
+		key := &authorizedKey{from: "10.0.0.1"}
+		_, err = key.permissions("alice", newTestKey(), otherAddr, time.Now())
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func newTestKey() ssh.PublicKey {
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	pubKey, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		panic(err)
+	}
+	return pubKey
+}
@@ -0,0 +1,164 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Critical options of user certificates, see PROTOCOL.certkeys of OpenSSH.
+const (
+	certForceCommand  = "force-command"
+	certSourceAddress = "source-address"
+)
+
+// Extensions of user certificates granting permissions.
+const (
+	certPermitPty            = "permit-pty"
+	certPermitPortForwarding = "permit-port-forwarding"
+	certPermitAgent          = "permit-agent-forwarding"
+)
+
+// loadPublicKeys reads a file with one public key per line, in the format of
+// authorized keys files.
+func loadPublicKeys(file string) ([]ssh.PublicKey, error) {
+	keysBytes, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []ssh.PublicKey
+	for len(bytes.TrimSpace(keysBytes)) > 0 {
+		key, _, _, rest, err := ssh.ParseAuthorizedKey(keysBytes)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+		keysBytes = rest
+	}
+	return keys, nil
+}
+
+// isUserAuthority returns whether auth is listed in TrustedUserCAKeys.
+func (s *Server) isUserAuthority(auth ssh.PublicKey) bool {
+	for _, key := range s.trustedUserCAKeys {
+		if keyEqual(key, auth) {
+			return true
+		}
+	}
+	return false
+}
+
+func keyEqual(a, b ssh.PublicKey) bool {
+	return bytes.Equal(a.Marshal(), b.Marshal())
+}
+
+// CertificateAuth authenticates the client using a user certificate signed by
+// one of the trusted certificate authorities. The certificate must list the
+// user name as principal, or one of the principals in the user's
+// AuthorizedPrincipalsFile if it is set.
+func (s *Server) CertificateAuth(c ssh.ConnMetadata, cert *ssh.Certificate) (*ssh.Permissions, error) {
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("certificate is not a user certificate")
+	}
+	if !s.isUserAuthority(cert.SignatureKey) {
+		return nil, fmt.Errorf("certificate signed by unknown authority")
+	}
+	usr, err := user.Lookup(c.User())
+	if err != nil {
+		return nil, err
+	}
+
+	principals := []string{c.User()}
+	if s.authorizedPrincipalsFile != "none" {
+		principals, err = loadPrincipals(authorizedKeysFiles(s.authorizedPrincipalsFile, usr))
+		if err != nil {
+			return nil, err
+		}
+	}
+	principal, ok := matchPrincipal(principals, cert.ValidPrincipals)
+	if !ok {
+		return nil, fmt.Errorf("no authorized principal for %q in certificate", c.User())
+	}
+
+	checker := ssh.CertChecker{
+		SupportedCriticalOptions: []string{certForceCommand, certSourceAddress},
+	}
+	if err := checker.CheckCert(principal, cert); err != nil {
+		return nil, err
+	}
+	return certificateKey(cert).permissions(c.User(), cert, c.RemoteAddr(), time.Now())
+}
+
+// certificateKey returns the restrictions of a certificate as authorized key
+// options. The validity period has already been checked with the certificate.
+func certificateKey(cert *ssh.Certificate) *authorizedKey {
+	_, permitPty := cert.Extensions[certPermitPty]
+	_, permitPortForwarding := cert.Extensions[certPermitPortForwarding]
+	_, permitAgent := cert.Extensions[certPermitAgent]
+	return &authorizedKey{
+		command:           cert.CriticalOptions[certForceCommand],
+		from:              cert.CriticalOptions[certSourceAddress],
+		noPty:             !permitPty,
+		noPortForwarding:  !permitPortForwarding,
+		noAgentForwarding: !permitAgent,
+	}
+}
+
+// loadPrincipals reads the principals listed in the first of files that
+// exists, one per line.
+func loadPrincipals(files []string) ([]string, error) {
+	for _, file := range files {
+		f, err := os.Open(file)
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		var principals []string
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			principals = append(principals, line)
+		}
+		return principals, scanner.Err()
+	}
+	return nil, nil
+}
+
+func matchPrincipal(allowed, valid []string) (string, bool) {
+	for _, a := range allowed {
+		for _, v := range valid {
+			if a == v {
+				return a, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,141 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/user"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/go/lib/snet"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"golang.org/x/crypto/ssh"
+)
+
+type testConnMetadata struct {
+	user       string
+	remoteAddr net.Addr
+}
+
+func (c testConnMetadata) User() string          { return c.user }
+func (c testConnMetadata) SessionID() []byte     { return nil }
+func (c testConnMetadata) ClientVersion() []byte { return nil }
+func (c testConnMetadata) ServerVersion() []byte { return nil }
+func (c testConnMetadata) RemoteAddr() net.Addr  { return c.remoteAddr }
+func (c testConnMetadata) LocalAddr() net.Addr   { return nil }
+
+func newTestSigner() ssh.Signer {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		panic(err)
+	}
+	return signer
+}
+
+func TestCertificateAuth(t *testing.T) {
+	Convey("Given a server trusting a user CA", t, func() {
+		ca := newTestSigner()
+		s := &Server{
+			trustedUserCAKeys:        []ssh.PublicKey{ca.PublicKey()},
+			authorizedPrincipalsFile: "none",
+		}
+		usr, err := user.Current()
+		So(err, ShouldBeNil)
+		addr, err := snet.ParseUDPAddr("1-ffaa:1:abc,[10.0.0.1]:4242")
+		So(err, ShouldBeNil)
+		conn := testConnMetadata{user: usr.Username, remoteAddr: addr}
+
+		newCert := func(principals ...string) *ssh.Certificate {
+			cert := &ssh.Certificate{
+				Key:             newTestSigner().PublicKey(),
+				CertType:        ssh.UserCert,
+				ValidPrincipals: principals,
+				ValidAfter:      uint64(time.Now().Add(-time.Hour).Unix()),
+				ValidBefore:     uint64(time.Now().Add(time.Hour).Unix()),
+				Permissions: ssh.Permissions{
+					Extensions: map[string]string{certPermitPty: ""},
+				},
+			}
+			So(cert.SignCert(rand.Reader, ca), ShouldBeNil)
+			return cert
+		}
+
+		Convey("A certificate for the user is accepted", func() {
+			perms, err := s.CertificateAuth(conn, newCert(usr.Username))
+			So(err, ShouldBeNil)
+			So(perms.CriticalOptions["user"], ShouldEqual, usr.Username)
+			So(perms.Extensions, ShouldNotContainKey, noPtyExtension)
+			So(perms.Extensions, ShouldContainKey, noPortForwardingExtension)
+		})
+
+		Convey("A certificate for other principals is rejected", func() {
+			_, err := s.CertificateAuth(conn, newCert("someone-else"))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("A certificate of an unknown CA is rejected", func() {
+			cert := newCert(usr.Username)
+			So(cert.SignCert(rand.Reader, newTestSigner()), ShouldBeNil)
+			_, err := s.CertificateAuth(conn, cert)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("An expired certificate is rejected", func() {
+			cert := newCert(usr.Username)
+			cert.ValidBefore = uint64(time.Now().Add(-time.Minute).Unix())
+			So(cert.SignCert(rand.Reader, ca), ShouldBeNil)
+			_, err := s.CertificateAuth(conn, cert)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("The source address of the certificate is enforced", func() {
+			cert := newCert(usr.Username)
+			cert.CriticalOptions = map[string]string{certSourceAddress: "2-*"}
+			So(cert.SignCert(rand.Reader, ca), ShouldBeNil)
+			_, err := s.CertificateAuth(conn, cert)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("Given an authorized principals file", func() {
+			file, err := ioutil.TempFile("", "principals")
+			So(err, ShouldBeNil)
+			defer os.Remove(file.Name())
+			_, err = file.WriteString("# team\nops\n")
+			So(err, ShouldBeNil)
+			file.Close()
+			s.authorizedPrincipalsFile = file.Name()
+
+			Convey("Listed principals map to the user", func() {
+				_, err := s.CertificateAuth(conn, newCert("ops"))
+				So(err, ShouldBeNil)
+			})
+
+			Convey("The user name is not a principal anymore", func() {
+				_, err := s.CertificateAuth(conn, newCert(usr.Username))
+				So(err, ShouldNotBeNil)
+			})
+		})
+	})
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"os/user"
+	"path"
+	"strings"
+)
+
+const defaultPath = "/usr/local/bin:/usr/bin:/bin"
+
+// parseAcceptEnv splits the AcceptEnv options into single patterns. Each
+// option can contain several patterns separated by whitespace.
+func parseAcceptEnv(options []string) []string {
+	var patterns []string
+	for _, option := range options {
+		patterns = append(patterns, strings.Fields(option)...)
+	}
+	return patterns
+}
+
+// acceptsEnv returns whether a client may set the environment variable name.
+func (s *Server) acceptsEnv(name string) bool {
+	if name == "" || strings.Contains(name, "=") {
+		return false
+	}
+	for _, pattern := range s.acceptEnv {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// userEnv creates the login environment of usr.
+func userEnv(usr *user.User, shell string) []string {
+	return []string{
+		"HOME=" + usr.HomeDir,
+		"USER=" + usr.Username,
+		"LOGNAME=" + usr.Username,
+		"SHELL=" + shell,
+		"PATH=" + defaultPath,
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"syscall"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAcceptsEnv(t *testing.T) {
+	Convey("Given a server accepting LANG, LC_* and GIT_PROTOCOL", t, func() {
+		s := &Server{acceptEnv: parseAcceptEnv([]string{"LANG LC_*", "GIT_PROTOCOL"})}
+
+		Convey("Listed variables are accepted", func() {
+			So(s.acceptsEnv("LANG"), ShouldBeTrue)
+			So(s.acceptsEnv("LC_ALL"), ShouldBeTrue)
+			So(s.acceptsEnv("GIT_PROTOCOL"), ShouldBeTrue)
+		})
+
+		Convey("Other variables are rejected", func() {
+			So(s.acceptsEnv("LD_PRELOAD"), ShouldBeFalse)
+			So(s.acceptsEnv("PATH"), ShouldBeFalse)
+			So(s.acceptsEnv("LANGUAGE"), ShouldBeFalse)
+			So(s.acceptsEnv("LC_ALL=C"), ShouldBeFalse)
+			So(s.acceptsEnv(""), ShouldBeFalse)
+		})
+	})
+
+	Convey("A server without AcceptEnv rejects all variables", t, func() {
+		s := &Server{}
+		So(s.acceptsEnv("LANG"), ShouldBeFalse)
+	})
+}
+
+func TestSignalName(t *testing.T) {
+	Convey("Signals are named as in RFC 4254", t, func() {
+		So(signalName(syscall.SIGTERM), ShouldEqual, "TERM")
+		So(signalName(syscall.SIGKILL), ShouldEqual, "KILL")
+		So(signals["INT"], ShouldEqual, syscall.SIGINT)
+	})
+}
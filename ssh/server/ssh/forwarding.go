@@ -0,0 +1,295 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"sync"
+
+	log "github.com/inconshreveable/log15"
+	"github.com/scionproto/scion/go/lib/snet"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/netsec-ethz/scion-apps/ssh/quicconn"
+)
+
+// remoteForwardRequest is the payload of tcpip-forward and scionquic-forward
+// requests (and their cancel- counterparts), see RFC 4254, section 7.1.
+type remoteForwardRequest struct {
+	BindAddr string
+	BindPort uint32
+}
+
+// remoteForwardSuccess is the reply to a forward request for port 0.
+type remoteForwardSuccess struct {
+	BindPort uint32
+}
+
+// forwardedChannelData is the payload of forwarded-tcpip and
+// forwarded-scionquic channels, see RFC 4254, section 7.2.
+type forwardedChannelData struct {
+	Addr       string
+	Port       uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+// remoteForwarding describes a kind of remote forwarding, i.e. the global
+// requests to start and cancel it, the channel type over which connections are
+// forwarded to the client, and how to listen for those connections.
+type remoteForwarding struct {
+	request       string
+	cancelRequest string
+	channelType   string
+	listen        func(f *forwardings, bindAddr string, bindPort uint32) (net.Listener, error)
+}
+
+var (
+	tcpForwarding = &remoteForwarding{
+		request:       "tcpip-forward",
+		cancelRequest: "cancel-tcpip-forward",
+		channelType:   "forwarded-tcpip",
+		listen:        (*forwardings).listenTCP,
+	}
+	scionQUICForwarding = &remoteForwarding{
+		request:       "scionquic-forward",
+		cancelRequest: "cancel-scionquic-forward",
+		channelType:   "forwarded-scionquic",
+		listen:        (*forwardings).listenSCIONQUIC,
+	}
+)
+
+// maxPrivilegedPort is the highest port only root may forward, as in OpenSSH.
+const maxPrivilegedPort = 1023
+
+// forwardings keeps track of the remote forwardings requested by a client
+// over a single connection.
+type forwardings struct {
+	conn         ssh.Conn
+	gatewayPorts bool
+	permitted    bool
+	// privileged is set if the user may forward ports up to maxPrivilegedPort
+	privileged bool
+	// onStart is called with the request and address of started forwardings
+	onStart func(forward string)
+
+	mutex     sync.Mutex
+	listeners map[string]net.Listener
+}
+
+func newForwardings(conn ssh.Conn, gatewayPorts, permitted, privileged bool) *forwardings {
+	return &forwardings{
+		conn:         conn,
+		gatewayPorts: gatewayPorts,
+		permitted:    permitted,
+		privileged:   privileged,
+		listeners:    make(map[string]net.Listener),
+	}
+}
+
+// handleRequests handles the global requests of a connection. Forwarding
+// requests are served, all others are rejected.
+func (f *forwardings) handleRequests(reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		var err error
+		var reply []byte
+		switch req.Type {
+		case tcpForwarding.request:
+			reply, err = f.start(tcpForwarding, req.Payload)
+		case tcpForwarding.cancelRequest:
+			err = f.cancel(tcpForwarding, req.Payload)
+		case scionQUICForwarding.request:
+			reply, err = f.start(scionQUICForwarding, req.Payload)
+		case scionQUICForwarding.cancelRequest:
+			err = f.cancel(scionQUICForwarding, req.Payload)
+		default:
+			err = fmt.Errorf("unknown request type: %s", req.Type)
+		}
+		if err != nil {
+			log.Debug("Rejecting global request", "type", req.Type, "error", err)
+		}
+		if req.WantReply {
+			_ = req.Reply(err == nil, reply)
+		}
+	}
+}
+
+func (f *forwardings) start(kind *remoteForwarding, payload []byte) ([]byte, error) {
+	if !f.permitted {
+		return nil, fmt.Errorf("port forwarding is disabled")
+	}
+	var req remoteForwardRequest
+	if err := ssh.Unmarshal(payload, &req); err != nil {
+		return nil, err
+	}
+	if req.BindPort > 65535 {
+		return nil, fmt.Errorf("invalid port %d", req.BindPort)
+	}
+	if req.BindPort != 0 && req.BindPort <= maxPrivilegedPort && !f.privileged {
+		return nil, fmt.Errorf("only root may forward privileged port %d", req.BindPort)
+	}
+
+	listener, err := kind.listen(f, req.BindAddr, req.BindPort)
+	if err != nil {
+		return nil, err
+	}
+	port := listenerPort(listener.Addr())
+
+	f.mutex.Lock()
+	key := forwardingKey(kind, req.BindAddr, port)
+	if _, exists := f.listeners[key]; exists {
+		f.mutex.Unlock()
+		listener.Close()
+		return nil, fmt.Errorf("already forwarding %s:%d", req.BindAddr, port)
+	}
+	f.listeners[key] = listener
+	f.mutex.Unlock()
+
+	log.Debug("Starting remote forwarding", "type", kind.request, "address", listener.Addr())
+	if f.onStart != nil {
+		f.onStart(fmt.Sprintf("%s %s", kind.request, listener.Addr()))
+	}
+	go f.serve(kind, listener, req.BindAddr, port)
+
+	if req.BindPort == 0 {
+		return ssh.Marshal(&remoteForwardSuccess{BindPort: port}), nil
+	}
+	return nil, nil
+}
+
+func (f *forwardings) cancel(kind *remoteForwarding, payload []byte) error {
+	var req remoteForwardRequest
+	if err := ssh.Unmarshal(payload, &req); err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	key := forwardingKey(kind, req.BindAddr, req.BindPort)
+	listener, exists := f.listeners[key]
+	delete(f.listeners, key)
+	f.mutex.Unlock()
+
+	if !exists {
+		return fmt.Errorf("not forwarding %s:%d", req.BindAddr, req.BindPort)
+	}
+	log.Debug("Cancelling remote forwarding", "type", kind.request, "address", listener.Addr())
+	return listener.Close()
+}
+
+// closeAll stops all forwardings, e.g. when the connection is closed.
+func (f *forwardings) closeAll() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for key, listener := range f.listeners {
+		listener.Close()
+		delete(f.listeners, key)
+	}
+}
+
+func (f *forwardings) serve(kind *remoteForwarding, listener net.Listener, bindAddr string, bindPort uint32) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.Debug("Stopped accepting forwarded connections", "address", listener.Addr(), "error", err)
+			return
+		}
+		go f.forward(kind, conn, bindAddr, bindPort)
+	}
+}
+
+// forward opens a channel to the client for the given connection.
+func (f *forwardings) forward(kind *remoteForwarding, conn net.Conn, bindAddr string, bindPort uint32) {
+	originAddr, originPort := splitOrigin(conn.RemoteAddr())
+	data := forwardedChannelData{
+		Addr:       bindAddr,
+		Port:       bindPort,
+		OriginAddr: originAddr,
+		OriginPort: originPort,
+	}
+	connection, requests, err := f.conn.OpenChannel(kind.channelType, ssh.Marshal(&data))
+	if err != nil {
+		log.Debug("Could not open forwarded channel", "type", kind.channelType, "error", err)
+		conn.Close()
+		return
+	}
+
+	go ssh.DiscardRequests(requests)
+
+	handleTunnelForRemoteConnection(connection, conn)
+}
+
+func (f *forwardings) listenTCP(bindAddr string, bindPort uint32) (net.Listener, error) {
+	host := forwardBindHost(bindAddr, f.gatewayPorts)
+	return net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(int(bindPort))))
+}
+
+// listenSCIONQUIC listens for QUIC sessions on the server's SCION address.
+// The bind address is ignored. As this makes the port reachable from the whole
+// SCION network, it is only allowed with GatewayPorts.
+func (f *forwardings) listenSCIONQUIC(bindAddr string, bindPort uint32) (net.Listener, error) {
+	if !f.gatewayPorts {
+		return nil, errors.New("SCION forwarding requires GatewayPorts")
+	}
+	return quicconn.Listen(uint16(bindPort))
+}
+
+// forwardBindHost returns the host to listen on for a requested bind address.
+// Unless gatewayPorts is set, forwarded ports are only bound to loopback, as in
+// OpenSSH.
+func forwardBindHost(bindAddr string, gatewayPorts bool) string {
+	if !gatewayPorts {
+		return "localhost"
+	}
+	switch bindAddr {
+	case "", "*", "0.0.0.0", "::":
+		return ""
+	default:
+		return bindAddr
+	}
+}
+
+func forwardingKey(kind *remoteForwarding, bindAddr string, bindPort uint32) string {
+	return fmt.Sprintf("%s %s:%d", kind.request, bindAddr, bindPort)
+}
+
+func listenerPort(addr net.Addr) uint32 {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return uint32(a.Port)
+	case *snet.UDPAddr:
+		return uint32(a.Host.Port)
+	case *net.UDPAddr:
+		return uint32(a.Port)
+	}
+	return 0
+}
+
+// splitOrigin returns the originator address and port of a forwarded
+// connection. SCION addresses are returned as ISD-AS,[IP].
+func splitOrigin(addr net.Addr) (string, uint32) {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP.String(), uint32(a.Port)
+	case *snet.UDPAddr:
+		return fmt.Sprintf("%s,[%s]", a.IA, a.Host.IP), uint32(a.Host.Port)
+	case *net.UDPAddr:
+		return a.IP.String(), uint32(a.Port)
+	}
+	return addr.String(), 0
+}
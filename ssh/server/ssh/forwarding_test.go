@@ -0,0 +1,108 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"net"
+	"testing"
+
+	"github.com/scionproto/scion/go/lib/snet"
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestForwardBindHost(t *testing.T) {
+	Convey("Without GatewayPorts, forwarded ports are bound to loopback", t, func() {
+		So(forwardBindHost("", false), ShouldEqual, "localhost")
+		So(forwardBindHost("0.0.0.0", false), ShouldEqual, "localhost")
+		So(forwardBindHost("192.0.2.1", false), ShouldEqual, "localhost")
+	})
+
+	Convey("With GatewayPorts, the requested address is used", t, func() {
+		So(forwardBindHost("", true), ShouldEqual, "")
+		So(forwardBindHost("*", true), ShouldEqual, "")
+		So(forwardBindHost("0.0.0.0", true), ShouldEqual, "")
+		So(forwardBindHost("localhost", true), ShouldEqual, "localhost")
+		So(forwardBindHost("192.0.2.1", true), ShouldEqual, "192.0.2.1")
+	})
+}
+
+func TestSplitOrigin(t *testing.T) {
+	Convey("Given the remote address of a TCP connection", t, func() {
+		addr, port := splitOrigin(&net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 4242})
+		So(addr, ShouldEqual, "192.0.2.1")
+		So(port, ShouldEqual, 4242)
+	})
+
+	Convey("Given the remote address of a SCION connection", t, func() {
+		remote, err := snet.ParseUDPAddr("1-ff00:0:110,[10.0.0.1]:4242")
+		So(err, ShouldBeNil)
+		addr, port := splitOrigin(remote)
+		So(addr, ShouldEqual, "1-ff00:0:110,[10.0.0.1]")
+		So(port, ShouldEqual, 4242)
+	})
+}
+
+func TestRemoteForwarding(t *testing.T) {
+	Convey("Given the forwardings of a connection", t, func() {
+		f := newForwardings(nil, false, true, false)
+		payload := func(port uint32) []byte {
+			return ssh.Marshal(&remoteForwardRequest{BindAddr: "localhost", BindPort: port})
+		}
+
+		Convey("Forwarding port 0 replies with the chosen port", func() {
+			reply, err := f.start(tcpForwarding, payload(0))
+			So(err, ShouldBeNil)
+			var success remoteForwardSuccess
+			So(ssh.Unmarshal(reply, &success), ShouldBeNil)
+			So(success.BindPort, ShouldNotEqual, 0)
+			So(len(f.listeners), ShouldEqual, 1)
+
+			Convey("The forwarding can be cancelled with the chosen port", func() {
+				So(f.cancel(tcpForwarding, payload(success.BindPort)), ShouldBeNil)
+				So(len(f.listeners), ShouldEqual, 0)
+				So(f.cancel(tcpForwarding, payload(success.BindPort)), ShouldNotBeNil)
+			})
+		})
+
+		Convey("Closing stops all forwardings", func() {
+			_, err := f.start(tcpForwarding, payload(0))
+			So(err, ShouldBeNil)
+			f.closeAll()
+			So(len(f.listeners), ShouldEqual, 0)
+		})
+
+		Convey("Privileged ports are rejected for other users than root", func() {
+			_, err := f.start(tcpForwarding, payload(80))
+			So(err, ShouldNotBeNil)
+			So(len(f.listeners), ShouldEqual, 0)
+		})
+
+		Convey("SCION forwardings are rejected without GatewayPorts", func() {
+			_, err := f.start(scionQUICForwarding, payload(0))
+			So(err, ShouldNotBeNil)
+			So(len(f.listeners), ShouldEqual, 0)
+		})
+
+		Reset(f.closeAll)
+	})
+
+	Convey("Forwardings are rejected if port forwarding is disabled", t, func() {
+		f := newForwardings(nil, false, false, false)
+		_, err := f.start(tcpForwarding, ssh.Marshal(&remoteForwardRequest{BindAddr: "localhost", BindPort: 0}))
+		So(err, ShouldNotBeNil)
+		So(len(f.listeners), ShouldEqual, 0)
+	})
+}
@@ -0,0 +1,357 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/netsec-ethz/scion-apps/ssh/server/serverconfig"
+)
+
+// maxRateLimitKeys is the number of sources or IAs above which idle rate
+// limits are dropped.
+const maxRateLimitKeys = 4096
+
+var (
+	errTooManyConnections = errors.New("too many connections")
+	errTooManyStartups    = errors.New("too many unauthenticated connections")
+	errRateLimited        = errors.New("connection rate exceeded")
+	errBanned             = errors.New("source banned after repeated authentication failures")
+)
+
+// maxStartups is the MaxStartups option. Beyond start unauthenticated
+// connections, new connections are refused with a probability of rate
+// percent, increasing linearly up to 100 percent at full connections.
+type maxStartups struct {
+	start int
+	rate  int
+	full  int
+}
+
+// parseMaxStartups parses MaxStartups, either "start:rate:full" or a single
+// number of unauthenticated connections beyond which all are refused.
+func parseMaxStartups(option string) (maxStartups, error) {
+	fields := strings.Split(option, ":")
+	values := make([]int, len(fields))
+	for i, field := range fields {
+		v, err := strconv.Atoi(field)
+		if err != nil || v < 0 {
+			return maxStartups{}, fmt.Errorf("invalid MaxStartups %q", option)
+		}
+		values[i] = v
+	}
+	switch len(values) {
+	case 1:
+		return maxStartups{start: values[0], rate: 100, full: values[0]}, nil
+	case 3:
+		if values[0] > values[2] || values[1] > 100 {
+			return maxStartups{}, fmt.Errorf("invalid MaxStartups %q", option)
+		}
+		return maxStartups{start: values[0], rate: values[1], full: values[2]}, nil
+	}
+	return maxStartups{}, fmt.Errorf("invalid MaxStartups %q", option)
+}
+
+// refuse returns whether a new connection is refused when there are
+// startups unauthenticated connections. random returns a number in [0,100).
+func (m maxStartups) refuse(startups int, random func() int) bool {
+	if startups < m.start {
+		return false
+	}
+	if startups >= m.full {
+		return true
+	}
+	p := m.rate + (100-m.rate)*(startups-m.start)/(m.full-m.start)
+	return random() < p
+}
+
+// rateLimit allows a number of connections per interval, with bursts of up
+// to the same number. A nil rateLimit allows everything.
+type rateLimit struct {
+	count    int
+	interval time.Duration
+	buckets  map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// parseRateLimit parses a rate limit of the form "count/interval", such as
+// "10/1m", or "none".
+func parseRateLimit(option string) (*rateLimit, error) {
+	if option == "none" {
+		return nil, nil
+	}
+	parts := strings.SplitN(option, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid rate limit %q", option)
+	}
+	count, err := strconv.Atoi(parts[0])
+	if err != nil || count <= 0 {
+		return nil, fmt.Errorf("invalid rate limit %q", option)
+	}
+	interval, err := time.ParseDuration(parts[1])
+	if err != nil || interval <= 0 {
+		return nil, fmt.Errorf("invalid rate limit %q", option)
+	}
+	return &rateLimit{count: count, interval: interval, buckets: make(map[string]*bucket)}, nil
+}
+
+// allow takes a token from the bucket of key, if there is one.
+func (r *rateLimit) allow(key string, now time.Time) bool {
+	if r == nil {
+		return true
+	}
+	if len(r.buckets) > maxRateLimitKeys {
+		for k, b := range r.buckets {
+			if now.Sub(b.last) >= r.interval {
+				delete(r.buckets, k)
+			}
+		}
+	}
+
+	b, ok := r.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(r.count), last: now}
+		r.buckets[key] = b
+	}
+	b.tokens += float64(r.count) * float64(now.Sub(b.last)) / float64(r.interval)
+	if b.tokens > float64(r.count) {
+		b.tokens = float64(r.count)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// connectionLimits limits the number of concurrent connections and the rate
+// of new connections, and bans sources whose connections repeatedly ended
+// with failed authentication attempts only. Sources are identified by their
+// IA and IP address.
+type connectionLimits struct {
+	maxConnections       int
+	maxStartups          maxStartups
+	perSourceMaxStartups int
+	sourceRate           *rateLimit
+	iaRate               *rateLimit
+	banAfterAuthFailures int
+	banTime              time.Duration
+
+	now    func() time.Time
+	random func() int
+
+	mutex          sync.Mutex
+	connections    int
+	startups       int
+	sourceStartups map[string]int
+	authenticating map[string]*Admission
+	failures       map[string][]time.Time
+	bans           map[string]time.Time
+}
+
+func newConnectionLimits() *connectionLimits {
+	return &connectionLimits{
+		maxStartups:    maxStartups{start: 10, rate: 30, full: 100},
+		now:            time.Now,
+		random:         func() int { return rand.Intn(100) },
+		sourceStartups: make(map[string]int),
+		authenticating: make(map[string]*Admission),
+		failures:       make(map[string][]time.Time),
+		bans:           make(map[string]time.Time),
+	}
+}
+
+// configure sets the limits from the server configuration.
+func (l *connectionLimits) configure(config *serverconfig.ServerConfig) error {
+	var err error
+	l.maxStartups, err = parseMaxStartups(config.MaxStartups)
+	if err != nil {
+		return err
+	}
+	l.maxConnections, err = parseOptionalCount("MaxConnections", config.MaxConnections)
+	if err != nil {
+		return err
+	}
+	l.perSourceMaxStartups, err = parseOptionalCount("PerSourceMaxStartups", config.PerSourceMaxStartups)
+	if err != nil {
+		return err
+	}
+	l.banAfterAuthFailures, err = parseOptionalCount("BanAfterAuthFailures", config.BanAfterAuthFailures)
+	if err != nil {
+		return err
+	}
+	l.banTime = config.BanTime
+	l.sourceRate, err = parseRateLimit(config.PerSourceRateLimit)
+	if err != nil {
+		return err
+	}
+	l.iaRate, err = parseRateLimit(config.PerIARateLimit)
+	return err
+}
+
+// parseOptionalCount parses the option name, a positive number or "none",
+// which is 0.
+func parseOptionalCount(name, option string) (int, error) {
+	if option == "none" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(option)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid %s %q", name, option)
+	}
+	return n, nil
+}
+
+// Admission is a connection admitted by the limits of the server. It counts
+// as unauthenticated until authenticated is called, and against the limits
+// until it is closed.
+type Admission struct {
+	limits         *connectionLimits
+	source         string
+	remote         string
+	authenticating bool
+	authFailed     bool
+	closed         bool
+}
+
+// admit checks whether a new connection from addr is within the limits.
+func (l *connectionLimits) admit(addr net.Addr) (*Admission, error) {
+	ia, ip := splitRemoteAddr(addr)
+	source := ia + "," + ip.String()
+	now := l.now()
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if until, banned := l.bans[source]; banned {
+		if now.Before(until) {
+			return nil, errBanned
+		}
+		delete(l.bans, source)
+	}
+	if l.maxConnections > 0 && l.connections >= l.maxConnections {
+		return nil, errTooManyConnections
+	}
+	if l.maxStartups.refuse(l.startups, l.random) {
+		return nil, errTooManyStartups
+	}
+	if l.perSourceMaxStartups > 0 && l.sourceStartups[source] >= l.perSourceMaxStartups {
+		return nil, errTooManyStartups
+	}
+	if !l.sourceRate.allow(source, now) || (ia != "" && !l.iaRate.allow(ia, now)) {
+		return nil, errRateLimited
+	}
+
+	a := &Admission{limits: l, source: source, remote: addr.String(), authenticating: true}
+	l.connections++
+	l.startups++
+	l.sourceStartups[source]++
+	l.authenticating[a.remote] = a
+	return a, nil
+}
+
+// authFailed records a failed authentication attempt of the connection from
+// addr.
+func (l *connectionLimits) authFailed(addr net.Addr) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if a, ok := l.authenticating[addr.String()]; ok {
+		a.authFailed = true
+	}
+}
+
+// recordFailure counts a connection of source that ended with failed
+// authentication attempts only, and bans the source if this happened too
+// often within the ban time.
+func (l *connectionLimits) recordFailure(source string) {
+	if l.banAfterAuthFailures <= 0 {
+		return
+	}
+	now := l.now()
+	for s, failures := range l.failures {
+		if now.Sub(failures[len(failures)-1]) >= l.banTime {
+			delete(l.failures, s)
+		}
+	}
+
+	failures := l.failures[source]
+	for len(failures) > 0 && now.Sub(failures[0]) >= l.banTime {
+		failures = failures[1:]
+	}
+	failures = append(failures, now)
+	if len(failures) >= l.banAfterAuthFailures {
+		l.bans[source] = now.Add(l.banTime)
+		delete(l.failures, source)
+		return
+	}
+	l.failures[source] = failures
+}
+
+// authenticated marks the connection as authenticated.
+func (a *Admission) authenticated() {
+	a.limits.mutex.Lock()
+	defer a.limits.mutex.Unlock()
+	a.endStartup()
+}
+
+func (a *Admission) endStartup() {
+	if !a.authenticating {
+		return
+	}
+	a.authenticating = false
+	delete(a.limits.authenticating, a.remote)
+	a.limits.startups--
+	a.limits.sourceStartups[a.source]--
+	if a.limits.sourceStartups[a.source] <= 0 {
+		delete(a.limits.sourceStartups, a.source)
+	}
+}
+
+// Close releases the connection from the limits.
+func (a *Admission) Close() {
+	a.limits.mutex.Lock()
+	defer a.limits.mutex.Unlock()
+	if a.closed {
+		return
+	}
+	a.closed = true
+	if a.authenticating && a.authFailed {
+		a.limits.recordFailure(a.source)
+	}
+	a.endStartup()
+	a.limits.connections--
+}
+
+// logAuth is called after each authentication attempt.
+func (s *Server) logAuth(c ssh.ConnMetadata, method string, err error) {
+	s.auditAuth(c, method, err)
+	if err != nil && err != errPartialSuccess && method != "none" {
+		s.limits.authFailed(c.RemoteAddr())
+	}
+}
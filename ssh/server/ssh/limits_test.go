@@ -0,0 +1,210 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/scionproto/scion/go/lib/addr"
+	"github.com/scionproto/scion/go/lib/snet"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"github.com/netsec-ethz/scion-apps/ssh/server/serverconfig"
+)
+
+func TestParseMaxStartups(t *testing.T) {
+	Convey("MaxStartups is parsed", t, func() {
+		m, err := parseMaxStartups("10:30:100")
+		So(err, ShouldBeNil)
+		So(m, ShouldResemble, maxStartups{start: 10, rate: 30, full: 100})
+
+		m, err = parseMaxStartups("5")
+		So(err, ShouldBeNil)
+		So(m, ShouldResemble, maxStartups{start: 5, rate: 100, full: 5})
+
+		for _, invalid := range []string{"", "10:30", "10:130:100", "100:30:10", "a:b:c"} {
+			_, err = parseMaxStartups(invalid)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Connections are refused with increasing probability", t, func() {
+		m := maxStartups{start: 10, rate: 30, full: 20}
+		random := func(n int) func() int { return func() int { return n } }
+		So(m.refuse(9, random(0)), ShouldBeFalse)
+		So(m.refuse(10, random(29)), ShouldBeTrue)
+		So(m.refuse(10, random(30)), ShouldBeFalse)
+		So(m.refuse(15, random(64)), ShouldBeTrue)
+		So(m.refuse(15, random(65)), ShouldBeFalse)
+		So(m.refuse(20, random(99)), ShouldBeTrue)
+	})
+}
+
+func TestParseOptionalCount(t *testing.T) {
+	Convey("Optional counts are parsed", t, func() {
+		n, err := parseOptionalCount("MaxConnections", "none")
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 0)
+
+		n, err = parseOptionalCount("MaxConnections", "10")
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 10)
+
+		for _, invalid := range []string{"", "0", "-1", "10x", "unlimited"} {
+			_, err = parseOptionalCount("MaxConnections", invalid)
+			So(err, ShouldNotBeNil)
+		}
+	})
+
+	Convey("Invalid limits are an error", t, func() {
+		conf := serverconfig.Create()
+		So(newConnectionLimits().configure(conf), ShouldBeNil)
+
+		invalidOptions := []func(*serverconfig.ServerConfig){
+			func(c *serverconfig.ServerConfig) { c.MaxConnections = "0" },
+			func(c *serverconfig.ServerConfig) { c.PerSourceMaxStartups = "abc" },
+			func(c *serverconfig.ServerConfig) { c.BanAfterAuthFailures = "" },
+		}
+		for _, setInvalid := range invalidOptions {
+			conf := serverconfig.Create()
+			setInvalid(conf)
+			So(newConnectionLimits().configure(conf), ShouldNotBeNil)
+		}
+	})
+}
+
+func TestRateLimit(t *testing.T) {
+	Convey("Given a rate limit of 2 connections per minute", t, func() {
+		r, err := parseRateLimit("2/1m")
+		So(err, ShouldBeNil)
+		now := time.Now()
+
+		Convey("Bursts are limited, and tokens are refilled over time", func() {
+			So(r.allow("a", now), ShouldBeTrue)
+			So(r.allow("a", now), ShouldBeTrue)
+			So(r.allow("a", now), ShouldBeFalse)
+			So(r.allow("b", now), ShouldBeTrue)
+			So(r.allow("a", now.Add(20*time.Second)), ShouldBeFalse)
+			So(r.allow("a", now.Add(30*time.Second)), ShouldBeTrue)
+		})
+	})
+
+	Convey("Rate limits are parsed", t, func() {
+		r, err := parseRateLimit("none")
+		So(err, ShouldBeNil)
+		So(r.allow("a", time.Now()), ShouldBeTrue)
+		for _, invalid := range []string{"10", "0/1m", "10/x", "10/-1s"} {
+			_, err = parseRateLimit(invalid)
+			So(err, ShouldNotBeNil)
+		}
+	})
+}
+
+func TestConnectionLimits(t *testing.T) {
+	Convey("Given connection limits", t, func() {
+		now := time.Now()
+		l := newConnectionLimits()
+		l.now = func() time.Time { return now }
+		l.maxStartups = maxStartups{start: 100, rate: 100, full: 100}
+
+		ia, _ := addr.IAFromString("1-ffaa:1:abc")
+		newAddr := func(ip string, port int) net.Addr {
+			return &snet.UDPAddr{IA: ia, Host: &net.UDPAddr{IP: net.ParseIP(ip), Port: port}}
+		}
+
+		Convey("Unauthenticated connections per source are limited", func() {
+			l.perSourceMaxStartups = 1
+			a, err := l.admit(newAddr("10.0.0.1", 1000))
+			So(err, ShouldBeNil)
+			_, err = l.admit(newAddr("10.0.0.1", 1001))
+			So(err, ShouldEqual, errTooManyStartups)
+			_, err = l.admit(newAddr("10.0.0.2", 1000))
+			So(err, ShouldBeNil)
+
+			a.authenticated()
+			_, err = l.admit(newAddr("10.0.0.1", 1001))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Concurrent connections are limited", func() {
+			l.maxConnections = 1
+			a, err := l.admit(newAddr("10.0.0.1", 1000))
+			So(err, ShouldBeNil)
+			a.authenticated()
+			_, err = l.admit(newAddr("10.0.0.2", 1000))
+			So(err, ShouldEqual, errTooManyConnections)
+			a.Close()
+			a.Close()
+			_, err = l.admit(newAddr("10.0.0.2", 1000))
+			So(err, ShouldBeNil)
+		})
+
+		Convey("New connections per IA are rate limited", func() {
+			l.iaRate, _ = parseRateLimit("1/1h")
+			_, err := l.admit(newAddr("10.0.0.1", 1000))
+			So(err, ShouldBeNil)
+			_, err = l.admit(newAddr("10.0.0.2", 1000))
+			So(err, ShouldEqual, errRateLimited)
+		})
+
+		Convey("Sources are banned after repeated failed connections", func() {
+			l.banAfterAuthFailures = 2
+			l.banTime = time.Minute
+			fail := func(port int) {
+				remote := newAddr("10.0.0.1", port)
+				a, err := l.admit(remote)
+				So(err, ShouldBeNil)
+				l.authFailed(remote)
+				a.Close()
+			}
+
+			fail(1000)
+			// Failed attempts of authenticated connections don't count
+			remote := newAddr("10.0.0.1", 1001)
+			a, _ := l.admit(remote)
+			l.authFailed(remote)
+			a.authenticated()
+			a.Close()
+			_, err := l.admit(newAddr("10.0.0.1", 1002))
+			So(err, ShouldBeNil)
+
+			fail(1003)
+			_, err = l.admit(newAddr("10.0.0.1", 1004))
+			So(err, ShouldEqual, errBanned)
+			_, err = l.admit(newAddr("10.0.0.2", 1000))
+			So(err, ShouldBeNil)
+
+			now = now.Add(time.Minute)
+			_, err = l.admit(newAddr("10.0.0.1", 1004))
+			So(err, ShouldBeNil)
+		})
+	})
+
+	Convey("Only failed authentication attempts are counted", t, func() {
+		s := &Server{limits: newConnectionLimits()}
+		remote := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1000}
+		a, err := s.limits.admit(remote)
+		So(err, ShouldBeNil)
+		conn := testConnMetadata{user: "alice", remoteAddr: remote}
+		s.logAuth(conn, "none", errors.New("no auth passed yet"))
+		s.logAuth(conn, "keyboard-interactive", errPartialSuccess)
+		So(a.authFailed, ShouldBeFalse)
+		s.logAuth(conn, "password", errors.New("authenticate: failed"))
+		So(a.authFailed, ShouldBeTrue)
+	})
+}
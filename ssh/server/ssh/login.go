@@ -0,0 +1,145 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	passwdFile   = "/etc/passwd"
+	defaultShell = "/bin/sh"
+)
+
+// sessionUser returns the user a session was authenticated as.
+func sessionUser(perms *ssh.Permissions) (*user.User, error) {
+	username, ok := perms.CriticalOptions["user"]
+	if !ok {
+		return user.Current()
+	}
+	return user.Lookup(username)
+}
+
+// userCredential returns the credential to run processes as usr, with all its
+// supplementary groups.
+func userCredential(usr *user.User) (*syscall.Credential, error) {
+	uid, err := strconv.ParseUint(usr.Uid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	gid, err := strconv.ParseUint(usr.Gid, 10, 32)
+	if err != nil {
+		return nil, err
+	}
+	groupIds, err := usr.GroupIds()
+	if err != nil {
+		return nil, err
+	}
+	groups := make([]uint32, 0, len(groupIds))
+	for _, groupID := range groupIds {
+		group, err := strconv.ParseUint(groupID, 10, 32)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, uint32(group))
+	}
+	return &syscall.Credential{
+		Uid:    uint32(uid),
+		Gid:    uint32(gid),
+		Groups: groups,
+	}, nil
+}
+
+// loginShell returns the login shell of usr, falling back to /bin/sh if the
+// passwd entry doesn't list one.
+func loginShell(usr *user.User) string {
+	shell, err := lookupShell(passwdFile, usr.Username)
+	if err != nil || shell == "" {
+		return defaultShell
+	}
+	return shell
+}
+
+// lookupShell returns the login shell of the user username, as listed in the
+// passwd file at passwdPath.
+func lookupShell(passwdPath, username string) (string, error) {
+	file, err := os.Open(passwdPath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		// name:password:UID:GID:GECOS:directory:shell
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) == 7 && fields[0] == username {
+			return fields[6], nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", user.UnknownUserError(username)
+}
+
+// expandUserPath replaces the tokens %h (home directory), %u (user name) and
+// %% in pth.
+func expandUserPath(pth string, usr *user.User) string {
+	return strings.NewReplacer("%%", "%", "%h", usr.HomeDir, "%u", usr.Username).Replace(pth)
+}
+
+// checkChrootDirectory checks that dir can safely be used as root directory:
+// it and all its parents must be owned by root and not writable by others.
+func checkChrootDirectory(dir string) error {
+	if !filepath.IsAbs(dir) {
+		return fmt.Errorf("chroot directory %s is not an absolute path", dir)
+	}
+	for pth := filepath.Clean(dir); ; pth = filepath.Dir(pth) {
+		info, err := os.Stat(pth)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("chroot path %s is not a directory", pth)
+		}
+		stat, ok := info.Sys().(*syscall.Stat_t)
+		if !ok || stat.Uid != 0 || info.Mode().Perm()&0022 != 0 {
+			return fmt.Errorf("bad ownership or modes for chroot directory component %s", pth)
+		}
+		if pth == "/" {
+			return nil
+		}
+	}
+}
+
+// workingDirectory returns the directory a session starts in: the home
+// directory if it exists below root, and the root directory otherwise.
+func workingDirectory(root, home string) string {
+	info, err := os.Stat(filepath.Join("/", root, home))
+	if err != nil || !info.IsDir() {
+		return "/"
+	}
+	return home
+}
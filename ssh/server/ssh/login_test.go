@@ -0,0 +1,107 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLookupShell(t *testing.T) {
+	Convey("Given a passwd file", t, func() {
+		file, err := ioutil.TempFile("", "passwd")
+		So(err, ShouldBeNil)
+		defer os.Remove(file.Name())
+		_, err = file.WriteString("root:x:0:0:root:/root:/bin/bash\nalice:x:1000:1000:Alice:/home/alice:/usr/bin/zsh\n")
+		So(err, ShouldBeNil)
+		file.Close()
+
+		Convey("The shell of a listed user is found", func() {
+			shell, err := lookupShell(file.Name(), "alice")
+			So(err, ShouldBeNil)
+			So(shell, ShouldEqual, "/usr/bin/zsh")
+		})
+
+		Convey("An unknown user is an error", func() {
+			_, err := lookupShell(file.Name(), "bob")
+			So(err, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestExpandUserPath(t *testing.T) {
+	Convey("Tokens are replaced by the user's values", t, func() {
+		usr := &user.User{Username: "alice", HomeDir: "/home/alice"}
+		So(expandUserPath("%h/.ssh/authorized_keys", usr), ShouldEqual, "/home/alice/.ssh/authorized_keys")
+		So(expandUserPath("/srv/chroot/%u", usr), ShouldEqual, "/srv/chroot/alice")
+		So(expandUserPath("/tmp/100%%", usr), ShouldEqual, "/tmp/100%")
+	})
+}
+
+func TestUserCredential(t *testing.T) {
+	Convey("The credential of the current user includes its groups", t, func() {
+		usr, err := user.Current()
+		So(err, ShouldBeNil)
+		credential, err := userCredential(usr)
+		So(err, ShouldBeNil)
+		So(credential.Uid, ShouldEqual, os.Getuid())
+		So(credential.Gid, ShouldEqual, os.Getgid())
+		So(len(credential.Groups), ShouldBeGreaterThan, 0)
+	})
+}
+
+func TestCheckChrootDirectory(t *testing.T) {
+	Convey("Relative chroot directories are rejected", t, func() {
+		So(checkChrootDirectory("chroot"), ShouldNotBeNil)
+	})
+
+	Convey("Missing chroot directories are rejected", t, func() {
+		So(checkChrootDirectory("/nonexistent/chroot"), ShouldNotBeNil)
+	})
+
+	Convey("The root directory is accepted", t, func() {
+		So(checkChrootDirectory("/"), ShouldBeNil)
+	})
+
+	Convey("Directories writable by others are rejected", t, func() {
+		dir, err := ioutil.TempDir("", "chroot")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		So(os.Chmod(dir, 0777), ShouldBeNil)
+		So(checkChrootDirectory(dir), ShouldNotBeNil)
+	})
+}
+
+func TestWorkingDirectory(t *testing.T) {
+	Convey("Given a root with a home directory", t, func() {
+		root, err := ioutil.TempDir("", "root")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(root)
+		So(os.MkdirAll(filepath.Join(root, "home", "alice"), 0755), ShouldBeNil)
+
+		Convey("Sessions start in the home directory if it exists", func() {
+			So(workingDirectory(root, "/home/alice"), ShouldEqual, "/home/alice")
+		})
+
+		Convey("Sessions start in the root directory otherwise", func() {
+			So(workingDirectory(root, "/home/bob"), ShouldEqual, "/")
+		})
+	})
+}
@@ -0,0 +1,135 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	log "github.com/inconshreveable/log15"
+)
+
+// asciicastHeader is the first line of a recording in the asciicast v2
+// format, followed by one JSON array [time, type, data] per event.
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     uint32            `json:"width"`
+	Height    uint32            `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// sessionRecording records the output of a pty session. Errors are logged
+// and end the recording, but never affect the session itself.
+type sessionRecording struct {
+	mutex   sync.Mutex
+	w       io.WriteCloser
+	encoder *json.Encoder
+	start   time.Time
+	pending []byte
+	failed  bool
+}
+
+// newSessionRecording creates a recording in a new file in dir, named after
+// the user and the start time.
+func newSessionRecording(dir, username string, header asciicastHeader) (*sessionRecording, string, error) {
+	start := time.Now()
+	f, err := ioutil.TempFile(dir, fmt.Sprintf("%s-%s-*.cast", username, start.Format("20060102-150405")))
+	if err != nil {
+		return nil, "", err
+	}
+	r, err := startRecording(f, start, header)
+	if err != nil {
+		f.Close()
+		return nil, "", err
+	}
+	return r, f.Name(), nil
+}
+
+func startRecording(w io.WriteCloser, start time.Time, header asciicastHeader) (*sessionRecording, error) {
+	header.Version = 2
+	header.Timestamp = start.Unix()
+	r := &sessionRecording{
+		w:       w,
+		encoder: json.NewEncoder(w),
+		start:   start,
+	}
+	if err := r.encoder.Encode(header); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write records p as output of the session. It always succeeds, so that it
+// can be used in an io.MultiWriter with the channel.
+func (r *sessionRecording) Write(p []byte) (int, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	// Keep an incomplete UTF-8 sequence at the end for the next write, as
+	// each event must contain valid UTF-8
+	data := append(r.pending, p...)
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	r.pending = append([]byte(nil), data[cut:]...)
+	if cut > 0 {
+		r.event("o", string(data[:cut]))
+	}
+	return len(p), nil
+}
+
+// resize records a change of the terminal size.
+func (r *sessionRecording) resize(width, height uint32) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.event("r", fmt.Sprintf("%dx%d", width, height))
+}
+
+func (r *sessionRecording) event(kind, data string) {
+	if r.failed {
+		return
+	}
+	elapsed := math.Round(time.Since(r.start).Seconds()*1e6) / 1e6
+	if err := r.encoder.Encode([]interface{}{elapsed, kind, data}); err != nil {
+		log.Error("Failed writing session recording, stopped recording", "error", err)
+		r.failed = true
+	}
+}
+
+// Close flushes pending output and closes the recording.
+func (r *sessionRecording) Close() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if len(r.pending) > 0 {
+		r.event("o", string(r.pending))
+		r.pending = nil
+	}
+	return r.w.Close()
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSessionRecording(t *testing.T) {
+	Convey("Given a session recording", t, func() {
+		dir, err := ioutil.TempDir("", "recording")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+
+		r, file, err := newSessionRecording(dir, "alice", asciicastHeader{Width: 80, Height: 24, Command: "top"})
+		So(err, ShouldBeNil)
+		So(filepath.Dir(file), ShouldEqual, dir)
+		So(filepath.Base(file), ShouldStartWith, "alice-")
+
+		Convey("The output is recorded in the asciicast v2 format", func() {
+			n, err := r.Write([]byte("h\xc3"))
+			So(n, ShouldEqual, 2)
+			So(err, ShouldBeNil)
+			r.Write([]byte("\xa9llo\r\n"))
+			r.resize(100, 40)
+			r.Write([]byte("\xe2\x82"))
+			So(r.Close(), ShouldBeNil)
+
+			f, err := os.Open(file)
+			So(err, ShouldBeNil)
+			defer f.Close()
+			scanner := bufio.NewScanner(f)
+
+			So(scanner.Scan(), ShouldBeTrue)
+			var header asciicastHeader
+			So(json.Unmarshal(scanner.Bytes(), &header), ShouldBeNil)
+			So(header.Version, ShouldEqual, 2)
+			So(header.Width, ShouldEqual, 80)
+			So(header.Height, ShouldEqual, 24)
+			So(header.Command, ShouldEqual, "top")
+			So(header.Timestamp, ShouldBeGreaterThan, 0)
+
+			var events [][]interface{}
+			for scanner.Scan() {
+				var event []interface{}
+				So(json.Unmarshal(scanner.Bytes(), &event), ShouldBeNil)
+				events = append(events, event)
+			}
+			So(events, ShouldHaveLength, 4)
+			So(events[0][1:], ShouldResemble, []interface{}{"o", "h"})
+			So(events[1][1:], ShouldResemble, []interface{}{"o", "éllo\r\n"})
+			So(events[2][1:], ShouldResemble, []interface{}{"r", "100x40"})
+			// An incomplete sequence at the end is written as is
+			So(events[3][1], ShouldEqual, "o")
+			So(strings.ContainsRune(events[3][2].(string), '�'), ShouldBeTrue)
+		})
+	})
+}
@@ -16,13 +16,15 @@ package ssh
 
 import (
 	"encoding/binary"
+	"fmt"
 	"io"
 	"os"
 	"os/exec"
-	"os/user"
+	"path/filepath"
 	"strconv"
 	"sync"
 	"syscall"
+	"time"
 	"unsafe"
 
 	log "github.com/inconshreveable/log15"
@@ -31,7 +33,8 @@ import (
 	"golang.org/x/crypto/ssh"
 )
 
-func handleSession(perms *ssh.Permissions, newChannel ssh.NewChannel) {
+func (s *Server) handleSession(conn *ssh.ServerConn, newChannel ssh.NewChannel) {
+	perms := conn.Permissions
 	connection, requests, err := newChannel.Accept()
 	if err != nil {
 		log.Error("Could not accept channel", "error", err)
@@ -49,53 +52,100 @@ func handleSession(perms *ssh.Permissions, newChannel ssh.NewChannel) {
 	}
 	var once sync.Once
 
+	var cmd *exec.Cmd
 	var cmdf *os.File
 	hasRequestedPty := false
 	var ptyPayload []byte
+	var env []string
+	var agent *agentSocket
+	var recording *sessionRecording
 
-	execCmd := func(name string, arg ...string) error {
-		cmd := exec.Command(name, arg...)
-		username, ok := perms.CriticalOptions["user"]
-		var usr *user.User
-		if ok {
-			var err error
-			usr, err = user.Lookup(username)
+	// execCmd runs command with the login shell of the user, or the login
+	// shell itself if command is empty
+	execCmd := func(command string) error {
+		usr, err := sessionUser(perms)
+		if err != nil {
+			return err
+		}
+		credential, err := userCredential(usr)
+		if err != nil {
+			return err
+		}
+
+		root := ""
+		if s.chrootDirectory != "none" {
+			root = expandUserPath(s.chrootDirectory, usr)
+			err = checkChrootDirectory(root)
 			if err != nil {
 				return err
 			}
-		} else {
-			usr, err = user.Current()
+		}
+
+		// The server's ForceCommand takes precedence over the command option
+		// of the authorized key
+		forceCommand := s.forceCommand
+		if forceCommand == "" {
+			forceCommand = perms.CriticalOptions[forceCommandOption]
+		}
+		if forceCommand != "" {
+			env = append(env, "SSH_ORIGINAL_COMMAND="+command)
+			command = forceCommand
+		}
+
+		shell := loginShell(usr)
+		switch command {
+		case "":
+			cmd = exec.Command(shell)
+			// A leading dash makes the shell a login shell
+			cmd.Args[0] = "-" + filepath.Base(shell)
+		case internalSFTP:
+			// Run the SFTP server in a new process of this executable,
+			// so that it runs as the logged in user. With a chroot, the
+			// executable is run from inside it.
+			exe, err := os.Executable()
 			if err != nil {
 				return err
 			}
+			if root != "" {
+				if _, err := os.Stat(filepath.Join(root, exe)); err != nil {
+					return fmt.Errorf("%s is required inside ChrootDirectory %s for internal-sftp", exe, root)
+				}
+			}
+			cmd = exec.Command(exe, SFTPServerArg)
+		default:
+			cmd = exec.Command(shell, "-c", command)
 		}
-		uid, err := strconv.ParseUint(usr.Uid, 10, 32)
-		if err != nil {
-			return err
-		}
-		gid, err := strconv.ParseUint(usr.Gid, 10, 32)
-		if err != nil {
-			return err
-		}
-		if cmd.SysProcAttr == nil {
-			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Chroot:     root,
+			Credential: credential,
 		}
-		cmd.SysProcAttr.Credential = &syscall.Credential{
-			Uid: uint32(uid),
-			Gid: uint32(gid),
+		cmd.Dir = workingDirectory(root, usr.HomeDir)
+		cmd.Env = append(userEnv(usr, shell), env...)
+		if hasRequestedPty {
+			termLen := ptyPayload[3]
+			cmd.Env = append(cmd.Env, "TERM="+string(ptyPayload[4:termLen+4]))
 		}
-		close := func() {
-			cmd.Process.Kill()
+
+		start := time.Now()
+		recordingFile := ""
+		s.audit(conn, "session", func(e *auditEvent) { e.Command = command })
+
+		// finish waits for the command to end and reports its exit status
+		finish := func() {
 			err := cmd.Wait()
 			if err != nil {
-				log.Error("Error waiting for bash to end", "error", err)
+				log.Debug("Command ended", "error", err)
 			}
 
-			tb := []byte{0, 0, 0, 0}
-			_, err = connection.SendRequest("exit-status", false, tb)
-			if err != nil {
-				log.Error("Error sending exit status", "error", err)
+			sendExitStatus(connection, cmd.ProcessState)
+			if recording != nil {
+				recording.Close()
 			}
+			s.audit(conn, "session-end", func(e *auditEvent) {
+				e.Command = command
+				e.Recording = recordingFile
+				e.Duration = time.Since(start).Seconds()
+			})
 
 			once.Do(closeConn)
 
@@ -103,26 +153,48 @@ func handleSession(perms *ssh.Permissions, newChannel ssh.NewChannel) {
 		}
 
 		if hasRequestedPty {
+			termLen := ptyPayload[3]
+			w, h := parseDims(ptyPayload[termLen+4:])
+
+			var output io.Writer = connection
+			if s.sessionRecordingDirectory != "none" {
+				header := asciicastHeader{
+					Width:   w,
+					Height:  h,
+					Command: command,
+					Env: map[string]string{
+						"SHELL": shell,
+						"TERM":  string(ptyPayload[4 : termLen+4]),
+					},
+				}
+				recording, recordingFile, err = newSessionRecording(s.sessionRecordingDirectory, usr.Username, header)
+				if err != nil {
+					return fmt.Errorf("failed creating session recording: %v", err)
+				}
+				output = io.MultiWriter(connection, recording)
+			}
+
 			log.Debug("Creating pty...")
 			cmdf, err = pty.Start(cmd)
 			if err != nil {
+				if recording != nil {
+					recording.Close()
+				}
 				return err
 			}
 
-			var once sync.Once
+			// The pty is closed when the command ends, or when it is killed
+			// because the channel was closed
 			go func() {
-				_, err := io.Copy(connection, cmdf)
+				_, err := io.Copy(output, cmdf)
 				log.Debug("Pty to connection copy ended", "error", err)
-				once.Do(close)
+				finish()
 			}()
 			go func() {
 				_, err := io.Copy(cmdf, connection)
 				log.Debug("Connection to pty copy ended", "error", err)
-				once.Do(close)
 			}()
 
-			termLen := ptyPayload[3]
-			w, h := parseDims(ptyPayload[termLen+4:])
 			SetWinsize(cmdf.Fd(), w, h)
 		} else {
 			stdin, err := cmd.StdinPipe()
@@ -145,12 +217,13 @@ func handleSession(perms *ssh.Permissions, newChannel ssh.NewChannel) {
 			pipesWait.Add(2)
 			go func() {
 				pipesWait.Wait()
-				close()
+				finish()
 			}()
 
 			go func() {
 				_, err := io.Copy(stdin, connection)
 				log.Debug("Stdin copy ended", "error", err)
+				stdin.Close()
 			}()
 			go func() {
 				_, err := io.Copy(connection, stdout)
@@ -175,19 +248,34 @@ func handleSession(perms *ssh.Permissions, newChannel ssh.NewChannel) {
 	// Sessions have out-of-band requests such as "shell", "pty-req" and "exec"
 	go func() {
 		defer once.Do(closeConn)
+		defer func() {
+			// The channel was closed, stop the command if it still runs
+			if cmd != nil && cmd.Process != nil {
+				cmd.Process.Kill()
+			}
+			if agent != nil {
+				agent.Close()
+			}
+		}()
 		for req := range requests {
 			switch req.Type {
 			case "shell":
-				// TODO determine and use default shell, don't force bash
-				err := execCmd("bash")
+				err := execCmd("")
 				if err != nil {
 					log.Error("Can't create shell!", "error", err)
 				}
 
 				if req.WantReply {
-					req.Reply(true, nil)
+					req.Reply(err == nil, nil)
 				}
 			case "pty-req":
+				if _, denied := perms.Extensions[noPtyExtension]; denied {
+					log.Debug("Pty allocation disabled for this key")
+					if req.WantReply {
+						req.Reply(false, nil)
+					}
+					continue
+				}
 				hasRequestedPty = true
 				ptyPayload = req.Payload
 				if req.WantReply {
@@ -199,20 +287,85 @@ func handleSession(perms *ssh.Permissions, newChannel ssh.NewChannel) {
 				} else {
 					w, h := parseDims(req.Payload)
 					SetWinsize(cmdf.Fd(), w, h)
+					if recording != nil {
+						recording.resize(w, h)
+					}
 					if req.WantReply {
 						req.Reply(true, nil)
 					}
 				}
 			case "exec":
-				cmdStrLen := binary.BigEndian.Uint32(req.Payload[0:4])
-				cmdStr := string(req.Payload[4 : cmdStrLen+4])
-				err := execCmd("bash", "-c", cmdStr)
+				var payload execRequest
+				err := ssh.Unmarshal(req.Payload, &payload)
+				if err == nil {
+					err = execCmd(payload.Command)
+				}
 				if err != nil {
 					log.Error("Can't create shell!", "error", err)
 				}
 
 				if req.WantReply {
-					req.Reply(true, nil)
+					req.Reply(err == nil, nil)
+				}
+			case "subsystem":
+				var payload subsystemRequest
+				if err := ssh.Unmarshal(req.Payload, &payload); err != nil || payload.Name != "sftp" {
+					log.Debug("Unknown subsystem", "name", payload.Name)
+					if req.WantReply {
+						req.Reply(false, nil)
+					}
+					continue
+				}
+				err := execCmd(internalSFTP)
+				if err != nil {
+					log.Error("Can't start SFTP server!", "error", err)
+				}
+
+				if req.WantReply {
+					req.Reply(err == nil, nil)
+				}
+			case "env":
+				var payload envRequest
+				err := ssh.Unmarshal(req.Payload, &payload)
+				accepted := err == nil && cmd == nil && s.acceptsEnv(payload.Name)
+				if accepted {
+					env = append(env, payload.Name+"="+payload.Value)
+				} else {
+					log.Debug("Rejected environment variable", "name", payload.Name)
+				}
+
+				if req.WantReply {
+					req.Reply(accepted, nil)
+				}
+			case agentRequestType:
+				var err error
+				if agent == nil {
+					agent, err = s.forwardAgent(conn)
+				}
+				if err == nil {
+					env = append(env, "SSH_AUTH_SOCK="+agent.path())
+				} else {
+					log.Debug("Rejected agent forwarding", "error", err)
+				}
+
+				if req.WantReply {
+					req.Reply(err == nil, nil)
+				}
+			case "signal":
+				var payload signalRequest
+				err := ssh.Unmarshal(req.Payload, &payload)
+				sig, known := signals[payload.Signal]
+				if err == nil && known && cmd != nil && cmd.Process != nil {
+					err = cmd.Process.Signal(sig)
+				} else if err == nil {
+					err = fmt.Errorf("can't deliver signal %q", payload.Signal)
+				}
+				if err != nil {
+					log.Debug("Can't deliver signal", "error", err)
+				}
+
+				if req.WantReply {
+					req.Reply(err == nil, nil)
 				}
 			default:
 				log.Debug("Unknown session request type %s", req.Type)
@@ -221,6 +374,83 @@ func handleSession(perms *ssh.Permissions, newChannel ssh.NewChannel) {
 	}()
 }
 
+type execRequest struct {
+	Command string
+}
+
+type subsystemRequest struct {
+	Name string
+}
+
+type envRequest struct {
+	Name  string
+	Value string
+}
+
+type signalRequest struct {
+	Signal string
+}
+
+type exitStatusMsg struct {
+	Status uint32
+}
+
+type exitSignalMsg struct {
+	Signal     string
+	CoreDumped bool
+	Error      string
+	Lang       string
+}
+
+// signals maps the signal names of RFC 4254, section 6.10 to signals.
+var signals = map[string]syscall.Signal{
+	"ABRT": syscall.SIGABRT,
+	"ALRM": syscall.SIGALRM,
+	"FPE":  syscall.SIGFPE,
+	"HUP":  syscall.SIGHUP,
+	"ILL":  syscall.SIGILL,
+	"INT":  syscall.SIGINT,
+	"KILL": syscall.SIGKILL,
+	"PIPE": syscall.SIGPIPE,
+	"QUIT": syscall.SIGQUIT,
+	"SEGV": syscall.SIGSEGV,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// sendExitStatus sends the exit status of an ended command to the client, as
+// exit-signal if it was killed by a signal and as exit-status otherwise.
+func sendExitStatus(connection ssh.Channel, state *os.ProcessState) {
+	var err error
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		msg := exitSignalMsg{
+			Signal:     signalName(status.Signal()),
+			CoreDumped: status.CoreDump(),
+		}
+		_, err = connection.SendRequest("exit-signal", false, ssh.Marshal(&msg))
+	} else {
+		msg := exitStatusMsg{
+			Status: uint32(state.ExitCode()),
+		}
+		_, err = connection.SendRequest("exit-status", false, ssh.Marshal(&msg))
+	}
+	if err != nil {
+		log.Debug("Error sending exit status", "error", err)
+	}
+}
+
+// signalName returns the name of sig without the SIG prefix, as used in
+// exit-signal messages.
+func signalName(sig syscall.Signal) string {
+	for name, s := range signals {
+		if s == sig {
+			return name
+		}
+	}
+	return strconv.Itoa(int(sig))
+}
+
 // parseDims extracts terminal dimensions (width x height) from the provided buffer.
 func parseDims(b []byte) (uint32, uint32) {
 	w := binary.BigEndian.Uint32(b)
@@ -0,0 +1,46 @@
+// Copyright 2020 ETH Zurich
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//   http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ssh
+
+import (
+	"io"
+	"os"
+
+	"github.com/pkg/sftp"
+)
+
+// SFTPServerArg is the only argument passed to the server executable to run
+// it as SFTP server for a session, see ServeSFTP.
+const SFTPServerArg = "--internal-sftp"
+
+// internalSFTP is the command that runs the SFTP server, e.g. as ForceCommand.
+const internalSFTP = "internal-sftp"
+
+// ServeSFTP serves the SFTP protocol on stdin and stdout, until the client
+// closes the session.
+func ServeSFTP() error {
+	server, err := sftp.NewServer(struct {
+		io.Reader
+		io.WriteCloser
+	}{os.Stdin, os.Stdout})
+	if err != nil {
+		return err
+	}
+	err = server.Serve()
+	if err == io.EOF {
+		return nil
+	}
+	return err
+}
@@ -18,7 +18,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
-	"strconv"
+	"time"
 
 	log "github.com/inconshreveable/log15"
 
@@ -29,11 +29,29 @@ import (
 )
 
 // ChannelHandlerFunction is a type for channel handlers, such as terminal sessions, tunnels, or X11 forwarding.
-type ChannelHandlerFunction func(perms *ssh.Permissions, newChannel ssh.NewChannel)
+type ChannelHandlerFunction func(conn *ssh.ServerConn, newChannel ssh.NewChannel)
 
 // Server is a struct containing information about SSH servers.
 type Server struct {
 	authorizedKeysFile string
+	gatewayPorts       bool
+	acceptEnv          []string
+	forceCommand       string
+	chrootDirectory    string
+
+	allowAgentForwarding bool
+
+	trustedUserCAKeys        []ssh.PublicKey
+	authorizedPrincipalsFile string
+
+	authenticationMethods [][]string
+	authProgress          *authProgress
+
+	auditLog                  *auditLog
+	sessionRecordingDirectory string
+
+	limits         *connectionLimits
+	loginGraceTime time.Duration
 
 	configuration *ssh.ServerConfig
 
@@ -43,17 +61,72 @@ type Server struct {
 // Create creates a new unconnected Server object.
 func Create(config *serverconfig.ServerConfig, version string) (*Server, error) {
 	server := &Server{
-		authorizedKeysFile: config.AuthorizedKeysFile,
-		channelHandlers:    make(map[string]ChannelHandlerFunction),
+		authorizedKeysFile:        config.AuthorizedKeysFile,
+		gatewayPorts:              config.GatewayPorts == "yes",
+		acceptEnv:                 parseAcceptEnv(config.AcceptEnv),
+		forceCommand:              config.ForceCommand,
+		chrootDirectory:           config.ChrootDirectory,
+		authorizedPrincipalsFile:  config.AuthorizedPrincipalsFile,
+		allowAgentForwarding:      config.AllowAgentForwarding == "yes",
+		sessionRecordingDirectory: config.SessionRecordingDirectory,
+		authProgress:              newAuthProgress(),
+		limits:                    newConnectionLimits(),
+		channelHandlers:           make(map[string]ChannelHandlerFunction),
+	}
+
+	if config.TrustedUserCAKeys != "none" {
+		keys, err := loadPublicKeys(utils.ParsePath(config.TrustedUserCAKeys))
+		if err != nil {
+			return nil, fmt.Errorf("failed loading trusted user CA keys: %v", err)
+		}
+		server.trustedUserCAKeys = keys
 	}
 
-	maxAuthTries, _ := strconv.Atoi(config.MaxAuthTries)
+	if config.AuditLog != "none" {
+		auditLog, err := openAuditLog(utils.ParsePath(config.AuditLog))
+		if err != nil {
+			return nil, fmt.Errorf("failed opening audit log: %v", err)
+		}
+		server.auditLog = auditLog
+	}
+
+	err := server.limits.configure(config)
+	if err != nil {
+		return nil, err
+	}
+	server.loginGraceTime = config.LoginGraceTime
+
 	server.configuration = &ssh.ServerConfig{
-		PasswordCallback:  server.PasswordAuth,
-		PublicKeyCallback: server.PublicKeyAuth,
-		MaxAuthTries:      maxAuthTries,
+		MaxAuthTries: config.MaxAuthTries,
 		//ServerVersion: fmt.Sprintf("SCION-ssh-server-v%s", version),
 	}
+	server.configuration.AuthLogCallback = server.logAuth
+	enabledMethods := make(map[string]bool)
+	if config.PasswordAuthentication == "yes" {
+		server.configuration.PasswordCallback = server.PasswordAuth
+		enabledMethods[passwordMethod] = true
+	}
+	if config.PubkeyAuthentication == "yes" {
+		server.configuration.PublicKeyCallback = server.PublicKeyAuth
+		enabledMethods[publicKeyMethod] = true
+	}
+	if config.KbdInteractiveAuthentication == "yes" {
+		server.configuration.KeyboardInteractiveCallback = server.KeyboardInteractiveAuth
+		enabledMethods[keyboardInteractiveMethod] = true
+	}
+
+	authMethods, err := parseAuthenticationMethods(config.AuthenticationMethods)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AuthenticationMethods: %v", err)
+	}
+	for _, list := range authMethods {
+		for _, method := range list {
+			if !enabledMethods[method] {
+				return nil, fmt.Errorf("invalid AuthenticationMethods: %s authentication is disabled", method)
+			}
+		}
+	}
+	server.authenticationMethods = authMethods
 
 	privateBytes, err := ioutil.ReadFile(utils.ParsePath(config.HostKey))
 	if err != nil {
@@ -65,44 +138,96 @@ func Create(config *serverconfig.ServerConfig, version string) (*Server, error)
 	}
 	server.configuration.AddHostKey(private)
 
-	server.channelHandlers["session"] = handleSession
-	server.channelHandlers["direct-tcpip"] = handleTCPTunnel
-	server.channelHandlers["direct-scionquic"] = handleSCIONQUICTunnel
+	if config.HostCertificate != "none" {
+		certBytes, err := ioutil.ReadFile(utils.ParsePath(config.HostCertificate))
+		if err != nil {
+			return nil, fmt.Errorf("failed loading host certificate: %v", err)
+		}
+		pubKey, _, _, _, err := ssh.ParseAuthorizedKey(certBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed parsing host certificate: %v", err)
+		}
+		cert, ok := pubKey.(*ssh.Certificate)
+		if !ok {
+			return nil, fmt.Errorf("host certificate %s is not a certificate", config.HostCertificate)
+		}
+		certSigner, err := ssh.NewCertSigner(cert, private)
+		if err != nil {
+			return nil, fmt.Errorf("host certificate does not match host key: %v", err)
+		}
+		server.configuration.AddHostKey(certSigner)
+	}
+
+	server.channelHandlers["session"] = server.handleSession
+	server.channelHandlers["direct-tcpip"] = server.handleTCPTunnel
+	server.channelHandlers["direct-scionquic"] = server.handleSCIONQUICTunnel
 
 	return server, nil
 }
 
-func (s *Server) handleChannels(perms *ssh.Permissions, chans <-chan ssh.NewChannel) {
+func (s *Server) handleChannels(conn *ssh.ServerConn, chans <-chan ssh.NewChannel) {
 	// Service the incoming Channel channel in go routine
 	for newChannel := range chans {
-		go s.handleChannel(perms, newChannel)
+		go s.handleChannel(conn, newChannel)
 	}
 }
 
-func (s *Server) handleChannel(perms *ssh.Permissions, newChannel ssh.NewChannel) {
+func (s *Server) handleChannel(conn *ssh.ServerConn, newChannel ssh.NewChannel) {
 	if handler, exists := s.channelHandlers[newChannel.ChannelType()]; exists {
-		handler(perms, newChannel)
+		handler(conn, newChannel)
 	} else {
 		newChannel.Reject(ssh.UnknownChannelType, fmt.Sprintf("unknown channel type: %s", newChannel.ChannelType()))
 		return
 	}
 }
 
-// HandleConnection handles a client connection.
-func (s *Server) HandleConnection(conn net.Conn) error {
+// Admit checks whether a new connection from addr is within the connection
+// limits of the server. Admitted connections must be passed to
+// HandleConnection, or closed if that is not possible.
+func (s *Server) Admit(addr net.Addr) (*Admission, error) {
+	return s.limits.admit(addr)
+}
+
+// HandleConnection handles a client connection, which was admitted by Admit.
+func (s *Server) HandleConnection(conn net.Conn, admission *Admission) error {
+	defer admission.Close()
+
 	log.Debug("Handling new connection")
+	// Unauthenticated connections count against MaxStartups, so they may
+	// not stay forever
+	var timer *time.Timer
+	if s.loginGraceTime > 0 {
+		timer = time.AfterFunc(s.loginGraceTime, func() {
+			log.Debug("Login grace time exceeded", "remoteAddress", conn.RemoteAddr())
+			conn.Close()
+		})
+	}
 	sshConn, chans, reqs, err := ssh.NewServerConn(conn, s.configuration)
+	if timer != nil {
+		timer.Stop()
+	}
 	if err != nil {
 		log.Error("Failed to create new connection", "error", err)
 		conn.Close()
 		return err
 	}
+	admission.authenticated()
 
 	log.Debug("New SSH connection", "remoteAddress", sshConn.RemoteAddr(), "clientVersion", sshConn.ClientVersion())
-	// Discard all global out-of-band Requests
-	go ssh.DiscardRequests(reqs)
+	start := time.Now()
+	s.audit(sshConn, "login", nil)
+	// Serve remote forwarding requests, reject all other global requests
+	usr, err := sessionUser(sshConn.Permissions)
+	privileged := err == nil && usr.Uid == "0"
+	forwardings := newForwardings(sshConn, s.gatewayPorts, permitsPortForwarding(sshConn.Permissions), privileged)
+	forwardings.onStart = func(forward string) {
+		s.audit(sshConn, "remote-forward", func(e *auditEvent) { e.Forward = forward })
+	}
+	go forwardings.handleRequests(reqs)
 	// Accept all channels
-	s.handleChannels(sshConn.Permissions, chans)
+	s.handleChannels(sshConn, chans)
+	forwardings.closeAll()
+	s.audit(sshConn, "logout", func(e *auditEvent) { e.Duration = time.Since(start).Seconds() })
 
 	return nil
 }
@@ -45,11 +45,26 @@ func handleTunnelForRemoteConnection(connection ssh.Channel, remoteConnection ne
 	}()
 }
 
-func handleTCPTunnel(perms *ssh.Permissions, newChannel ssh.NewChannel) {
+// permitsPortForwarding returns whether the authenticated user may forward
+// ports.
+func permitsPortForwarding(perms *ssh.Permissions) bool {
+	_, denied := perms.Extensions[noPortForwardingExtension]
+	return !denied
+}
+
+func (s *Server) handleTCPTunnel(conn *ssh.ServerConn, newChannel ssh.NewChannel) {
+	if !permitsPortForwarding(conn.Permissions) {
+		newChannel.Reject(ssh.Prohibited, "port forwarding is disabled")
+		return
+	}
+
 	extraData := newChannel.ExtraData()
 	addressLen := binary.BigEndian.Uint32(extraData[0:4])
 	address := string(extraData[4 : addressLen+4])
 	port := binary.BigEndian.Uint32(extraData[addressLen+4 : addressLen+8])
+	s.audit(conn, "local-forward", func(e *auditEvent) {
+		e.Forward = fmt.Sprintf("%s %s:%d", newChannel.ChannelType(), address, port)
+	})
 
 	connection, requests, err := newChannel.Accept()
 	if err != nil {
@@ -68,10 +83,18 @@ func handleTCPTunnel(perms *ssh.Permissions, newChannel ssh.NewChannel) {
 	handleTunnelForRemoteConnection(connection, remoteConnection)
 }
 
-func handleSCIONQUICTunnel(perms *ssh.Permissions, newChannel ssh.NewChannel) {
+func (s *Server) handleSCIONQUICTunnel(conn *ssh.ServerConn, newChannel ssh.NewChannel) {
+	if !permitsPortForwarding(conn.Permissions) {
+		newChannel.Reject(ssh.Prohibited, "port forwarding is disabled")
+		return
+	}
+
 	extraData := newChannel.ExtraData()
 	addressLen := binary.BigEndian.Uint32(extraData[0:4])
 	address := string(extraData[4 : addressLen+4])
+	s.audit(conn, "local-forward", func(e *auditEvent) {
+		e.Forward = fmt.Sprintf("%s %s", newChannel.ChannelType(), address)
+	})
 
 	connection, requests, err := newChannel.Accept()
 	if err != nil {
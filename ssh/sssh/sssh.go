@@ -16,7 +16,11 @@ package sssh
 
 import (
 	"errors"
+	"fmt"
+	"io"
 	"net"
+	"strings"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -64,6 +68,41 @@ func newSSHClient(transportStream net.Conn, config *ssh.ClientConfig) (*ssh.Clie
 	return ssh.NewClient(conn, nc, rc), nil
 }
 
+// NewClient starts a client connection to the SSH server at addr over an
+// established transport, such as a channel of a jump host or the standard
+// input and output of a proxy command. addr is used to verify the host key.
+func NewClient(transport net.Conn, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	network := "tcp"
+	if strings.Contains(addr, ",") {
+		network = "scion"
+	}
+	return newSSHClient(&proxiedConn{transport, proxiedAddr{network, addr}}, config)
+}
+
+// proxiedConn is a transport to a server that is not connected directly, with
+// the address of the server as remote address.
+type proxiedConn struct {
+	net.Conn
+	remote proxiedAddr
+}
+
+func (c *proxiedConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+type proxiedAddr struct {
+	network string
+	address string
+}
+
+func (a proxiedAddr) Network() string {
+	return a.network
+}
+
+func (a proxiedAddr) String() string {
+	return a.address
+}
+
 // TunnelDialSCION creates a tunnel using the given SSH client.
 func TunnelDialSCION(client *ssh.Client, addr string) (net.Conn, error) {
 	openChannelData := directSCIONData{
@@ -86,6 +125,181 @@ type directSCIONData struct {
 	addr string
 }
 
+// TunnelListenSCION requests the server to listen for QUIC sessions on the
+// given port of its SCION address and returns a listener for the connections
+// forwarded over the given SSH client. If port is 0, the server chooses one.
+func TunnelListenSCION(client *ssh.Client, port uint16) (net.Listener, error) {
+	forwards := scionForwardsFor(client)
+
+	req := scionForwardData{
+		BindPort: uint32(port),
+	}
+	ok, resp, err := client.SendRequest("scionquic-forward", true, ssh.Marshal(&req))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("scion-ssh: scionquic-forward request denied by peer")
+	}
+	if port == 0 {
+		var p struct {
+			Port uint32
+		}
+		if err := ssh.Unmarshal(resp, &p); err != nil {
+			return nil, err
+		}
+		req.BindPort = p.Port
+	}
+
+	return forwards.add(req), nil
+}
+
+type scionForwardData struct {
+	BindAddr string
+	BindPort uint32
+}
+
+type forwardedSCIONData struct {
+	Addr       string
+	Port       uint32
+	OriginAddr string
+	OriginPort uint32
+}
+
+var (
+	scionForwardsMutex sync.Mutex
+	scionForwardsMap   = make(map[*ssh.Client]*scionForwards)
+)
+
+// scionForwards dispatches the forwarded-scionquic channels of a client to
+// its listeners, by port.
+type scionForwards struct {
+	client *ssh.Client
+
+	mutex     sync.Mutex
+	listeners map[uint32]*scionListener
+}
+
+func scionForwardsFor(client *ssh.Client) *scionForwards {
+	scionForwardsMutex.Lock()
+	defer scionForwardsMutex.Unlock()
+	if f, exists := scionForwardsMap[client]; exists {
+		return f
+	}
+	f := &scionForwards{
+		client:    client,
+		listeners: make(map[uint32]*scionListener),
+	}
+	scionForwardsMap[client] = f
+	go f.handleChannels(client.HandleChannelOpen("forwarded-scionquic"))
+	return f
+}
+
+func (f *scionForwards) add(req scionForwardData) *scionListener {
+	l := &scionListener{
+		forwards: f,
+		req:      req,
+		in:       make(chan ssh.NewChannel),
+		closed:   make(chan struct{}),
+	}
+	f.mutex.Lock()
+	f.listeners[req.BindPort] = l
+	f.mutex.Unlock()
+	return l
+}
+
+func (f *scionForwards) remove(l *scionListener) {
+	f.mutex.Lock()
+	if f.listeners[l.req.BindPort] == l {
+		delete(f.listeners, l.req.BindPort)
+	}
+	f.mutex.Unlock()
+}
+
+func (f *scionForwards) handleChannels(in <-chan ssh.NewChannel) {
+	for ch := range in {
+		var data forwardedSCIONData
+		if err := ssh.Unmarshal(ch.ExtraData(), &data); err != nil {
+			ch.Reject(ssh.ConnectionFailed, "could not parse forwarded-scionquic payload: "+err.Error())
+			continue
+		}
+		f.mutex.Lock()
+		l, exists := f.listeners[data.Port]
+		f.mutex.Unlock()
+		if !exists || !l.deliver(ch) {
+			ch.Reject(ssh.Prohibited, fmt.Sprintf("no forward for port %d", data.Port))
+		}
+	}
+
+	// The connection is closed
+	f.mutex.Lock()
+	for _, l := range f.listeners {
+		l.closeOnce.Do(func() { close(l.closed) })
+	}
+	f.listeners = nil
+	f.mutex.Unlock()
+	scionForwardsMutex.Lock()
+	delete(scionForwardsMap, f.client)
+	scionForwardsMutex.Unlock()
+}
+
+// scionListener is a net.Listener for connections forwarded by the server.
+type scionListener struct {
+	forwards *scionForwards
+	req      scionForwardData
+
+	in        chan ssh.NewChannel
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (l *scionListener) deliver(ch ssh.NewChannel) bool {
+	select {
+	case l.in <- ch:
+		return true
+	case <-l.closed:
+		return false
+	}
+}
+
+// Accept waits for and returns the next forwarded connection.
+func (l *scionListener) Accept() (net.Conn, error) {
+	select {
+	case ch := <-l.in:
+		c, requests, err := ch.Accept()
+		if err != nil {
+			return nil, err
+		}
+		go ssh.DiscardRequests(requests)
+		return &chanConn{c}, nil
+	case <-l.closed:
+		return nil, io.EOF
+	}
+}
+
+// Close closes the listener and cancels the forwarding on the server.
+func (l *scionListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closed)
+		l.forwards.remove(l)
+		var ok bool
+		ok, _, err = l.forwards.client.SendRequest("cancel-scionquic-forward", true, ssh.Marshal(&l.req))
+		if err == nil && !ok {
+			err = errors.New("scion-ssh: cancel-scionquic-forward failed")
+		}
+	})
+	return err
+}
+
+// Addr returns the port forwarded by the server.
+func (l *scionListener) Addr() net.Addr {
+	return &net.TCPAddr{
+		IP:   net.IPv4zero,
+		Port: int(l.req.BindPort),
+	}
+}
+
 // chanConn fulfills the net.Conn interface without having to hold laddr or raddr.
 type chanConn struct {
 	ssh.Channel